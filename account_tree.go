@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Accounts form a tree through their Parent field, e.g. Groceries under
+// Food. A child has the same type as its parent; its own Amount and Budget
+// stay its own, and rollups add every descendant into the ancestors.
+
+// AccountNode is an account in the tree returned by /api/accounts/tree
+type AccountNode struct {
+	Account
+	Path        string         `json:"path"`        // e.g. "Food:Groceries"
+	Total       Money          `json:"total"`       // Amount including children, in the base currency
+	BudgetTotal Money          `json:"budgetTotal"` // Budget including children, in the base currency
+	Children    []*AccountNode `json:"children"`
+}
+
+// validateAccountParent checks that acc (currently named oldName, or new
+// when oldName is "") can hang under its parent without forming a cycle
+func validateAccountParent(accounts []Account, acc Account, oldName string) error {
+	if acc.Parent == "" {
+		return nil
+	}
+
+	if acc.Parent == acc.Name || acc.Parent == oldName {
+		return errors.New("an account cannot be its own parent")
+	}
+
+	parent := findAccount(accounts, acc.Parent)
+	if parent.Name == "" {
+		return fmt.Errorf("unknown parent account: %s", acc.Parent)
+	}
+	if parent.Type != acc.Type {
+		return errors.New("parent account must have the same type")
+	}
+
+	// Walk up from the parent; meeting the account again means a cycle
+	seen := map[string]bool{}
+	for name := parent.Parent; name != ""; name = findAccount(accounts, name).Parent {
+		if name == oldName || name == acc.Name {
+			return errors.New("parent would create a cycle")
+		}
+		if seen[name] {
+			break
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// reparentChildren moves the children of name under newParent
+func reparentChildren(accounts []Account, name, newParent string) {
+	for i := range accounts {
+		if accounts[i].Parent == name {
+			accounts[i].Parent = newParent
+		}
+	}
+}
+
+// rollupAccounts adds each account's value into all of its ancestors and
+// returns the subtree totals by account name
+func rollupAccounts(accounts []Account, values map[string]Money) map[string]Money {
+	totals := make(map[string]Money, len(accounts))
+	for _, acc := range accounts {
+		v := values[acc.Name]
+		totals[acc.Name] += v
+
+		seen := map[string]bool{acc.Name: true}
+		for name := acc.Parent; name != "" && !seen[name]; name = findAccount(accounts, name).Parent {
+			seen[name] = true
+			totals[name] += v
+		}
+	}
+	return totals
+}
+
+// accountPath returns the colon separated path from the root, e.g.
+// "Food:Groceries"
+func accountPath(accounts []Account, name string) string {
+	path := []string{name}
+	seen := map[string]bool{name: true}
+	for parent := findAccount(accounts, name).Parent; parent != "" && !seen[parent]; parent = findAccount(accounts, parent).Parent {
+		seen[parent] = true
+		path = append([]string{parent}, path...)
+	}
+	return strings.Join(path, ":")
+}
+
+// buildAccountTree arranges accounts into trees, one root per top-level
+// account, ordered by type and then name
+func buildAccountTree(accounts []Account, conv *Converter) []*AccountNode {
+	amounts := baseAmounts(accounts, conv, func(a Account) Money { return a.Amount })
+	budgets := baseAmounts(accounts, conv, func(a Account) Money { return a.Budget })
+	totals := rollupAccounts(accounts, amounts)
+	budgetTotals := rollupAccounts(accounts, budgets)
+
+	nodes := make(map[string]*AccountNode, len(accounts))
+	for _, acc := range accounts {
+		nodes[acc.Name] = &AccountNode{
+			Account:     acc,
+			Path:        accountPath(accounts, acc.Name),
+			Total:       totals[acc.Name],
+			BudgetTotal: budgetTotals[acc.Name],
+			Children:    []*AccountNode{},
+		}
+	}
+
+	typeOrder := map[string]int{"ASSET": 0, "LIABILITIES": 1, "INCOME": 2, "EXPENSE": 3}
+	sorted := append([]Account(nil), accounts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Type != sorted[j].Type {
+			return typeOrder[sorted[i].Type] < typeOrder[sorted[j].Type]
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	roots := []*AccountNode{}
+	for _, acc := range sorted {
+		node := nodes[acc.Name]
+		if parent, ok := nodes[acc.Parent]; ok && acc.Parent != acc.Name {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// baseAmounts maps each account to a value converted into the base currency
+// at today's rate, ready for rollupAccounts
+func baseAmounts(accounts []Account, conv *Converter, value func(Account) Money) map[string]Money {
+	asOf := time.Now().Format("02-01-2006")
+	amounts := make(map[string]Money, len(accounts))
+	for _, acc := range accounts {
+		amounts[acc.Name] = conv.ToBase(value(acc), accountCurrency(acc, conv.Base), asOf)
+	}
+	return amounts
+}
+
+// portfolioAccounts returns the open top-level assets in net worth with
+// their children rolled in, at market value in the base currency, for the
+// dashboard portfolio chart
+func portfolioAccounts(accounts []Account, conv *Converter) []Account {
+	totals := rollupAccounts(accounts, baseAmounts(accounts, conv, Account.Value))
+
+	portfolio := []Account{}
+	for _, acc := range accounts {
+		if acc.Type == "ASSET" && acc.IINW == "Yes" && acc.Parent == "" && !acc.Closed() {
+			acc.Amount = totals[acc.Name]
+			acc.Currency = conv.Base
+			portfolio = append(portfolio, acc)
+		}
+	}
+	return portfolio
+}
+
+func handleAccountTree(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	accounts, err := readAccounts()
+	if err != nil {
+		respondError(w, "Failed to load accounts", http.StatusInternalServerError)
+		return
+	}
+
+	budgets, err := readBudgets()
+	if err != nil {
+		log.Printf("Error reading budgets: %v", err)
+	}
+	applyBudgets(accounts, budgets, time.Now().Format("01-2006"))
+
+	conv, err := loadConverter()
+	if err != nil {
+		log.Printf("Error reading exchange rates: %v", err)
+	}
+
+	json.NewEncoder(w).Encode(buildAccountTree(accounts, conv))
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Budgets are kept per account and month in budgets.csv, so changing a
+// budget does not rewrite how earlier months look. A row sets the
+// account's budget from its month on, until the account's next row; a row
+// marked Once overrides only its own month. Months before an account's
+// first row use the Budget stored on the account.
+
+var budgetHeader = []string{"Account", "Month", "Amount", "Once"}
+
+var budgetsMutex sync.Mutex
+
+// BudgetPlan is everything besides transactions that budgets are worked
+// out from
+type BudgetPlan struct {
+	Entries         []BudgetEntry
+	Allocations     []Allocation // moves between envelopes, see envelopes.go
+	EnvelopeStart   string       // MM-YYYY; empty when envelope budgeting is off
+	FiscalYearStart time.Month
+}
+
+// loadBudgetPlan reads the budget history, the allocation ledger and the
+// settings they depend on. The settings are filled in even if reading the
+// tables fails.
+func loadBudgetPlan() (BudgetPlan, error) {
+	plan := BudgetPlan{EnvelopeStart: getSetting("envelopeStart"), FiscalYearStart: fiscalYearStart()}
+	var err error
+	if plan.Entries, err = readBudgets(); err != nil {
+		return plan, err
+	}
+	plan.Allocations, err = readAllocations()
+	return plan, err
+}
+
+// BudgetEntry is one row of budget history
+type BudgetEntry struct {
+	Account string `json:"account"`
+	Month   string `json:"month"` // MM-YYYY
+	Amount  Money  `json:"amount"`
+	Once    bool   `json:"once"` // this month only, instead of carrying forward
+}
+
+func readBudgets() ([]BudgetEntry, error) {
+	rows, cols, err := store.ReadTable("budgets")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []BudgetEntry
+	for _, record := range rows {
+		amount, err := ParseMoney(columnValue(record, cols, "Amount"))
+		if err != nil {
+			log.Printf("Skipping budget for %s: %v", columnValue(record, cols, "Account"), err)
+			continue
+		}
+		entries = append(entries, BudgetEntry{
+			Account: columnValue(record, cols, "Account"),
+			Month:   columnValue(record, cols, "Month"),
+			Amount:  amount,
+			Once:    columnValue(record, cols, "Once") == "Yes",
+		})
+	}
+	return entries, nil
+}
+
+// writeBudgets saves the entries ordered by account and month
+func writeBudgets(entries []BudgetEntry) error {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Account != entries[j].Account {
+			return entries[i].Account < entries[j].Account
+		}
+		return monthKey(entries[i].Month) < monthKey(entries[j].Month)
+	})
+
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		once := "No"
+		if e.Once {
+			once = "Yes"
+		}
+		rows = append(rows, []string{e.Account, e.Month, e.Amount.String(), once})
+	}
+	return store.WriteTable("budgets", budgetHeader, rows)
+}
+
+// monthKey turns MM-YYYY into YYYYMM for comparisons
+func monthKey(month string) string {
+	if len(month) < 7 {
+		return month
+	}
+	return month[3:7] + month[0:2]
+}
+
+// isValidMonth checks for MM-YYYY
+func isValidMonth(month string) bool {
+	_, err := time.Parse("01-2006", month)
+	return err == nil && len(month) == 7
+}
+
+// budgetFor returns an account's budget in a month: its override for that
+// month, else its latest row at or before the month, else the budget on
+// the account
+func budgetFor(acc Account, entries []BudgetEntry, month string) Money {
+	key := monthKey(month)
+	budget, latest := acc.Budget, ""
+	for _, e := range entries {
+		if e.Account != acc.Name {
+			continue
+		}
+		if e.Once {
+			if e.Month == month {
+				return e.Amount
+			}
+			continue
+		}
+		if k := monthKey(e.Month); k <= key && k >= latest {
+			budget, latest = e.Amount, k
+		}
+	}
+	return budget
+}
+
+// applyBudgets sets each expense account's Budget to its budget in month,
+// for showing accounts with the budget that applies now
+func applyBudgets(accounts []Account, entries []BudgetEntry, month string) {
+	for i := range accounts {
+		if accounts[i].Type == "EXPENSE" {
+			accounts[i].Budget = budgetFor(accounts[i], entries, month)
+		}
+	}
+}
+
+// setBudget adds an entry, replacing the account's entry of the same kind
+// for the same month
+func setBudget(entries []BudgetEntry, entry BudgetEntry) []BudgetEntry {
+	for i, e := range entries {
+		if e.Account == entry.Account && e.Month == entry.Month && e.Once == entry.Once {
+			entries[i] = entry
+			return entries
+		}
+	}
+	return append(entries, entry)
+}
+
+// saveAccountBudget records a budget changed on the account form as
+// carrying forward from the current month, leaving earlier months alone
+func saveAccountBudget(acc Account, budget Money) error {
+	month := time.Now().Format("01-2006")
+
+	budgetsMutex.Lock()
+	defer budgetsMutex.Unlock()
+
+	entries, err := readBudgets()
+	if err != nil {
+		return err
+	}
+	if budgetFor(acc, entries, month) == budget {
+		return nil
+	}
+	return writeBudgets(setBudget(entries, BudgetEntry{Account: acc.Name, Month: month, Amount: budget}))
+}
+
+func validateBudgetEntry(e *BudgetEntry) error {
+	e.Account = sanitizeInput(e.Account)
+	e.Month = sanitizeInput(e.Month)
+
+	if !isValidMonth(e.Month) {
+		return errors.New("invalid month format (use MM-YYYY)")
+	}
+	if e.Amount < 0 || e.Amount > MAX_AMOUNT {
+		return errors.New("amount out of range")
+	}
+
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	acc := findAccount(accounts, e.Account)
+	if acc.Name == "" {
+		return fmt.Errorf("unknown account: %s", e.Account)
+	}
+	if acc.Type != "EXPENSE" {
+		return errors.New("budgets are for expense accounts")
+	}
+	return nil
+}
+
+// handleBudgets shows a month's budget against spending and edits the
+// budget history:
+// GET /api/budgets?month=09-2026
+// PUT /api/budgets {"account": "Food", "month": "09-2026", "amount": 600, "once": true}
+// DELETE /api/budgets {"account": "Food", "month": "09-2026", "once": true}
+func handleBudgets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		month := r.URL.Query().Get("month")
+		if month == "" {
+			month = time.Now().Format("01-2006")
+		} else if !isValidMonth(month) {
+			respondError(w, "invalid month format (use MM-YYYY)", http.StatusBadRequest)
+			return
+		}
+
+		transactions, accounts, _, conv, err := loadReportData()
+		if err != nil {
+			respondError(w, "Failed to load data", http.StatusInternalServerError)
+			return
+		}
+		plan, err := loadBudgetPlan()
+		if err != nil {
+			respondError(w, "Failed to load budgets", http.StatusInternalServerError)
+			return
+		}
+		entries := plan.Entries
+		if entries == nil {
+			entries = []BudgetEntry{}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"month":        month,
+			"baseCurrency": conv.Base,
+			"budget":       calculateBudget(transactions, accounts, plan, month, conv),
+			"entries":      entries,
+		})
+
+	case http.MethodPut, http.MethodDelete:
+		var entry BudgetEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+		if err := validateBudgetEntry(&entry); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		budgetsMutex.Lock()
+		defer budgetsMutex.Unlock()
+
+		entries, err := readBudgets()
+		if err != nil {
+			respondError(w, "Failed to load budgets", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			entries = setBudget(entries, entry)
+		} else {
+			kept := entries[:0]
+			for _, e := range entries {
+				if e.Account != entry.Account || e.Month != entry.Month || e.Once != entry.Once {
+					kept = append(kept, e)
+				}
+			}
+			if len(kept) == len(entries) {
+				respondError(w, "Budget not found", http.StatusNotFound)
+				return
+			}
+			entries = kept
+		}
+
+		if err := writeBudgets(entries); err != nil {
+			respondError(w, "Failed to save budgets", http.StatusInternalServerError)
+			return
+		}
+
+		logSecurityEvent("BUDGET_UPDATE", getClientIP(r), fmt.Sprintf("%s budget for %s from %s", r.Method, entry.Account, entry.Month))
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
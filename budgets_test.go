@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestBudgetFor(t *testing.T) {
+	food := Account{Name: "Food", Type: "EXPENSE", Budget: 100000}
+	entries := []BudgetEntry{
+		{Account: "Food", Month: "03-2026", Amount: 200000},
+		{Account: "Food", Month: "12-2025", Amount: 150000},
+		{Account: "Food", Month: "05-2026", Amount: 500000, Once: true},
+		{Account: "Food", Month: "07-2026", Amount: 0},
+		{Account: "Food", Month: "09-2026", Amount: 250000},
+		{Account: "Food", Month: "09-2026", Amount: 50000, Once: true},
+		{Account: "Home", Month: "01-2025", Amount: 900000},
+		{Account: "Home", Month: "04-2026", Amount: 1, Once: true},
+	}
+
+	// Month by month, Food's budget follows its rows
+	months := []struct {
+		month string
+		want  Money
+	}{
+		{"11-2025", 100000}, // before any row: the account's own budget
+		{"12-2025", 150000}, // rows are found by month, not by order
+		{"01-2026", 150000}, // carried across the year end
+		{"02-2026", 150000},
+		{"03-2026", 200000},
+		{"04-2026", 200000}, // another account's override does not apply
+		{"05-2026", 500000}, // this month only
+		{"06-2026", 200000}, // and back to the carried budget
+		{"07-2026", 0},      // a zero row carries forward too
+		{"08-2026", 0},
+		{"09-2026", 50000}, // the override wins over a row of the same month
+		{"10-2026", 250000},
+		{"01-2030", 250000},
+	}
+	for _, tt := range months {
+		if got := budgetFor(food, entries, tt.month); got != tt.want {
+			t.Errorf("budgetFor(Food, %s) = %s, want %s", tt.month, got, tt.want)
+		}
+	}
+
+	home := Account{Name: "Home", Type: "EXPENSE", Budget: 7}
+	tests := []struct {
+		month string
+		want  Money
+	}{
+		{"12-2024", 7},
+		{"01-2025", 900000},
+		{"04-2026", 1},
+		{"05-2026", 900000},
+	}
+	for _, tt := range tests {
+		if got := budgetFor(home, entries, tt.month); got != tt.want {
+			t.Errorf("budgetFor(Home, %s) = %s, want %s", tt.month, got, tt.want)
+		}
+	}
+
+	// Without rows the account's budget holds in every month
+	if got := budgetFor(Account{Name: "Rent", Budget: 3}, entries, "05-2026"); got != 3 {
+		t.Errorf("budgetFor(Rent) = %s, want 0.03", got)
+	}
+}
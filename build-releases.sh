@@ -13,19 +13,19 @@ mkdir -p releases
 
 # Build for different platforms
 echo "Building Linux AMD64..."
-GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o ${BINARY_NAME}-linux-amd64 main.go
+GOOS=linux GOARCH=amd64 go build -ldflags="-s -w" -o ${BINARY_NAME}-linux-amd64 .
 
 echo "Building Linux ARM64..."
-GOOS=linux GOARCH=arm64 go build -ldflags="-s -w" -o ${BINARY_NAME}-linux-arm64 main.go
+GOOS=linux GOARCH=arm64 go build -ldflags="-s -w" -o ${BINARY_NAME}-linux-arm64 .
 
 echo "Building macOS Intel..."
-GOOS=darwin GOARCH=amd64 go build -ldflags="-s -w" -o ${BINARY_NAME}-darwin-amd64 main.go
+GOOS=darwin GOARCH=amd64 go build -ldflags="-s -w" -o ${BINARY_NAME}-darwin-amd64 .
 
 echo "Building macOS Apple Silicon..."
-GOOS=darwin GOARCH=arm64 go build -ldflags="-s -w" -o ${BINARY_NAME}-darwin-arm64 main.go
+GOOS=darwin GOARCH=arm64 go build -ldflags="-s -w" -o ${BINARY_NAME}-darwin-arm64 .
 
 echo "Building Windows AMD64..."
-GOOS=windows GOARCH=amd64 go build -ldflags="-s -w" -o ${BINARY_NAME}-windows-amd64.exe main.go
+GOOS=windows GOARCH=amd64 go build -ldflags="-s -w" -o ${BINARY_NAME}-windows-amd64.exe .
 
 echo "Creating release archives..."
 
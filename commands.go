@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runCommand dispatches one-shot subcommands given after the flags, e.g.
+//
+//	arthik migrate -from csv -to sqlite
+//	arthik import -profile HDFC statement.csv
+//	arthik import-journal -commit main.beancount
+//	arthik export -format beancount -o arthik.beancount
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:])
+	case "import":
+		return runImport(args[1:])
+	case "import-journal":
+		return runImportJournal(args[1:])
+	case "export":
+		return runExport(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (available: migrate, import, import-journal, export)", args[0])
+	}
+}
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "csv", "Source store: csv or sqlite")
+	to := fs.String("to", "sqlite", "Destination store: csv or sqlite")
+	dataDir := fs.String("data", DATA_DIR, "CSV data directory")
+	dbPath := fs.String("db", filepath.Join(DATA_DIR, "arthik.db"), "SQLite database file")
+	force := fs.Bool("force", false, "Overwrite a destination that already holds data")
+	fs.Parse(args)
+
+	if *from == *to {
+		return errors.New("migrate: -from and -to must differ")
+	}
+
+	if err := os.MkdirAll(*dataDir, 0700); err != nil {
+		return err
+	}
+
+	src, err := openStore(*from, *dataDir, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := openStore(*to, *dataDir, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if !*force {
+		accounts, _ := dst.ReadAccounts()
+		years, _ := dst.TransactionYears()
+		if len(accounts) > 0 || len(years) > 0 {
+			return fmt.Errorf("migrate: %s store already has data (use -force to overwrite)", *to)
+		}
+	}
+
+	summary, err := copyStore(src, dst)
+	if err != nil {
+		return fmt.Errorf("migrate: %v", err)
+	}
+
+	log.Printf("Migrated %s to %s: %s", *from, *to, summary)
+	return nil
+}
+
+// copyStore copies every account, transaction, record and feature table
+// from src to dst
+func copyStore(src, dst Store) (string, error) {
+	accounts, err := src.ReadAccounts()
+	if err != nil {
+		return "", fmt.Errorf("reading accounts: %v", err)
+	}
+	if err := dst.WriteAccounts(accounts); err != nil {
+		return "", fmt.Errorf("writing accounts: %v", err)
+	}
+
+	years, err := src.TransactionYears()
+	if err != nil {
+		return "", fmt.Errorf("listing transactions: %v", err)
+	}
+
+	// Clear years that exist only in the destination
+	dstYears, err := dst.TransactionYears()
+	if err != nil {
+		return "", fmt.Errorf("listing transactions: %v", err)
+	}
+	srcYears := make(map[string]bool)
+	for _, year := range years {
+		srcYears[year] = true
+	}
+	for _, year := range dstYears {
+		if !srcYears[year] {
+			if err := dst.WriteTransactions(year, nil); err != nil {
+				return "", fmt.Errorf("clearing %s: %v", year, err)
+			}
+		}
+	}
+
+	total := 0
+	for _, year := range years {
+		transactions, err := src.ReadTransactions(year)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %v", year, err)
+		}
+		if err := dst.WriteTransactions(year, transactions); err != nil {
+			return "", fmt.Errorf("writing %s: %v", year, err)
+		}
+		total += len(transactions)
+	}
+
+	records, err := src.ReadRecords()
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("reading records: %v", err)
+	}
+	if err := dst.WriteRecords(records); err != nil {
+		return "", fmt.Errorf("writing records: %v", err)
+	}
+
+	for _, name := range storeTables {
+		rows, cols, err := src.ReadTable(name)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %v", name, err)
+		}
+		if len(cols) == 0 {
+			continue
+		}
+		header := tableHeader(cols)
+		if err := dst.WriteTable(name, header, rows); err != nil {
+			return "", fmt.Errorf("writing %s: %v", name, err)
+		}
+	}
+
+	return fmt.Sprintf("%d accounts, %d transactions in %d years, %d records",
+		len(accounts), total, len(years), len(records)), nil
+}
+
+// runImport previews a bank statement with a saved import profile, and
+// adds its transactions with -commit
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	profileName := fs.String("profile", "", "Import profile name")
+	kind := fs.String("store", "csv", "Storage backend: csv or sqlite")
+	dataDir := fs.String("data", DATA_DIR, "CSV data directory")
+	dbPath := fs.String("db", filepath.Join(DATA_DIR, "arthik.db"), "SQLite database file")
+	commit := fs.Bool("commit", false, "Add the transactions instead of only previewing them")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("import: give exactly one statement file")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("import: %v", err)
+	}
+
+	store, err = openStore(*kind, *dataDir, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	profile, err := loadImportProfile(*profileName)
+	if err != nil {
+		return fmt.Errorf("import: %v", err)
+	}
+
+	rows, imported, err := importStatement(profile, data, nil, *commit)
+	if err != nil {
+		return fmt.Errorf("import: %v", err)
+	}
+
+	for _, row := range rows {
+		t := row.Transaction
+		status := "ok"
+		if row.Rule != "" {
+			status = "ok (rule " + row.Rule + ")"
+		}
+		if row.Error != "" {
+			status = "error: " + row.Error
+		} else if len(row.Duplicates) > 0 {
+			status = "possible duplicate of " + strings.Join(row.Duplicates, ", ")
+		}
+		fmt.Printf("%4d  %s  %s -> %s  %s  %q  %s\n", row.Line, t.TranDate, t.From, t.To, t.Amount, t.Description, status)
+	}
+
+	if *commit {
+		log.Printf("Imported %d of %d lines", imported, len(rows))
+	} else {
+		log.Printf("Previewed %d lines; run again with -commit to import them", len(rows))
+	}
+	return nil
+}
+
+// runImportJournal previews a ledger, hledger or beancount journal, and
+// adds its accounts and transactions with -commit
+func runImportJournal(args []string) error {
+	fs := flag.NewFlagSet("import-journal", flag.ExitOnError)
+	kind := fs.String("store", "csv", "Storage backend: csv or sqlite")
+	dataDir := fs.String("data", DATA_DIR, "CSV data directory")
+	dbPath := fs.String("db", filepath.Join(DATA_DIR, "arthik.db"), "SQLite database file")
+	commit := fs.Bool("commit", false, "Add the accounts and transactions instead of only previewing them")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("import-journal: give exactly one journal file")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("import-journal: %v", err)
+	}
+
+	if err := os.MkdirAll(*dataDir, 0700); err != nil {
+		return err
+	}
+	store, err = openStore(*kind, *dataDir, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rows, created, imported, err := importJournal(data, *commit)
+	if err != nil {
+		return fmt.Errorf("import-journal: %v", err)
+	}
+
+	for _, acc := range created {
+		fmt.Printf("new account  %s (%s)\n", accountPath(created, acc.Name), acc.Type)
+	}
+	for _, row := range rows {
+		t := row.Transaction
+		to := t.To
+		if len(t.Splits) > 0 {
+			to = strings.Join(legAccounts(t), "+")
+		}
+		status := "ok"
+		if row.Error != "" {
+			status = "error: " + row.Error
+		} else if len(row.Duplicates) > 0 {
+			status = "possible duplicate of " + strings.Join(row.Duplicates, ", ")
+		}
+		fmt.Printf("%6d  %s  %s -> %s  %s  %q  %s\n", row.Line, t.TranDate, t.From, to, t.Amount, t.Description, status)
+	}
+
+	if *commit {
+		log.Printf("Imported %d transactions and %d new accounts", imported, len(created))
+	} else {
+		log.Printf("Previewed %d transactions and %d new accounts; run again with -commit to import them", len(rows), len(created))
+	}
+	return nil
+}
+
+// runExport writes every account and transaction as a ledger, hledger or
+// beancount journal, to standard output unless -o is given
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", EXPORT_LEDGER, "Journal format: ledger, hledger or beancount")
+	output := fs.String("o", "", "Output file (default standard output)")
+	kind := fs.String("store", "csv", "Storage backend: csv or sqlite")
+	dataDir := fs.String("data", DATA_DIR, "CSV data directory")
+	dbPath := fs.String("db", filepath.Join(DATA_DIR, "arthik.db"), "SQLite database file")
+	fs.Parse(args)
+
+	if _, ok := exportExtensions[*format]; !ok {
+		return errors.New("export: -format must be ledger, hledger or beancount")
+	}
+
+	var err error
+	store, err = openStore(*kind, *dataDir, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	journal, err := loadJournal()
+	if err != nil {
+		return fmt.Errorf("export: %v", err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("export: %v", err)
+		}
+		defer out.Close()
+	}
+
+	if err := writeJournal(out, *format, journal); err != nil {
+		return fmt.Errorf("export: %v", err)
+	}
+	log.Printf("Exported %d accounts and %d transactions as %s", len(journal.Accounts), len(journal.Transactions), *format)
+	return nil
+}
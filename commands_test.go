@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// migrateFixture is a CSV data directory as csvStore writes it, so a round
+// trip through SQLite must give back the same bytes
+var migrateFixture = map[string]string{
+	"account.csv": "Account,Type,Amount,IINW,Budget,DueDate,ClosedDate,Parent,Currency\n" +
+		"HDFC,ASSET,12345.67,Yes,0.00,,,,\n" +
+		"Food,EXPENSE,0.00,No,5000.00,,,Living,\n" +
+		"Wise,ASSET,0.00,Yes,0.00,,30-09-2026,,USD\n",
+	"tran_2025.csv": "ID,TranDate,TranTime,From,To,Description,Amount,ToAmount,Security,Units,Price,FITID,Tags\n" +
+		"a0,31-12-2025,23:59,HDFC,Food,\"Dinner, late\",450.50,,,,,,\"food,work\"\n",
+	"tran_2026.csv": "ID,TranDate,TranTime,From,To,Description,Amount,ToAmount,Security,Units,Price,FITID,Tags\n" +
+		"a1,02-10-2026,10:30,HDFC,Food,Supermarket,1000.00,,,,,,\n" +
+		"a1,02-10-2026,10:30,HDFC,Wise,Supermarket,100.00,1.20,,,,,\n" +
+		"a2,03-10-2026,11:00,HDFC,Wise,Transfer,8325.00,100.00,,,,F-1,\n",
+	"record.csv": "Date,NetWorth,Assets,Liabilities,Expenses\n" +
+		"30-09-2026,10000.00,12000.00,-2000.00,500.00\n",
+	"budgets.csv": "Account,Month,Amount,Once\n" +
+		"Food,10-2026,5000.00,\n",
+	"rates.csv": "Date,From,To,Rate\n" +
+		"01-10-2026,USD,INR,83.25\n",
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	csvDir, backDir := t.TempDir(), t.TempDir()
+	db := filepath.Join(t.TempDir(), "arthik.db")
+	for name, data := range migrateFixture {
+		if err := os.WriteFile(filepath.Join(csvDir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runMigrate([]string{"-from", "csv", "-to", "sqlite", "-data", csvDir, "-db", db}); err != nil {
+		t.Fatal(err)
+	}
+	// The destination now has data
+	if err := runMigrate([]string{"-from", "csv", "-to", "sqlite", "-data", csvDir, "-db", db}); err == nil {
+		t.Error("migrating onto a store with data succeeded without -force")
+	}
+	if err := runMigrate([]string{"-from", "sqlite", "-to", "csv", "-data", backDir, "-db", db}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	files, err := filepath.Glob(filepath.Join(backDir, "*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[filepath.Base(path)] = string(data)
+	}
+	if !reflect.DeepEqual(got, migrateFixture) {
+		for name, want := range migrateFixture {
+			if got[name] != want {
+				t.Errorf("%s after csv -> sqlite -> csv:\n%s\nwant:\n%s", name, got[name], want)
+			}
+		}
+		for name := range got {
+			if _, ok := migrateFixture[name]; !ok {
+				t.Errorf("unexpected file %s", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Exchange rates are stored in rates.csv as Date,From,To,Rate rows, where
+// one unit of From is worth Rate units of To on that date. Amounts are
+// converted with the latest rate on or before the date asked for; dates
+// before the first known rate use the earliest one.
+
+var rateHeader = []string{"Date", "From", "To", "Rate"}
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+var ratePattern = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+var ratesMutex sync.Mutex
+
+type ExchangeRate struct {
+	Date string `json:"date"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	Rate string `json:"rate"`
+}
+
+type ratePoint struct {
+	key  string // YYYYMMDD, sortable
+	rate *big.Rat
+}
+
+// Converter converts amounts between currencies and into the base currency
+type Converter struct {
+	Base    string
+	rates   map[string][]ratePoint // "FROM/TO", oldest first
+	missing map[string]bool
+}
+
+func isValidCurrency(code string) bool {
+	return currencyPattern.MatchString(code)
+}
+
+// dateKey turns DD-MM-YYYY into a sortable YYYYMMDD
+func dateKey(date string) string {
+	if len(date) < 10 {
+		return date
+	}
+	return date[6:10] + date[3:5] + date[0:2]
+}
+
+// accountCurrency returns the account's currency, or the base currency for
+// accounts without one
+func accountCurrency(acc Account, base string) string {
+	if acc.Currency == "" {
+		return base
+	}
+	return acc.Currency
+}
+
+func readRates() ([]ExchangeRate, error) {
+	rows, cols, err := store.ReadTable("rates")
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []ExchangeRate
+	for _, record := range rows {
+		rates = append(rates, ExchangeRate{
+			Date: columnValue(record, cols, "Date"),
+			From: columnValue(record, cols, "From"),
+			To:   columnValue(record, cols, "To"),
+			Rate: columnValue(record, cols, "Rate"),
+		})
+	}
+	return rates, nil
+}
+
+func writeRates(rates []ExchangeRate) error {
+	sort.SliceStable(rates, func(i, j int) bool {
+		return dateKey(rates[i].Date) < dateKey(rates[j].Date)
+	})
+
+	rows := make([][]string, 0, len(rates))
+	for _, r := range rates {
+		rows = append(rows, []string{r.Date, r.From, r.To, r.Rate})
+	}
+	return store.WriteTable("rates", rateHeader, rows)
+}
+
+func validateRate(r *ExchangeRate) error {
+	r.From = strings.ToUpper(sanitizeInput(r.From))
+	r.To = strings.ToUpper(sanitizeInput(r.To))
+	r.Rate = sanitizeInput(r.Rate)
+
+	if !isValidDate(r.Date) {
+		return errors.New("invalid date format (use DD-MM-YYYY)")
+	}
+	if !isValidCurrency(r.From) || !isValidCurrency(r.To) {
+		return errors.New("currencies must be 3-letter codes")
+	}
+	if r.From == r.To {
+		return errors.New("from and to currencies must differ")
+	}
+	if !ratePattern.MatchString(r.Rate) {
+		return errors.New("rate must be a positive decimal number")
+	}
+	if v, ok := new(big.Rat).SetString(r.Rate); !ok || v.Sign() <= 0 {
+		return errors.New("rate must be a positive decimal number")
+	}
+	return nil
+}
+
+// loadConverter reads the base currency and every exchange rate
+func loadConverter() (*Converter, error) {
+	c := &Converter{
+		Base:    getSetting("baseCurrency"),
+		rates:   make(map[string][]ratePoint),
+		missing: make(map[string]bool),
+	}
+
+	rates, err := readRates()
+	if err != nil {
+		return c, err
+	}
+	for _, r := range rates {
+		v, ok := new(big.Rat).SetString(r.Rate)
+		if !ok || v.Sign() <= 0 {
+			continue
+		}
+		pair := r.From + "/" + r.To
+		c.rates[pair] = append(c.rates[pair], ratePoint{key: dateKey(r.Date), rate: v})
+	}
+	for _, points := range c.rates {
+		sort.SliceStable(points, func(i, j int) bool { return points[i].key < points[j].key })
+	}
+	return c, nil
+}
+
+// lookup returns the stored rate for one direction of a pair
+func (c *Converter) lookup(from, to, date string) (*big.Rat, bool) {
+	points := c.rates[from+"/"+to]
+	if len(points) == 0 {
+		return nil, false
+	}
+
+	key := dateKey(date)
+	i := sort.Search(len(points), func(i int) bool { return points[i].key > key })
+	if i == 0 {
+		return points[0].rate, true
+	}
+	return points[i-1].rate, true
+}
+
+// Rate finds how many units of to one unit of from is worth on a date,
+// using a direct rate, its inverse, or a cross rate through the base
+// currency
+func (c *Converter) Rate(from, to, date string) (*big.Rat, bool) {
+	if from == to {
+		return big.NewRat(1, 1), true
+	}
+	if r, ok := c.lookup(from, to, date); ok {
+		return r, true
+	}
+	if r, ok := c.lookup(to, from, date); ok {
+		return new(big.Rat).Inv(r), true
+	}
+	if from != c.Base && to != c.Base {
+		a, okA := c.Rate(from, c.Base, date)
+		b, okB := c.Rate(c.Base, to, date)
+		if okA && okB {
+			return new(big.Rat).Mul(a, b), true
+		}
+	}
+	return nil, false
+}
+
+// Convert converts an amount between currencies on a date. A result too
+// large for Money is logged and treated like a missing rate, so it never
+// reaches a total.
+func (c *Converter) Convert(m Money, from, to, date string) (Money, bool) {
+	rate, ok := c.Rate(from, to, date)
+	if !ok {
+		return 0, false
+	}
+	v, err := m.Convert(rate)
+	if err != nil {
+		log.Printf("Converting %s %s to %s on %s: %v", m, from, to, date, err)
+		return 0, false
+	}
+	return v, true
+}
+
+// ToBase converts an amount into the base currency. Without a rate the
+// amount is used as is and the pair is remembered for Missing.
+func (c *Converter) ToBase(m Money, currency, date string) Money {
+	if currency == "" {
+		return m
+	}
+	v, ok := c.Convert(m, currency, c.Base, date)
+	if !ok {
+		c.missing[currency+"/"+c.Base] = true
+		return m
+	}
+	return v
+}
+
+// Missing lists the currency pairs ToBase had no rate for
+func (c *Converter) Missing() []string {
+	var pairs []string
+	for pair := range c.missing {
+		pairs = append(pairs, pair)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// resolveToAmounts fills in the amount received by each leg whose account
+// holds a different currency from the source, using the exchange rate on
+// the transaction date when the caller did not give one
+func resolveToAmounts(t *Transaction, accounts []Account) error {
+	conv, err := loadConverter()
+	if err != nil {
+		return err
+	}
+	from := accountCurrency(findAccount(accounts, t.From), conv.Base)
+
+	resolve := func(leg *Split) error {
+		to := accountCurrency(findAccount(accounts, leg.To), conv.Base)
+		if to == from {
+			leg.ToAmount = 0
+			return nil
+		}
+		if leg.ToAmount < 0 || leg.ToAmount > MAX_AMOUNT {
+			return errors.New("received amount out of range")
+		}
+		if leg.ToAmount == 0 {
+			rate, ok := conv.Rate(from, to, t.TranDate)
+			if !ok {
+				return fmt.Errorf("no exchange rate from %s to %s; give the received amount (toAmount)", from, to)
+			}
+			// A tiny amount can round to nothing and a huge one overflow;
+			// neither is what was received
+			v, err := leg.Amount.Convert(rate)
+			if err != nil || v <= 0 || v > MAX_AMOUNT {
+				return fmt.Errorf("%s %s does not convert to a valid amount of %s; give the received amount (toAmount)", leg.Amount, from, to)
+			}
+			leg.ToAmount = v
+		}
+		return nil
+	}
+
+	if len(t.Splits) == 0 {
+		leg := Split{To: t.To, Amount: t.Amount, ToAmount: t.ToAmount}
+		if err := resolve(&leg); err != nil {
+			return err
+		}
+		t.ToAmount = leg.ToAmount
+		return nil
+	}
+
+	t.ToAmount = 0
+	for i := range t.Splits {
+		if err := resolve(&t.Splits[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleRates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		rates, err := readRates()
+		if err != nil {
+			respondError(w, "Failed to load exchange rates", http.StatusInternalServerError)
+			return
+		}
+		if rates == nil {
+			rates = []ExchangeRate{}
+		}
+		json.NewEncoder(w).Encode(rates)
+
+	case http.MethodPost:
+		var rate ExchangeRate
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		var data map[string]interface{}
+		if err := decoder.Decode(&data); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+		rate.Date, _ = data["date"].(string)
+		rate.From, _ = data["from"].(string)
+		rate.To, _ = data["to"].(string)
+		switch v := data["rate"].(type) {
+		case json.Number:
+			rate.Rate = v.String()
+		case string:
+			rate.Rate = v
+		}
+
+		if err := validateRate(&rate); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ratesMutex.Lock()
+		rates, err := readRates()
+		if err == nil {
+			// One rate per pair and day; a new one replaces the old
+			replaced := false
+			for i := range rates {
+				if rates[i].Date == rate.Date && rates[i].From == rate.From && rates[i].To == rate.To {
+					rates[i] = rate
+					replaced = true
+				}
+			}
+			if !replaced {
+				rates = append(rates, rate)
+			}
+			err = writeRates(rates)
+		}
+		ratesMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to save exchange rate", http.StatusInternalServerError)
+			return
+		}
+
+		if err := recalculateAllData(); err != nil {
+			log.Printf("Error recalculating data: %v", err)
+		}
+
+		logSecurityEvent("RATE_ADD", getClientIP(r), fmt.Sprintf("Set %s/%s rate on %s: %s", rate.From, rate.To, rate.Date, rate.Rate))
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveToAmounts(t *testing.T) {
+	useTestStore(t)
+
+	rates := []ExchangeRate{
+		{Date: "01-10-2026", From: "USD", To: "INR", Rate: "83.25"},
+		{Date: "01-10-2026", From: "JPY", To: "INR", Rate: "0.5"},
+		{Date: "01-10-2026", From: "INR", To: "XAU", Rate: "0.000001"},
+	}
+	if err := writeRates(rates); err != nil {
+		t.Fatal(err)
+	}
+	accounts := []Account{
+		{Name: "HDFC", Type: "ASSET"},
+		{Name: "Wise", Type: "ASSET", Currency: "USD"},
+		{Name: "Yen", Type: "ASSET", Currency: "JPY"},
+		{Name: "Gold", Type: "ASSET", Currency: "XAU"},
+		{Name: "Euro", Type: "ASSET", Currency: "EUR"},
+	}
+
+	tests := []struct {
+		name string
+		tran Transaction
+		want Money  // the ToAmount filled in
+		err  string // a part of the error
+	}{
+		{name: "same currency", tran: Transaction{From: "HDFC", To: "HDFC", Amount: 100, ToAmount: 5}, want: 0},
+		{name: "at the rate", tran: Transaction{From: "Wise", To: "HDFC", Amount: 10000}, want: 832500},
+		{name: "inverse rate", tran: Transaction{From: "HDFC", To: "Wise", Amount: 832500}, want: 10000},
+		{name: "given", tran: Transaction{From: "HDFC", To: "Wise", Amount: 832500, ToAmount: 9999}, want: 9999},
+		{name: "cross rate", tran: Transaction{From: "Wise", To: "Yen", Amount: 100}, want: 16650},
+		{name: "no rate", tran: Transaction{From: "HDFC", To: "Euro", Amount: 100}, err: "no exchange rate from INR to EUR"},
+		// 0.01 INR is 0.00000001 XAU, which rounds to nothing
+		{name: "rounds to zero", tran: Transaction{From: "HDFC", To: "Gold", Amount: 1}, err: "give the received amount (toAmount)"},
+		// Too large to store
+		{name: "out of range", tran: Transaction{From: "Gold", To: "HDFC", Amount: MAX_AMOUNT}, err: "give the received amount (toAmount)"},
+		{name: "given out of range", tran: Transaction{From: "HDFC", To: "Wise", Amount: 100, ToAmount: MAX_AMOUNT + 1}, err: "received amount out of range"},
+	}
+
+	for _, tt := range tests {
+		tt.tran.TranDate = "16-10-2026"
+		err := resolveToAmounts(&tt.tran, accounts)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || tt.tran.ToAmount != tt.want {
+			t.Errorf("%s: ToAmount = %s, %v, want %s", tt.name, tt.tran.ToAmount, err, tt.want)
+		}
+	}
+
+	// Each leg of a split is converted on its own
+	split := Transaction{
+		TranDate: "16-10-2026", From: "HDFC", Amount: 832600, ToAmount: 7,
+		Splits: []Split{{To: "Wise", Amount: 832500}, {To: "HDFC", Amount: 100, ToAmount: 3}},
+	}
+	if err := resolveToAmounts(&split, accounts); err != nil {
+		t.Fatal(err)
+	}
+	if split.ToAmount != 0 || split.Splits[0].ToAmount != 10000 || split.Splits[1].ToAmount != 0 {
+		t.Errorf("split resolved to %+v", split)
+	}
+}
+
+func TestConverterConvert(t *testing.T) {
+	useTestStore(t)
+
+	if err := writeRates([]ExchangeRate{
+		{Date: "01-10-2026", From: "USD", To: "INR", Rate: "83.25"},
+		{Date: "01-10-2026", From: "XAU", To: "INR", Rate: "1000000000000"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	conv, err := loadConverter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := conv.Convert(10000, "USD", "INR", "16-10-2026"); !ok || v != 832500 {
+		t.Errorf("Convert(100 USD) = %s, %v, want 8325.00", v, ok)
+	}
+	if _, ok := conv.Convert(100, "EUR", "INR", "16-10-2026"); ok {
+		t.Error("Convert without a rate succeeded")
+	}
+	// Too large for Money: no value is given, as with a missing rate
+	if v, ok := conv.Convert(MAX_AMOUNT, "XAU", "INR", "16-10-2026"); ok || v != 0 {
+		t.Errorf("overflowing Convert = %s, %v, want 0, false", v, ok)
+	}
+	if v := conv.ToBase(MAX_AMOUNT, "XAU", "16-10-2026"); v != MAX_AMOUNT {
+		t.Errorf("overflowing ToBase = %s, want the amount unconverted", v)
+	}
+	if missing := conv.Missing(); len(missing) != 1 || missing[0] != "XAU/INR" {
+		t.Errorf("Missing = %v, want [XAU/INR]", missing)
+	}
+}
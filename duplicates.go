@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// A transaction entered by hand and the same purchase imported from a
+// statement later look alike: same amount, a shared account, dates a day
+// or two apart and similar descriptions. Such pairs are flagged when a
+// transaction is added or imported and listed for review, where one side
+// is merged into the other or the pair is dismissed for good.
+
+// DUPLICATE_WINDOW_DAYS is how far apart the dates of a duplicate may be
+const DUPLICATE_WINDOW_DAYS = 3
+
+// DUPLICATE_SIMILARITY is the description similarity (0 to 1) above which
+// two transactions on different dates or accounts count as duplicates
+const DUPLICATE_SIMILARITY = 0.5
+
+var dismissalHeader = []string{"First", "Second"}
+
+var duplicatesMutex sync.Mutex
+
+// DuplicatePair is a review queue entry; First is the older transaction
+type DuplicatePair struct {
+	First      Transaction `json:"first"`
+	Second     Transaction `json:"second"`
+	Similarity float64     `json:"similarity"`
+}
+
+// dayNumber turns DD-MM-YYYY into days since the epoch
+func dayNumber(date string) int {
+	t, err := time.Parse("02-01-2006", date)
+	if err != nil {
+		return 0
+	}
+	return int(t.Unix() / 86400)
+}
+
+// descriptionBigrams returns the letter and digit pairs of a description,
+// ignoring case, spacing and punctuation
+func descriptionBigrams(s string) map[string]int {
+	var b strings.Builder
+	for _, r := range strings.ToLower(html.UnescapeString(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	runes := []rune(b.String())
+
+	grams := make(map[string]int)
+	for i := 0; i+1 < len(runes); i++ {
+		grams[string(runes[i:i+2])]++
+	}
+	return grams
+}
+
+// descriptionSimilarity is the Dice coefficient of the descriptions'
+// bigrams: 1 for the same text, 0 for nothing in common
+func descriptionSimilarity(a, b string) float64 {
+	ga, gb := descriptionBigrams(a), descriptionBigrams(b)
+	total := 0
+	for _, n := range ga {
+		total += n
+	}
+	for _, n := range gb {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	common := 0
+	for gram, n := range ga {
+		if m := gb[gram]; m < n {
+			common += m
+		} else {
+			common += n
+		}
+	}
+	return 2 * float64(common) / float64(total)
+}
+
+// sameLegs reports whether two transactions credit the same accounts
+func sameLegs(a, b Transaction) bool {
+	la, lb := legAccounts(a), legAccounts(b)
+	if len(la) != len(lb) {
+		return false
+	}
+	for i := range la {
+		if la[i] != lb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// duplicateSimilarity reports whether two transactions look like the same
+// event, with their description similarity
+func duplicateSimilarity(a, b Transaction) (float64, bool) {
+	if a.ID == b.ID && a.ID != "" {
+		return 0, false
+	}
+	if a.Amount != b.Amount {
+		return 0, false
+	}
+	// Two different bank lines are never the same event
+	if a.FITID != "" && b.FITID != "" && a.FITID != b.FITID {
+		return 0, false
+	}
+
+	gap := dayNumber(a.TranDate) - dayNumber(b.TranDate)
+	if gap < -DUPLICATE_WINDOW_DAYS || gap > DUPLICATE_WINDOW_DAYS {
+		return 0, false
+	}
+
+	accountsA := append([]string{a.From}, legAccounts(a)...)
+	accountsB := append([]string{b.From}, legAccounts(b)...)
+	if !anyName(accountsA, func(name string) bool {
+		return anyName(accountsB, func(other string) bool { return other == name })
+	}) {
+		return 0, false
+	}
+
+	similarity := descriptionSimilarity(a.Description, b.Description)
+	if similarity >= DUPLICATE_SIMILARITY {
+		return similarity, true
+	}
+	// Different wording, but the same accounts on the same day
+	if gap == 0 && a.From == b.From && sameLegs(a, b) {
+		return similarity, true
+	}
+	return 0, false
+}
+
+// findDuplicates returns the existing transactions that t may duplicate
+func findDuplicates(t Transaction, existing []Transaction) []Transaction {
+	var matches []Transaction
+	for _, other := range existing {
+		if _, ok := duplicateSimilarity(t, other); ok {
+			matches = append(matches, other)
+		}
+	}
+	return matches
+}
+
+func duplicateIDs(transactions []Transaction) []string {
+	var ids []string
+	for _, t := range transactions {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func pairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "\x00" + b
+}
+
+func readDismissals() (map[string]bool, error) {
+	rows, cols, err := store.ReadTable("duplicate_dismissals")
+	if err != nil {
+		return nil, err
+	}
+	dismissed := make(map[string]bool, len(rows))
+	for _, record := range rows {
+		dismissed[pairKey(columnValue(record, cols, "First"), columnValue(record, cols, "Second"))] = true
+	}
+	return dismissed, nil
+}
+
+func writeDismissals(dismissed map[string]bool) error {
+	keys := make([]string, 0, len(dismissed))
+	for key := range dismissed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rows := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, strings.SplitN(key, "\x00", 2))
+	}
+	return store.WriteTable("duplicate_dismissals", dismissalHeader, rows)
+}
+
+// duplicateQueue finds every likely duplicate pair not yet dismissed.
+// transactions must be sorted newest first, as readAllTransactions returns
+// them.
+func duplicateQueue(transactions []Transaction, dismissed map[string]bool) []DuplicatePair {
+	pairs := []DuplicatePair{}
+	for i := range transactions {
+		day := dayNumber(transactions[i].TranDate)
+		for j := i + 1; j < len(transactions); j++ {
+			if day-dayNumber(transactions[j].TranDate) > DUPLICATE_WINDOW_DAYS {
+				break
+			}
+			if dismissed[pairKey(transactions[i].ID, transactions[j].ID)] {
+				continue
+			}
+			if similarity, ok := duplicateSimilarity(transactions[i], transactions[j]); ok {
+				pairs = append(pairs, DuplicatePair{First: transactions[j], Second: transactions[i], Similarity: similarity})
+			}
+		}
+	}
+	return pairs
+}
+
+// mergeDuplicate deletes remove and keeps keep, which takes over the bank
+// ID of the removed line so a later import still recognizes it. Both year
+// files are written in one batch.
+func mergeDuplicate(keepID, removeID string) error {
+	years, err := store.TransactionYears()
+	if err != nil {
+		return err
+	}
+
+	byYear := make(map[string][]Transaction)
+	var keep, remove *Transaction
+	var removeYear string
+	for _, year := range years {
+		transactions, err := store.ReadTransactions(year)
+		if err != nil {
+			return err
+		}
+		for i := range transactions {
+			switch transactions[i].ID {
+			case keepID:
+				byYear[year] = transactions
+				keep = &transactions[i]
+			case removeID:
+				byYear[year] = transactions
+				remove = &transactions[i]
+				removeYear = year
+			}
+		}
+	}
+	if keep == nil || remove == nil {
+		return errTransactionNotFound
+	}
+
+	if keep.FITID == "" {
+		keep.FITID = remove.FITID
+	}
+	var filtered []Transaction
+	for _, t := range byYear[removeYear] {
+		if t.ID != removeID {
+			filtered = append(filtered, t)
+		}
+	}
+	byYear[removeYear] = filtered
+
+	return store.Commit(Batch{Transactions: byYear})
+}
+
+// handleDuplicates lists the review queue (GET /api/duplicates), merges a
+// pair (POST /api/duplicates/merge with keep and remove) or dismisses one
+// (POST /api/duplicates/dismiss with first and second)
+func handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet && r.URL.Path == "/api/duplicates" {
+		transactions, err := readAllTransactions()
+		if err != nil {
+			respondError(w, "Failed to load transactions", http.StatusInternalServerError)
+			return
+		}
+		dismissed, err := readDismissals()
+		if err != nil {
+			respondError(w, "Failed to load dismissed duplicates", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(duplicateQueue(transactions, dismissed))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		respondError(w, "Invalid request data", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/merge"):
+		keep, remove := sanitizeInput(data["keep"]), sanitizeInput(data["remove"])
+		if keep == "" || remove == "" || keep == remove {
+			respondError(w, "keep and remove must be two different transaction IDs", http.StatusBadRequest)
+			return
+		}
+
+		duplicatesMutex.Lock()
+		err := mergeDuplicate(keep, remove)
+		duplicatesMutex.Unlock()
+		if err != nil {
+			if errors.Is(err, errTransactionNotFound) {
+				respondError(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			respondError(w, "Failed to merge transactions", http.StatusInternalServerError)
+			return
+		}
+
+		if err := recalculateAllData(); err != nil {
+			log.Printf("Error recalculating data: %v", err)
+		}
+		logSecurityEvent("DUPLICATE_MERGE", getClientIP(r), fmt.Sprintf("Merged transaction %s into %s", remove, keep))
+
+	case strings.HasSuffix(r.URL.Path, "/dismiss"):
+		first, second := sanitizeInput(data["first"]), sanitizeInput(data["second"])
+		if first == "" || second == "" || first == second {
+			respondError(w, "first and second must be two different transaction IDs", http.StatusBadRequest)
+			return
+		}
+
+		duplicatesMutex.Lock()
+		dismissed, err := readDismissals()
+		if err == nil {
+			dismissed[pairKey(first, second)] = true
+			err = writeDismissals(dismissed)
+		}
+		duplicatesMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to dismiss duplicate", http.StatusInternalServerError)
+			return
+		}
+		logSecurityEvent("DUPLICATE_DISMISS", getClientIP(r), fmt.Sprintf("Dismissed duplicate %s / %s", first, second))
+
+	default:
+		respondError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
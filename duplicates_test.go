@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDuplicateSimilarity(t *testing.T) {
+	base := Transaction{ID: "1", TranDate: "10-10-2026", From: "HDFC", To: "Food", Description: "Swiggy order", Amount: 45000}
+	with := func(change func(t *Transaction)) Transaction {
+		t := base
+		t.ID = "2"
+		change(&t)
+		return t
+	}
+
+	tests := []struct {
+		name string
+		b    Transaction
+		want bool
+		sim  float64 // the similarity of a duplicate
+	}{
+		{name: "same", b: with(func(t *Transaction) {}), want: true, sim: 1},
+		{name: "same ID", b: base},
+		{name: "other amount", b: with(func(t *Transaction) { t.Amount++ })},
+		{name: "days apart, in the window", b: with(func(t *Transaction) { t.TranDate = "13-10-2026" }), want: true, sim: 1},
+		{name: "days apart, past the window", b: with(func(t *Transaction) { t.TranDate = "14-10-2026" })},
+		{name: "earlier, past the window", b: with(func(t *Transaction) { t.TranDate = "06-10-2026" })},
+		{name: "only one is a bank line", b: with(func(t *Transaction) { t.FITID = "B" }), want: true, sim: 1},
+		{name: "a shared account", b: with(func(t *Transaction) { t.From = "Card" }), want: true, sim: 1},
+		{name: "no shared account", b: with(func(t *Transaction) { t.From, t.To = "Card", "Home" })},
+		{name: "ignores case and punctuation", b: with(func(t *Transaction) { t.Description = "SWIGGY-ORDER!" }), want: true, sim: 1},
+		{name: "escaped text", b: with(func(t *Transaction) { t.Description = "Swiggy &amp; order" }), want: true, sim: 1},
+		// swiggyorder and swiggy: 2*5/(10+5)
+		{name: "near duplicate", b: with(func(t *Transaction) { t.TranDate = "11-10-2026"; t.Description = "Swiggy" }), want: true, sim: 2.0 / 3},
+		// swiggyorder and swiggyxxxxx share 5 of 10 bigrams each
+		{name: "at the threshold", b: with(func(t *Transaction) { t.TranDate = "11-10-2026"; t.Description = "Swiggyxxxxx" }), want: true, sim: 0.5},
+		{name: "below the threshold", b: with(func(t *Transaction) { t.TranDate = "11-10-2026"; t.Description = "Swigxxxxxxx" })},
+		{name: "other wording, same day and accounts", b: with(func(t *Transaction) { t.Description = "Lunch" }), want: true, sim: 0},
+		{name: "other wording, another day", b: with(func(t *Transaction) { t.TranDate = "11-10-2026"; t.Description = "Lunch" })},
+		{name: "other wording, other account", b: with(func(t *Transaction) { t.From = "Card"; t.Description = "Lunch" })},
+		{
+			name: "other wording, other split",
+			b: with(func(t *Transaction) {
+				t.To, t.Description = "", "Lunch"
+				t.Splits = []Split{{To: "Food", Amount: 40000}, {To: "Home", Amount: 5000}}
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		sim, ok := duplicateSimilarity(base, tt.b)
+		if ok != tt.want || (ok && sim != tt.sim) {
+			t.Errorf("%s: duplicateSimilarity = %v, %v, want %v, %v", tt.name, sim, ok, tt.sim, tt.want)
+		}
+		// The order of the pair does not matter
+		if rsim, rok := duplicateSimilarity(tt.b, base); rsim != sim || rok != ok {
+			t.Errorf("%s: reversed pair gives %v, %v", tt.name, rsim, rok)
+		}
+	}
+
+	bank := with(func(t *Transaction) { t.FITID = "A" })
+	if _, ok := duplicateSimilarity(bank, with(func(t *Transaction) { t.ID, t.FITID = "3", "B" })); ok {
+		t.Error("two bank lines with different FITIDs are duplicates")
+	}
+}
+
+func TestMarkDuplicates(t *testing.T) {
+	existing := []Transaction{
+		{ID: "a", TranDate: "10-10-2026", From: "HDFC", To: "Food", Description: "Swiggy order", Amount: 45000},
+		{ID: "b", TranDate: "11-10-2026", From: "HDFC", To: "Food", Description: "Swiggy", Amount: 45000},
+		{ID: "c", TranDate: "10-10-2026", From: "HDFC", To: "Food", Description: "Zomato", Amount: 30000},
+	}
+	rows := []ImportRow{
+		{Line: 1, Transaction: Transaction{TranDate: "12-10-2026", From: "HDFC", To: "Food", Description: "SWIGGY ORDER 123", Amount: 45000}},
+		{Line: 2, Transaction: Transaction{TranDate: "20-10-2026", From: "HDFC", To: "Food", Description: "Swiggy order", Amount: 45000}},
+		{Line: 3, Transaction: Transaction{TranDate: "10-10-2026", From: "HDFC", To: "Food", Description: "Swiggy order", Amount: 45000}, Error: "unknown account"},
+		{Line: 4, Transaction: Transaction{TranDate: "10-10-2026", From: "HDFC", To: "Food", Description: "UPI 5521", Amount: 30000}},
+	}
+
+	markDuplicates(rows, existing)
+
+	want := [][]string{{"a", "b"}, nil, nil, {"c"}}
+	for i, row := range rows {
+		if !reflect.DeepEqual(row.Duplicates, want[i]) {
+			t.Errorf("line %d: Duplicates = %v, want %v", row.Line, row.Duplicates, want[i])
+		}
+	}
+}
+
+func TestMergeDuplicate(t *testing.T) {
+	tests := []struct {
+		name       string
+		keep, drop Transaction
+		want       string // FITID the kept transaction ends with
+	}{
+		{
+			name: "takes the bank ID",
+			keep: Transaction{ID: "k", TranDate: "31-12-2025", From: "HDFC", To: "Food", Amount: 100},
+			drop: Transaction{ID: "d", TranDate: "01-01-2026", From: "HDFC", To: "Food", Amount: 100, FITID: "F-2"},
+			want: "F-2",
+		},
+		{
+			name: "keeps its own bank ID",
+			keep: Transaction{ID: "k", TranDate: "01-01-2026", From: "HDFC", To: "Food", Amount: 100, FITID: "F-1"},
+			drop: Transaction{ID: "d", TranDate: "02-01-2026", From: "HDFC", To: "Food", Amount: 100, FITID: "F-2"},
+			want: "F-1",
+		},
+		{
+			name: "neither has one",
+			keep: Transaction{ID: "k", TranDate: "01-01-2026", From: "HDFC", To: "Food", Amount: 100},
+			drop: Transaction{ID: "d", TranDate: "01-01-2026", From: "HDFC", To: "Food", Amount: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		useTestStore(t)
+		other := Transaction{ID: "o", TranDate: "05-01-2026", From: "HDFC", To: "Home", Amount: 5}
+		byYear := map[string][]Transaction{}
+		for _, tran := range []Transaction{tt.keep, tt.drop, other} {
+			year := tran.TranDate[6:]
+			byYear[year] = append(byYear[year], tran)
+		}
+		if err := store.Commit(Batch{Transactions: byYear}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mergeDuplicate("k", "d"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		transactions, err := readAllTransactions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := duplicateIDs(transactions); len(got) != 2 || findTransaction(transactions, "d") != nil {
+			t.Errorf("%s: transactions after the merge = %v", tt.name, got)
+		}
+		if kept := findTransaction(transactions, "k"); kept == nil || kept.FITID != tt.want {
+			t.Errorf("%s: kept transaction = %+v, want FITID %q", tt.name, kept, tt.want)
+		}
+	}
+
+	if err := mergeDuplicate("k", "missing"); !errors.Is(err, errTransactionNotFound) {
+		t.Errorf("merging a missing transaction: %v", err)
+	}
+}
+
+func findTransaction(transactions []Transaction, id string) *Transaction {
+	for i := range transactions {
+		if transactions[i].ID == id {
+			return &transactions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Envelope budgeting treats each expense account's budget as an envelope
+// of money. From the envelopeStart month on, whatever an envelope has left
+// at the end of a month, or has overspent, carries into the next month.
+// Income goes into a "to be budgeted" pool; each month's budgets are taken
+// from it, and allocations.csv records money moved between the pool and
+// envelopes or from one envelope to another. Allocations are in the base
+// currency.
+
+var allocationHeader = []string{"ID", "Date", "Month", "From", "To", "Amount", "Note"}
+
+var allocationsMutex sync.Mutex
+
+// Allocation moves money into, out of or between envelopes. An empty From
+// or To is the to-be-budgeted pool.
+type Allocation struct {
+	ID     string `json:"id"`
+	Date   string `json:"date"`  // when it was recorded (DD-MM-YYYY)
+	Month  string `json:"month"` // the budget month it applies to (MM-YYYY)
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount Money  `json:"amount"`
+	Note   string `json:"note,omitempty"`
+}
+
+// Envelope is one expense account's envelope in a month
+type Envelope struct {
+	Account   string `json:"account"`
+	Path      string `json:"path"`
+	Carried   Money  `json:"carried"`   // left over (or overspent) from the month before
+	Budgeted  Money  `json:"budgeted"`  // the month's budget
+	Allocated Money  `json:"allocated"` // moved in, less moved out
+	Spent     Money  `json:"spent"`
+	Available Money  `json:"available"` // carried into the next month
+}
+
+// EnvelopeMonth is the state of every envelope in a month
+type EnvelopeMonth struct {
+	Start        string       `json:"start"`
+	Month        string       `json:"month"`
+	Income       Money        `json:"income"`       // received this month
+	ToBeBudgeted Money        `json:"toBeBudgeted"` // income not yet given to an envelope, to date
+	Envelopes    []Envelope   `json:"envelopes"`
+	Allocations  []Allocation `json:"allocations"` // this month's
+}
+
+func readAllocations() ([]Allocation, error) {
+	rows, cols, err := store.ReadTable("allocations")
+	if err != nil {
+		return nil, err
+	}
+
+	var allocations []Allocation
+	for _, record := range rows {
+		amount, err := ParseMoney(columnValue(record, cols, "Amount"))
+		if err != nil {
+			log.Printf("Skipping allocation %s: %v", columnValue(record, cols, "ID"), err)
+			continue
+		}
+		allocations = append(allocations, Allocation{
+			ID:     columnValue(record, cols, "ID"),
+			Date:   columnValue(record, cols, "Date"),
+			Month:  columnValue(record, cols, "Month"),
+			From:   columnValue(record, cols, "From"),
+			To:     columnValue(record, cols, "To"),
+			Amount: amount,
+			Note:   columnValue(record, cols, "Note"),
+		})
+	}
+	return allocations, nil
+}
+
+func writeAllocations(allocations []Allocation) error {
+	rows := make([][]string, 0, len(allocations))
+	for _, a := range allocations {
+		rows = append(rows, []string{a.ID, a.Date, a.Month, a.From, a.To, a.Amount.String(), a.Note})
+	}
+	return store.WriteTable("allocations", allocationHeader, rows)
+}
+
+// envelopeBalances runs every envelope from the envelopeStart month
+// through month, which must not be before it. Budgets are converted at the
+// rate on the last day of their month, spending and income at the rate on
+// each transaction's date.
+func envelopeBalances(transactions []Transaction, accounts []Account, plan BudgetPlan, month string, conv *Converter) *EnvelopeMonth {
+	first, last := monthKey(plan.EnvelopeStart), monthKey(month)
+
+	// Spending and income per month
+	spent := make(map[string]map[string]Money)
+	income := make(map[string]Money)
+	for _, t := range transactions {
+		if len(t.TranDate) < 10 {
+			continue
+		}
+		m := t.TranDate[3:10]
+		if key := monthKey(m); key < first || key > last {
+			continue
+		}
+		if from := findAccount(accounts, t.From); from.Type == "INCOME" {
+			income[m] += conv.ToBase(t.Amount, accountCurrency(from, conv.Base), t.TranDate)
+		}
+		for _, leg := range t.Legs() {
+			acc := findAccount(accounts, leg.To)
+			if acc.Type != "EXPENSE" {
+				continue
+			}
+			if spent[m] == nil {
+				spent[m] = make(map[string]Money)
+			}
+			spent[m][acc.Name] += conv.ToBase(leg.Received(), accountCurrency(acc, conv.Base), t.TranDate)
+		}
+	}
+
+	start, _ := time.Parse("01-2006", plan.EnvelopeStart)
+	end, _ := time.Parse("01-2006", month)
+	envelopes := make(map[string]*Envelope)
+	var pool Money
+	for m := start; !m.After(end); m = m.AddDate(0, 1, 0) {
+		key := m.Format("01-2006")
+		asOf := m.AddDate(0, 1, -1)
+		if asOf.After(time.Now()) {
+			asOf = time.Now()
+		}
+
+		pool += income[key]
+		for _, acc := range accounts {
+			if acc.Type != "EXPENSE" {
+				continue
+			}
+			e := envelopes[acc.Name]
+			if e == nil {
+				e = &Envelope{Account: acc.Name, Path: accountPath(accounts, acc.Name)}
+				envelopes[acc.Name] = e
+			}
+			e.Carried = e.Available
+			e.Budgeted = conv.ToBase(budgetFor(acc, plan.Entries, key), accountCurrency(acc, conv.Base), asOf.Format("02-01-2006"))
+			e.Allocated = 0
+			e.Spent = spent[key][acc.Name]
+			pool -= e.Budgeted
+		}
+
+		// Money moved to or from an account that is no longer an envelope
+		// stays in the pool
+		for _, a := range plan.Allocations {
+			if a.Month != key {
+				continue
+			}
+			if e := envelopes[a.From]; e != nil {
+				e.Allocated -= a.Amount
+			} else if a.From == "" {
+				pool -= a.Amount
+			}
+			if e := envelopes[a.To]; e != nil {
+				e.Allocated += a.Amount
+			} else if a.To == "" {
+				pool += a.Amount
+			}
+		}
+
+		for _, e := range envelopes {
+			e.Available = e.Carried + e.Budgeted + e.Allocated - e.Spent
+		}
+	}
+
+	result := &EnvelopeMonth{
+		Start:        plan.EnvelopeStart,
+		Month:        month,
+		Income:       income[month],
+		ToBeBudgeted: pool,
+		Envelopes:    []Envelope{},
+		Allocations:  []Allocation{},
+	}
+	for _, e := range envelopes {
+		if e.Carried != 0 || e.Budgeted != 0 || e.Allocated != 0 || e.Spent != 0 {
+			result.Envelopes = append(result.Envelopes, *e)
+		}
+	}
+	sort.SliceStable(result.Envelopes, func(i, j int) bool {
+		return result.Envelopes[i].Path < result.Envelopes[j].Path
+	})
+	for _, a := range plan.Allocations {
+		if a.Month == month {
+			result.Allocations = append(result.Allocations, a)
+		}
+	}
+	return result
+}
+
+// validateAllocation checks a move against the accounts and the envelope
+// start month
+func validateAllocation(a *Allocation, start string) error {
+	a.Month = sanitizeInput(a.Month)
+	a.From = sanitizeInput(a.From)
+	a.To = sanitizeInput(a.To)
+	a.Note = sanitizeInput(a.Note)
+
+	if start == "" {
+		return errors.New("envelope budgeting is off (set envelopeStart)")
+	}
+	if !isValidMonth(a.Month) {
+		return errors.New("invalid month format (use MM-YYYY)")
+	}
+	if monthKey(a.Month) < monthKey(start) {
+		return fmt.Errorf("month is before envelopeStart (%s)", start)
+	}
+	if a.Amount <= 0 || a.Amount > MAX_AMOUNT {
+		return errors.New("amount must be positive")
+	}
+	if a.From == a.To {
+		return errors.New("from and to must differ")
+	}
+	if len(a.Note) > 100 {
+		return errors.New("note too long (max 100 characters)")
+	}
+
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{a.From, a.To} {
+		if name == "" {
+			continue
+		}
+		acc := findAccount(accounts, name)
+		if acc.Name == "" {
+			return fmt.Errorf("unknown account: %s", name)
+		}
+		if acc.Type != "EXPENSE" {
+			return fmt.Errorf("%s is not an expense account", name)
+		}
+	}
+	return nil
+}
+
+// handleEnvelopes shows every envelope in a month:
+// GET /api/envelopes?month=10-2026
+func handleEnvelopes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().Format("01-2006")
+	} else if !isValidMonth(month) {
+		respondError(w, "invalid month format (use MM-YYYY)", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := loadBudgetPlan()
+	if err != nil {
+		respondError(w, "Failed to load budgets", http.StatusInternalServerError)
+		return
+	}
+	if plan.EnvelopeStart == "" {
+		respondError(w, "envelope budgeting is off (set envelopeStart)", http.StatusBadRequest)
+		return
+	}
+	if monthKey(month) < monthKey(plan.EnvelopeStart) {
+		respondError(w, fmt.Sprintf("month is before envelopeStart (%s)", plan.EnvelopeStart), http.StatusBadRequest)
+		return
+	}
+
+	transactions, accounts, _, conv, err := loadReportData()
+	if err != nil {
+		respondError(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"baseCurrency": conv.Base,
+		"envelopes":    envelopeBalances(transactions, accounts, plan, month, conv),
+	})
+}
+
+// handleEnvelopeMove records and undoes moves of money:
+// POST /api/envelopes/move {"month": "10-2026", "from": "Dining", "to": "Food", "amount": 200}
+// DELETE /api/envelopes/move {"id": "..."}
+func handleEnvelopeMove(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodPost:
+		var a Allocation
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+		if err := validateAllocation(&a, getSetting("envelopeStart")); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id, err := newTransactionID()
+		if err != nil {
+			respondError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		a.ID = id
+		a.Date = time.Now().Format("02-01-2006")
+
+		allocationsMutex.Lock()
+		allocations, err := readAllocations()
+		if err == nil {
+			err = writeAllocations(append(allocations, a))
+		}
+		allocationsMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to save allocation", http.StatusInternalServerError)
+			return
+		}
+
+		logSecurityEvent("ENVELOPE_MOVE", getClientIP(r), fmt.Sprintf("Moved %s from %q to %q in %s", a.Amount, a.From, a.To, a.Month))
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "allocation": a})
+
+	case http.MethodDelete:
+		var data map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+		id := sanitizeInput(data["id"])
+
+		allocationsMutex.Lock()
+		defer allocationsMutex.Unlock()
+
+		allocations, err := readAllocations()
+		if err != nil {
+			respondError(w, "Failed to load allocations", http.StatusInternalServerError)
+			return
+		}
+		kept := make([]Allocation, 0, len(allocations))
+		for _, a := range allocations {
+			if a.ID != id {
+				kept = append(kept, a)
+			}
+		}
+		if id == "" || len(kept) == len(allocations) {
+			respondError(w, "Allocation not found", http.StatusNotFound)
+			return
+		}
+		if err := writeAllocations(kept); err != nil {
+			respondError(w, "Failed to save allocations", http.StatusInternalServerError)
+			return
+		}
+
+		logSecurityEvent("ENVELOPE_MOVE", getClientIP(r), fmt.Sprintf("Removed allocation %s", id))
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnvelopeBalances(t *testing.T) {
+	useTestStore(t)
+	if err := writeRates([]ExchangeRate{{Date: "01-08-2026", From: "USD", To: "INR", Rate: "80"}}); err != nil {
+		t.Fatal(err)
+	}
+	conv, err := loadConverter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts := []Account{
+		{Name: "HDFC", Type: "ASSET"},
+		{Name: "Salary", Type: "INCOME"},
+		{Name: "Food", Type: "EXPENSE", Budget: 100000},
+		{Name: "Home", Type: "EXPENSE", Budget: 50000},
+		{Name: "Trips", Type: "EXPENSE", Budget: 1000, Currency: "USD"}, // 800.00 in INR
+		{Name: "Unused", Type: "EXPENSE"},
+	}
+	transactions := []Transaction{
+		// Before the start, so not counted
+		{TranDate: "31-07-2026", From: "Salary", To: "HDFC", Amount: 999900},
+		{TranDate: "31-07-2026", From: "HDFC", To: "Food", Amount: 999900},
+
+		{TranDate: "01-08-2026", From: "Salary", To: "HDFC", Amount: 500000},
+		{TranDate: "05-08-2026", From: "HDFC", To: "Food", Amount: 120000},
+		{TranDate: "09-08-2026", From: "HDFC", To: "Trips", Amount: 40000, ToAmount: 500},
+		{TranDate: "10-09-2026", From: "HDFC", To: "Home", Amount: 30000},
+		{TranDate: "12-09-2026", From: "HDFC", Amount: 50000, Splits: []Split{{To: "Food", Amount: 40000}, {To: "Home", Amount: 10000}}},
+		{TranDate: "02-10-2026", From: "Salary", To: "HDFC", Amount: 300000},
+		// Money paid back to an income account is neither income nor spending
+		{TranDate: "03-10-2026", From: "HDFC", To: "Salary", Amount: 100},
+	}
+	plan := BudgetPlan{
+		EnvelopeStart: "08-2026",
+		Entries:       []BudgetEntry{{Account: "Home", Month: "10-2026", Amount: 80000}},
+		Allocations: []Allocation{
+			{ID: "1", Month: "09-2026", To: "Food", Amount: 20000},
+			{ID: "2", Month: "09-2026", From: "Food", To: "Home", Amount: 10000},
+			{ID: "3", Month: "10-2026", From: "Home", Amount: 5000},
+		},
+	}
+
+	tests := []struct {
+		month  string
+		income Money
+		pool   Money
+		want   []Envelope
+	}{
+		{
+			// 5000 of income less 1000 + 500 + 800 of budgets
+			month: "08-2026", income: 500000, pool: 270000,
+			want: []Envelope{
+				{Account: "Food", Path: "Food", Budgeted: 100000, Spent: 120000, Available: -20000},
+				{Account: "Home", Path: "Home", Budgeted: 50000, Available: 50000},
+				{Account: "Trips", Path: "Trips", Budgeted: 80000, Spent: 40000, Available: 40000},
+			},
+		},
+		{
+			// 2700 less 2300 of budgets, less 200 given to Food
+			month: "09-2026", pool: 20000,
+			want: []Envelope{
+				{Account: "Food", Path: "Food", Carried: -20000, Budgeted: 100000, Allocated: 10000, Spent: 40000, Available: 50000},
+				{Account: "Home", Path: "Home", Carried: 50000, Budgeted: 50000, Allocated: 10000, Spent: 40000, Available: 70000},
+				{Account: "Trips", Path: "Trips", Carried: 40000, Budgeted: 80000, Available: 120000},
+			},
+		},
+		{
+			// 200 plus 3000 of income, less 2600 of budgets, plus 50 back from Home
+			month: "10-2026", income: 300000, pool: 65000,
+			want: []Envelope{
+				{Account: "Food", Path: "Food", Carried: 50000, Budgeted: 100000, Available: 150000},
+				{Account: "Home", Path: "Home", Carried: 70000, Budgeted: 80000, Allocated: -5000, Available: 145000},
+				{Account: "Trips", Path: "Trips", Carried: 120000, Budgeted: 80000, Available: 200000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := envelopeBalances(transactions, accounts, plan, tt.month, conv)
+		if got.Income != tt.income || got.ToBeBudgeted != tt.pool {
+			t.Errorf("%s: income %s, to be budgeted %s; want %s, %s", tt.month, got.Income, got.ToBeBudgeted, tt.income, tt.pool)
+		}
+		if !reflect.DeepEqual(got.Envelopes, tt.want) {
+			t.Errorf("%s: envelopes\n%+v\nwant\n%+v", tt.month, got.Envelopes, tt.want)
+		}
+	}
+
+	// Money is only moved around: by October the pool and the envelopes
+	// hold all income less all spending
+	october := envelopeBalances(transactions, accounts, plan, "10-2026", conv)
+	held := october.ToBeBudgeted
+	for _, e := range october.Envelopes {
+		held += e.Available
+	}
+	if income, spent := Money(500000+300000), Money(120000+40000+30000+50000); held != income-spent {
+		t.Errorf("pool and envelopes hold %s, want %s", held, income-spent)
+	}
+
+	if got := envelopeBalances(transactions, accounts, plan, "09-2026", conv).Allocations; len(got) != 2 || got[0].ID != "1" || got[1].ID != "2" {
+		t.Errorf("09-2026 allocations = %+v", got)
+	}
+}
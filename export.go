@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Accounts, transactions, security prices and exchange rates can be
+// exported as a ledger-cli, hledger or beancount journal for reports those
+// tools do better. Account types become the usual top-level trees (Assets,
+// Liabilities, Income, Expenses) and parent accounts become the path
+// below them. Names are reduced to letters, digits and hyphens so every
+// tool accepts them.
+//
+// Each posting is in its account's currency: cross-currency legs carry the
+// amount paid as a total price (@@), and securities are posted as units of
+// their own commodity priced at the cash amount.
+
+// Journal formats
+const (
+	EXPORT_LEDGER    = "ledger"
+	EXPORT_HLEDGER   = "hledger"
+	EXPORT_BEANCOUNT = "beancount"
+)
+
+var exportExtensions = map[string]string{
+	EXPORT_LEDGER:    "ledger",
+	EXPORT_HLEDGER:   "journal",
+	EXPORT_BEANCOUNT: "beancount",
+}
+
+var accountRoots = map[string]string{
+	"ASSET":       "Assets",
+	"LIABILITIES": "Liabilities",
+	"INCOME":      "Income",
+	"EXPENSE":     "Expenses",
+}
+
+// hledger's account type codes
+var hledgerAccountTypes = map[string]string{
+	"ASSET":       "A",
+	"LIABILITIES": "L",
+	"INCOME":      "R",
+	"EXPENSE":     "X",
+}
+
+// Journal is everything a journal export contains
+type Journal struct {
+	Base         string
+	Accounts     []Account
+	Transactions []Transaction // any order
+	Prices       []SecurityPrice
+	Rates        []ExchangeRate
+}
+
+// loadJournal reads the journal from the store
+func loadJournal() (Journal, error) {
+	var j Journal
+	var err error
+	if j.Accounts, err = readAccounts(); err != nil {
+		return j, err
+	}
+	if j.Transactions, err = readAllTransactions(); err != nil {
+		return j, err
+	}
+	if j.Prices, err = readPrices(); err != nil {
+		return j, err
+	}
+	if j.Rates, err = readRates(); err != nil {
+		return j, err
+	}
+	j.Base = getSetting("baseCurrency")
+	return j, nil
+}
+
+// journalComponent makes one level of an account name safe for every
+// format: letters and digits, other runs of characters become a hyphen,
+// and the first letter is upper case
+func journalComponent(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range html.UnescapeString(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+		} else {
+			hyphen = true
+		}
+	}
+	s := []rune(b.String())
+	if len(s) == 0 {
+		return "Account"
+	}
+	s[0] = unicode.ToUpper(s[0])
+	return string(s)
+}
+
+// journalAccountNames maps every account to its full journal name, e.g.
+// Food & Drink under Living becomes Expenses:Living:Food-Drink. Names that
+// collide after cleaning get a numeric suffix.
+func journalAccountNames(accounts []Account) map[string]string {
+	sorted := append([]Account(nil), accounts...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	names := make(map[string]string, len(accounts))
+	used := make(map[string]bool, len(accounts))
+	for _, acc := range sorted {
+		root, ok := accountRoots[acc.Type]
+		if !ok {
+			root = "Equity"
+		}
+		parts := []string{root}
+		for _, part := range strings.Split(accountPath(accounts, acc.Name), ":") {
+			parts = append(parts, journalComponent(part))
+		}
+		name := strings.Join(parts, ":")
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%s-%d", strings.Join(parts, ":"), n)
+		}
+		used[name] = true
+		names[acc.Name] = name
+	}
+	return names
+}
+
+// journalCommodity names a security. Beancount wants upper-case letters,
+// digits and ._-' starting with a letter; ledger and hledger quote any
+// symbol that is not only letters.
+func journalCommodity(format, security string) string {
+	security = html.UnescapeString(security)
+	if format == EXPORT_BEANCOUNT {
+		var b strings.Builder
+		for _, r := range strings.ToUpper(security) {
+			switch {
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-', r == '\'':
+				b.WriteRune(r)
+			default:
+				b.WriteByte('-')
+			}
+		}
+		s := strings.Trim(b.String(), "-._'")
+		if s == "" || s[0] < 'A' || s[0] > 'Z' {
+			s = "X" + s
+		}
+		if len(s) > 24 {
+			s = strings.TrimRight(s[:24], "-._'")
+		}
+		return s
+	}
+
+	for _, r := range security {
+		if !unicode.IsLetter(r) {
+			return `"` + strings.ReplaceAll(security, `"`, "") + `"`
+		}
+	}
+	return security
+}
+
+// journalText unescapes a stored description and strips what would end it
+// early: newlines everywhere, ";" (a comment) in ledger and hledger
+func journalText(format, s string) string {
+	s = strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+	s = strings.TrimPrefix(s, "'") // sanitizeInput's CSV-injection guard
+	if format == EXPORT_BEANCOUNT {
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+	}
+	return strings.ReplaceAll(s, ";", ",")
+}
+
+// journalDate turns DD-MM-YYYY into YYYY-MM-DD, or YYYY/MM/DD for ledger
+func journalDate(format, date string) string {
+	if len(date) != 10 {
+		return date
+	}
+	sep := "-"
+	if format == EXPORT_LEDGER {
+		sep = "/"
+	}
+	return date[6:10] + sep + date[3:5] + sep + date[0:2]
+}
+
+// writeJournal writes j in the given format
+func writeJournal(out io.Writer, format string, j Journal) error {
+	if _, ok := exportExtensions[format]; !ok {
+		return errors.New("format must be ledger, hledger or beancount")
+	}
+
+	w := bufio.NewWriter(out)
+	names := journalAccountNames(j.Accounts)
+	currencies := make(map[string]string, len(j.Accounts))
+	for _, acc := range j.Accounts {
+		currencies[acc.Name] = accountCurrency(acc, j.Base)
+	}
+	posting := func(account, amount string) {
+		fmt.Fprintf(w, "    %-50s  %s\n", names[account], amount)
+	}
+
+	transactions := append([]Transaction(nil), j.Transactions...)
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return dateKey(transactions[i].TranDate)+transactions[i].TranTime < dateKey(transactions[j].TranDate)+transactions[j].TranTime
+	})
+
+	comment := ";"
+	if format == EXPORT_BEANCOUNT {
+		comment = ";;"
+	}
+	fmt.Fprintf(w, "%s Exported from arthik on %s\n\n", comment, time.Now().Format("2006-01-02"))
+	if format == EXPORT_BEANCOUNT {
+		fmt.Fprintf(w, "option \"operating_currency\" \"%s\"\n\n", j.Base)
+	}
+
+	// Account declarations; beancount opens every account on the first
+	// transaction date
+	opened := time.Now().Format("02-01-2006")
+	if len(transactions) > 0 {
+		opened = transactions[0].TranDate
+	}
+	accounts := append([]Account(nil), j.Accounts...)
+	sort.SliceStable(accounts, func(a, b int) bool { return names[accounts[a].Name] < names[accounts[b].Name] })
+	for _, acc := range accounts {
+		switch format {
+		case EXPORT_BEANCOUNT:
+			fmt.Fprintf(w, "%s open %s\n", journalDate(format, opened), names[acc.Name])
+		case EXPORT_HLEDGER:
+			fmt.Fprintf(w, "account %-50s  ; type: %s\n", names[acc.Name], hledgerAccountTypes[acc.Type])
+		default:
+			fmt.Fprintf(w, "account %s\n", names[acc.Name])
+		}
+	}
+	if format == EXPORT_BEANCOUNT {
+		for _, acc := range accounts {
+			if acc.Closed() {
+				closed := acc.ClosedDate
+				if compareDates(opened, closed) {
+					closed = opened
+				}
+				fmt.Fprintf(w, "%s close %s\n", journalDate(format, closed), names[acc.Name])
+			}
+		}
+	}
+	fmt.Fprintln(w)
+
+	// Prices: exchange rates, then securities in their holding account's
+	// currency
+	securityCurrencies := make(map[string]string)
+	for _, t := range transactions {
+		if t.Security != "" {
+			securityCurrencies[t.Security] = currencies[t.HoldingAccount()]
+		}
+	}
+	price := func(date, commodity, value, currency string) {
+		if format == EXPORT_BEANCOUNT {
+			fmt.Fprintf(w, "%s price %s %s %s\n", journalDate(format, date), commodity, value, currency)
+		} else {
+			fmt.Fprintf(w, "P %s %s %s %s\n", journalDate(format, date), commodity, value, currency)
+		}
+	}
+	for _, r := range j.Rates {
+		price(r.Date, r.From, r.Rate, r.To)
+	}
+	for _, p := range j.Prices {
+		currency := securityCurrencies[p.Security]
+		if currency == "" {
+			currency = j.Base
+		}
+		price(p.Date, journalCommodity(format, p.Security), p.Price.String(), currency)
+	}
+	if len(j.Rates) > 0 || len(j.Prices) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	for _, t := range transactions {
+		description := journalText(format, t.Description)
+		from := currencies[t.From]
+
+		switch format {
+		case EXPORT_BEANCOUNT:
+			fmt.Fprintf(w, "%s * \"%s\"", journalDate(format, t.TranDate), description)
+			for _, tag := range t.Tags {
+				fmt.Fprintf(w, " #%s", tag)
+			}
+			fmt.Fprintf(w, "\n    id: \"%s\"\n    time: \"%s\"\n", t.ID, t.TranTime)
+			if t.FITID != "" {
+				fmt.Fprintf(w, "    fitid: \"%s\"\n", journalText(format, t.FITID))
+			}
+		case EXPORT_HLEDGER:
+			fmt.Fprintf(w, "%s %s  ; id:%s, time:%s", journalDate(format, t.TranDate), description, t.ID, t.TranTime)
+			if t.FITID != "" {
+				fmt.Fprintf(w, ", fitid:%s", journalText(format, t.FITID))
+			}
+			for _, tag := range t.Tags {
+				fmt.Fprintf(w, ", %s:", tag)
+			}
+			fmt.Fprintln(w)
+		default:
+			fmt.Fprintf(w, "%s %s\n    ; id: %s\n    ; time: %s\n", journalDate(format, t.TranDate), description, t.ID, t.TranTime)
+			if t.FITID != "" {
+				fmt.Fprintf(w, "    ; fitid: %s\n", journalText(format, t.FITID))
+			}
+			if len(t.Tags) > 0 {
+				fmt.Fprintf(w, "    ; :%s:\n", strings.Join(t.Tags, ":"))
+			}
+		}
+
+		// A buy puts units into To; a sell takes them out of From
+		if t.Security != "" && t.Units != 0 {
+			commodity := journalCommodity(format, t.Security)
+			if t.Units > 0 {
+				posting(t.To, fmt.Sprintf("%s %s @@ %s %s", t.Units, commodity, t.Amount, from))
+				posting(t.From, fmt.Sprintf("%s %s", -t.Amount, from))
+			} else {
+				received := t.Legs()[0].Received()
+				posting(t.To, fmt.Sprintf("%s %s", received, currencies[t.To]))
+				posting(t.From, fmt.Sprintf("%s %s @@ %s %s", t.Units, commodity, received, currencies[t.To]))
+			}
+			fmt.Fprintln(w)
+			continue
+		}
+
+		for _, leg := range t.Legs() {
+			if leg.ToAmount != 0 && currencies[leg.To] != from {
+				posting(leg.To, fmt.Sprintf("%s %s @@ %s %s", leg.ToAmount, currencies[leg.To], leg.Amount, from))
+			} else {
+				posting(leg.To, fmt.Sprintf("%s %s", leg.Amount, from))
+			}
+		}
+		posting(t.From, fmt.Sprintf("%s %s", -t.Amount, from))
+		fmt.Fprintln(w)
+	}
+
+	return w.Flush()
+}
+
+// handleExport downloads the journal: GET /api/export?format=beancount
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = EXPORT_LEDGER
+	}
+	extension, ok := exportExtensions[format]
+	if !ok {
+		respondError(w, "format must be ledger, hledger or beancount", http.StatusBadRequest)
+		return
+	}
+
+	journal, err := loadJournal()
+	if err != nil {
+		respondError(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="arthik.%s"`, extension))
+	if err := writeJournal(w, format, journal); err != nil {
+		return
+	}
+	logSecurityEvent("EXPORT", getClientIP(r), fmt.Sprintf("Exported %d transactions as %s", len(journal.Transactions), format))
+}
@@ -1,11 +1,15 @@
 // Global state
 let currentPage = 1;
 let totalTransactions = 0;
+let pageSize = 30;
 let accounts = [];
 let dashboardData = null;
 let netWorthChart = null;
 let budgetChart = null;
 let portfolioChart = null;
+let trendChart = null;
+let trendData = null;
+let baseCurrency = 'INR'; // from the dashboard; amounts in reports are in it
 let editingTransaction = null;
 let editingAccount = null;
 let csrfToken = null;
@@ -36,7 +40,7 @@ document.addEventListener('DOMContentLoaded', () => {
         
         switch(action) {
             case 'edit-transaction':
-                editTransaction(target.getAttribute('data-date'), target.getAttribute('data-time'));
+                editTransaction(target.getAttribute('data-id'));
                 break;
             case 'save-edit-transaction':
                 saveEditTransaction();
@@ -45,7 +49,7 @@ document.addEventListener('DOMContentLoaded', () => {
                 loadTransactions(currentPage);
                 break;
             case 'delete-transaction':
-                deleteTransaction(target.getAttribute('data-date'), target.getAttribute('data-time'));
+                deleteTransaction(target.getAttribute('data-id'));
                 break;
             case 'save-transaction':
                 saveTransaction();
@@ -56,6 +60,42 @@ document.addEventListener('DOMContentLoaded', () => {
             case 'show-add-transaction':
                 showAddTransactionForm();
                 break;
+            case 'add-split-leg':
+                addSplitLeg(document.getElementById(target.getAttribute('data-target')));
+                break;
+            case 'remove-split-leg':
+                target.closest('.split-leg').remove();
+                break;
+            case 'search-transactions':
+                loadTransactions(1);
+                break;
+            case 'clear-search':
+                clearSearch();
+                break;
+            case 'show-import':
+                showImportPanel();
+                break;
+            case 'hide-import':
+                document.getElementById('importPanel').style.display = 'none';
+                break;
+            case 'preview-import':
+                runImport(false);
+                break;
+            case 'commit-import':
+                runImport(true);
+                break;
+            case 'show-duplicates':
+                loadDuplicates();
+                break;
+            case 'hide-duplicates':
+                document.getElementById('duplicatesPanel').style.display = 'none';
+                break;
+            case 'merge-duplicate':
+                mergeDuplicate(target.getAttribute('data-keep'), target.getAttribute('data-remove'));
+                break;
+            case 'dismiss-duplicate':
+                dismissDuplicate(target.getAttribute('data-first'), target.getAttribute('data-second'));
+                break;
             case 'prev-page':
                 changePage(-1);
                 break;
@@ -65,6 +105,12 @@ document.addEventListener('DOMContentLoaded', () => {
             case 'edit-account':
                 editAccount(target.getAttribute('data-account'));
                 break;
+            case 'archive-account':
+                archiveAccount(target.getAttribute('data-account'));
+                break;
+            case 'reopen-account':
+                reopenAccount(target.getAttribute('data-account'));
+                break;
             case 'save-account':
                 saveAccount();
                 break;
@@ -83,6 +129,30 @@ document.addEventListener('DOMContentLoaded', () => {
             case 'change-password':
                 changePassword();
                 break;
+            case 'save-base-currency':
+                saveBaseCurrency();
+                break;
+            case 'save-budget':
+                saveBudget();
+                break;
+            case 'move-envelope':
+                moveEnvelope();
+                break;
+            case 'undo-move':
+                undoEnvelopeMove(target.getAttribute('data-id'));
+                break;
+            case 'export-journal':
+                window.location.href = `/api/export?format=${encodeURIComponent(document.getElementById('exportFormat').value)}`;
+                break;
+            case 'save-rule':
+                saveRule();
+                break;
+            case 'delete-rule':
+                deleteRule(target.getAttribute('data-name'));
+                break;
+            case 'apply-rules':
+                applyRules();
+                break;
             case 'logout':
                 logout();
                 break;
@@ -106,6 +176,39 @@ document.addEventListener('DOMContentLoaded', () => {
             case 'toggle-hide-amount':
                 toggleHideAmount();
                 break;
+            case 'toggle-apply-rules':
+                saveApplyRules(target.checked);
+                break;
+            case 'save-fiscal-year':
+                saveFiscalYearStart(target.value);
+                break;
+            case 'save-envelope-start':
+                saveEnvelopeStart(target.value);
+                break;
+            case 'load-trends':
+                loadTrends();
+                break;
+            case 'load-dashboard':
+                loadDashboard();
+                break;
+            case 'render-trends':
+                renderTrends();
+                break;
+            case 'suggest-transaction':
+                suggestTransaction();
+                break;
+        }
+    });
+
+    // Event delegation for input events
+    document.body.addEventListener('input', (e) => {
+        const target = e.target.closest('[data-input]');
+        if (!target) return;
+
+        switch(target.getAttribute('data-input')) {
+            case 'suggest-transaction':
+                suggestTransaction();
+                break;
         }
     });
 
@@ -223,6 +326,9 @@ function switchTab(tab) {
         loadTransactions();
     } else if (tab === 'account') {
         loadAccounts();
+    } else if (tab === 'setting') {
+        loadServerSettings();
+        loadRules();
     }
 }
 
@@ -253,6 +359,12 @@ async function apiCall(url, options = {}) {
             return null;
         }
 
+        // Callers that handle conflicts themselves get the error back
+        if (response.status === 409 && options.allowConflict) {
+            const error = await response.json();
+            return { conflict: true, error: error.error };
+        }
+
         if (!response.ok) {
             const error = await response.json();
             throw new Error(error.error || 'Request failed');
@@ -268,10 +380,21 @@ async function apiCall(url, options = {}) {
 
 // Dashboard functions
 async function loadDashboard() {
-    const data = await apiCall('/api/dashboard');
+    // The budget month picker gives YYYY-MM; the API takes MM-YYYY
+    const picked = document.getElementById('budgetMonth').value;
+    let url = '/api/dashboard';
+    if (picked) {
+        const [year, month] = picked.split('-');
+        url += `?month=${month}-${year}`;
+    }
+    const data = await apiCall(url);
     if (!data) return;
 
     dashboardData = data;
+    baseCurrency = data.baseCurrency || baseCurrency;
+    document.querySelectorAll('.base-currency').forEach(el => {
+        el.innerHTML = currencySymbol(baseCurrency);
+    });
     if (data.csrfToken) {
         csrfToken = data.csrfToken;
         console.log('CSRF Token updated:', csrfToken ? 'Yes' : 'No');
@@ -283,9 +406,237 @@ async function loadDashboard() {
     
     renderNetWorthChart(data.records);
     renderBudgetChart(data.budget);
+    renderBudgetForm(data);
+    renderEnvelopes(data.budget.envelopes, data.accounts);
     renderAccountPills(data.accounts);
-    renderPortfolioChart(data.accounts);
+    renderPortfolioChart(data.portfolio || data.accounts);
     renderUpcomingBills(data.upcomingBills);
+    renderHoldings(data.holdings || []);
+    loadTrends();
+}
+
+// Sets the budget of an expense account for the month shown, from that
+// month on or for that month only (see budgets.go)
+function renderBudgetForm(data) {
+    const [month, year] = data.budgetMonth.split('-');
+    document.getElementById('budgetMonth').value = `${year}-${month}`;
+
+    const select = document.getElementById('budgetAccount');
+    const selected = select.value;
+    select.innerHTML = data.accounts
+        .filter(acc => acc.type === 'EXPENSE')
+        .map(acc => `<option value="${escapeHtml(acc.account)}">${escapeHtml(acc.account)}</option>`)
+        .join('');
+    if (selected) select.value = selected;
+}
+
+async function saveBudget() {
+    const account = document.getElementById('budgetAccount').value;
+    const amount = parseFloat(document.getElementById('budgetAmount').value);
+
+    if (!account || isNaN(amount) || amount < 0) {
+        alert('Choose an expense account and a budget');
+        return;
+    }
+
+    const result = await apiCall('/api/budgets', {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            account,
+            month: dashboardData.budgetMonth,
+            amount,
+            once: document.getElementById('budgetOnce').checked
+        })
+    });
+
+    if (result && result.success) {
+        document.getElementById('budgetAmount').value = '';
+        loadDashboard();
+    }
+}
+
+// Envelopes carry what is left of each budget into the next month (see
+// envelopes.go); money moves between them and the to-be-budgeted pool
+function renderEnvelopes(envelopes, accounts) {
+    const panel = document.getElementById('envelopePanel');
+    if (!envelopes) {
+        panel.style.display = 'none';
+        return;
+    }
+    panel.style.display = 'block';
+
+    const toBeBudgeted = document.getElementById('toBeBudgeted');
+    toBeBudgeted.textContent = formatAmount(envelopes.toBeBudgeted);
+    toBeBudgeted.parentElement.classList.toggle('negative', envelopes.toBeBudgeted < 0);
+
+    const options = '<option value="">To be budgeted</option>' + accounts
+        .filter(acc => acc.type === 'EXPENSE')
+        .map(acc => `<option value="${escapeHtml(acc.account)}">${escapeHtml(acc.account)}</option>`)
+        .join('');
+    ['envelopeFrom', 'envelopeTo'].forEach(id => {
+        const select = document.getElementById(id);
+        const selected = select.value;
+        select.innerHTML = options;
+        select.value = selected;
+    });
+
+    document.getElementById('envelopeTable').innerHTML = envelopes.envelopes.map(e => `<tr>
+        <td>${escapeHtml(e.path)}</td>
+        <td>${formatBaseAmount(e.carried)}</td>
+        <td>${formatBaseAmount(e.budgeted)}</td>
+        <td>${formatBaseAmount(e.allocated)}</td>
+        <td>${formatBaseAmount(e.spent)}</td>
+        <td class="${e.available < 0 ? 'negative' : ''}">${formatBaseAmount(e.available)}</td>
+    </tr>`).join('');
+
+    document.getElementById('allocationTable').innerHTML = envelopes.allocations.map(a => `<tr>
+        <td>${escapeHtml(a.date)}</td>
+        <td>${escapeHtml(a.from || 'To be budgeted')} → ${escapeHtml(a.to || 'To be budgeted')}</td>
+        <td>${formatBaseAmount(a.amount)}</td>
+        <td>${escapeHtml(a.note || '')}</td>
+        <td>
+            <button class="btn-icon btn-delete" data-action="undo-move" data-id="${escapeHtml(a.id)}" title="Undo">
+                <span class="material-icons">undo</span>
+            </button>
+        </td>
+    </tr>`).join('');
+}
+
+async function moveEnvelope() {
+    const from = document.getElementById('envelopeFrom').value;
+    const to = document.getElementById('envelopeTo').value;
+    const amount = parseFloat(document.getElementById('envelopeAmount').value);
+
+    if (from === to) {
+        alert('Choose two different envelopes');
+        return;
+    }
+    if (isNaN(amount) || amount <= 0) {
+        alert('Amount must be positive');
+        return;
+    }
+
+    const result = await apiCall('/api/envelopes/move', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            month: dashboardData.budgetMonth,
+            from,
+            to,
+            amount,
+            note: document.getElementById('envelopeNote').value.trim()
+        })
+    });
+
+    if (result && result.success) {
+        document.getElementById('envelopeAmount').value = '';
+        document.getElementById('envelopeNote').value = '';
+        loadDashboard();
+    }
+}
+
+async function undoEnvelopeMove(id) {
+    const result = await apiCall('/api/envelopes/move', {
+        method: 'DELETE',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ id })
+    });
+
+    if (result && result.success) {
+        loadDashboard();
+    }
+}
+
+// Monthly spending per expense account and cash flow (see GET /api/reports/trends)
+async function loadTrends() {
+    const months = document.getElementById('trendMonths').value;
+    const data = await apiCall(`/api/reports/trends?months=${encodeURIComponent(months)}`);
+    if (!data) return;
+
+    trendData = data;
+    baseCurrency = data.baseCurrency || baseCurrency;
+    renderTrends();
+}
+
+function renderTrends() {
+    if (!trendData) return;
+    const ctx = document.getElementById('trendChart');
+    const view = document.getElementById('trendView').value;
+
+    if (trendChart) {
+        trendChart.destroy();
+    }
+
+    const theme = document.body.getAttribute('data-theme') || 'purple';
+    const colorMap = {
+        purple: ['#9333ea', '#c084fc', '#a855f7', '#d8b4fe', '#e9d5ff'],
+        blue: ['#2563eb', '#60a5fa', '#3b82f6', '#93c5fd', '#dbeafe'],
+        green: ['#16a34a', '#4ade80', '#22c55e', '#86efac', '#dcfce7'],
+        orange: ['#ea580c', '#fb923c', '#f97316', '#fdba74', '#fed7aa'],
+        pink: ['#db2777', '#f472b6', '#ec4899', '#f9a8d4', '#fce7f3'],
+        teal: ['#0d9488', '#2dd4bf', '#14b8a6', '#5eead4', '#ccfbf1']
+    };
+    const colors = colorMap[theme];
+    const formatChange = c => c === null ? '—' : `${c > 0 ? '+' : ''}${c.toFixed(1)}%`;
+
+    let datasets;
+    const thead = document.querySelector('#trendTable thead');
+    const tbody = document.querySelector('#trendTable tbody');
+    if (view === 'cashflow') {
+        const flow = trendData.cashFlow;
+        datasets = [
+            { type: 'bar', label: 'Inflows', data: flow.inflows, backgroundColor: '#16a34a' },
+            { type: 'bar', label: 'Outflows', data: flow.outflows.map(v => -v), backgroundColor: '#dc2626' },
+            { type: 'line', label: 'Net', data: flow.net, borderColor: colors[0], tension: 0.3 }
+        ];
+        thead.innerHTML = '<tr><th>Account</th><th>In</th><th>Out</th><th>Net</th></tr>';
+        tbody.innerHTML = flow.accounts.map(row => {
+            const sum = values => values.reduce((a, b) => a + b, 0);
+            return `<tr>
+                <td>${escapeHtml(row.path)}</td>
+                <td>${formatBaseAmount(sum(row.inflows))}</td>
+                <td>${formatBaseAmount(sum(row.outflows))}</td>
+                <td>${formatBaseAmount(sum(row.net))}</td>
+            </tr>`;
+        }).join('');
+    } else {
+        // Top-level accounts stack up to the total; sub-accounts are in the table
+        const rows = trendData.expenses.accounts;
+        datasets = rows.filter(row => !row.path.includes(':')).map((row, idx) => ({
+            type: 'bar',
+            label: row.account,
+            data: row.amounts,
+            backgroundColor: colors[idx % colors.length]
+        }));
+        thead.innerHTML = '<tr><th>Account</th><th>Average</th><th>Min</th><th>Max</th><th>Last change</th></tr>';
+        tbody.innerHTML = rows.map(row => `<tr>
+            <td>${escapeHtml(row.path)}</td>
+            <td>${formatBaseAmount(row.average)}</td>
+            <td>${formatBaseAmount(row.min)}</td>
+            <td>${formatBaseAmount(row.max)}</td>
+            <td>${formatChange(row.changes[row.changes.length - 1])}</td>
+        </tr>`).join('');
+    }
+
+    trendChart = new Chart(ctx, {
+        data: { labels: trendData.months, datasets: datasets },
+        options: {
+            responsive: true,
+            maintainAspectRatio: true,
+            scales: {
+                x: { stacked: view !== 'cashflow' },
+                y: {
+                    stacked: view !== 'cashflow',
+                    ticks: {
+                        callback: function(value) {
+                            return currencySymbol(baseCurrency) + value.toLocaleString();
+                        }
+                    }
+                }
+            }
+        }
+    });
 }
 
 function renderNetWorthChart(records) {
@@ -333,7 +684,7 @@ function renderNetWorthChart(records) {
                     beginAtZero: true,
                     ticks: {
                         callback: function(value) {
-                            return '₹' + value.toLocaleString();
+                            return currencySymbol(baseCurrency) + value.toLocaleString();
                         }
                     }
                 }
@@ -352,6 +703,9 @@ function renderBudgetChart(budget) {
     document.getElementById('totalSpent').textContent = formatAmount(budget.totalSpent);
     document.getElementById('totalBudget').textContent = formatAmount(budget.totalBudget);
     document.getElementById('budgetPercentage').textContent = budget.percentage.toFixed(1);
+    const ytd = budget.yearToDate;
+    document.getElementById('budgetYearToDate').textContent =
+        `${ytd.fiscalYear} to date: ${formatBaseAmount(ytd.spent)} of ${formatBaseAmount(ytd.budget)} (${ytd.months} months)`;
 
     const categories = Object.keys(budget.breakdown);
     const spent = categories.map(c => budget.breakdown[c].spent);
@@ -394,7 +748,7 @@ function renderBudgetChart(budget) {
                     beginAtZero: true,
                     ticks: {
                         callback: function(value) {
-                            return '₹' + value.toLocaleString();
+                            return currencySymbol(baseCurrency) + value.toLocaleString();
                         }
                     }
                 }
@@ -438,7 +792,7 @@ function renderAccountPills(accounts) {
             pill.className = `account-pill ${acc.type}`;
             pill.innerHTML = `
                 <span class="account-name">${escapeHtml(acc.account)}</span>
-                <span class="amount">₹${formatAmount(acc.amount)}</span>
+                <span class="amount">${currencySymbol(acc.currency || baseCurrency)}${formatAmount(acc.amount)}</span>
             `;
             pillsContainer.appendChild(pill);
         });
@@ -499,7 +853,7 @@ function renderPortfolioChart(accounts) {
         <div class="legend-item">
             <span class="legend-color" style="background-color: ${colors[idx % colors.length]}"></span>
             <span class="legend-label">${escapeHtml(inv.account)}</span>
-            <span class="legend-value">₹${formatAmount(inv.amount)}</span>
+            <span class="legend-value">${formatBaseAmount(inv.amount)}</span>
         </div>
     `).join('');
 }
@@ -526,14 +880,87 @@ function renderUpcomingBills(bills) {
     });
 }
 
+function renderHoldings(holdings) {
+    const tbody = document.querySelector('#holdingsTable tbody');
+    tbody.innerHTML = '';
+
+    const held = holdings.filter(h => h.units > 0);
+    if (held.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="6" style="text-align: center; color: #666;">No holdings</td></tr>';
+        return;
+    }
+
+    held.forEach(h => {
+        const row = document.createElement('tr');
+        row.innerHTML = `
+            <td>${escapeHtml(h.security)}<br><small>${escapeHtml(h.account)}</small></td>
+            <td>${h.units}</td>
+            <td>${formatAmount(h.price)}</td>
+            <td>₹${formatAmount(h.costBasis)}</td>
+            <td>₹${formatAmount(h.marketValue)}</td>
+            <td class="${h.unrealizedGain < 0 ? 'loss' : 'gain'}">₹${formatAmount(h.unrealizedGain)}</td>
+        `;
+        tbody.appendChild(row);
+    });
+}
+
 // Transaction functions
+// Query string for the current search filters and page
+function transactionQuery(page) {
+    const params = new URLSearchParams({ page: page });
+    const fields = {
+        q: document.getElementById('searchQuery').value.trim(),
+        account: document.getElementById('searchAccount').value,
+        type: document.getElementById('searchType').value,
+        sort: document.getElementById('searchSort').value
+    };
+    const fromDate = document.getElementById('searchFrom').value;
+    const toDate = document.getElementById('searchTo').value;
+    if (fromDate) {
+        const [year, month, day] = fromDate.split('-');
+        fields.from = `${day}-${month}-${year}`;
+    }
+    if (toDate) {
+        const [year, month, day] = toDate.split('-');
+        fields.to = `${day}-${month}-${year}`;
+    }
+    Object.entries(fields).forEach(([key, value]) => {
+        if (value) params.set(key, value);
+    });
+    return params.toString();
+}
+
+function populateSearchAccounts() {
+    const select = document.getElementById('searchAccount');
+    const selected = select.value;
+    select.innerHTML = '<option value="">All accounts</option>' + accounts
+        .map(acc => `<option value="${escapeHtml(acc.account)}" ${acc.account === selected ? 'selected' : ''}>${escapeHtml(acc.account)}</option>`)
+        .join('');
+}
+
+function clearSearch() {
+    ['searchQuery', 'searchAccount', 'searchType', 'searchFrom', 'searchTo'].forEach(id => {
+        document.getElementById(id).value = '';
+    });
+    document.getElementById('searchSort').value = 'date-desc';
+    loadTransactions(1);
+}
+
 async function loadTransactions(page = 1) {
     currentPage = page;
-    const data = await apiCall(`/api/transactions?page=${page}`);
+    const data = await apiCall(`/api/transactions?${transactionQuery(page)}`);
     if (!data) return;
 
+    if (accounts.length === 0) {
+        await populateAccountDropdowns();
+    }
+    populateSearchAccounts();
+
     totalTransactions = data.total;
+    pageSize = data.pageSize || pageSize;
     const transactions = data.transactions;
+    document.getElementById('searchSummary').textContent =
+        `${data.total} transactions, ₹${formatAmount(data.totalAmount)}`;
 
     const listContainer = document.getElementById('transactionList');
     listContainer.innerHTML = '';
@@ -544,21 +971,20 @@ async function loadTransactions(page = 1) {
         transactions.forEach(tran => {
             const card = document.createElement('div');
             card.className = 'transaction-card';
-            card.setAttribute('data-date', tran.tranDate);
-            card.setAttribute('data-time', tran.tranTime);
+            card.setAttribute('data-id', tran.id);
             card.innerHTML = `
                 <div class="transaction-grid">
                     <div><strong>Date:</strong> ${escapeHtml(tran.tranDate)}</div>
                     <div><strong>Time:</strong> ${escapeHtml(tran.tranTime)}</div>
                     <div><strong>From:</strong> ${escapeHtml(tran.from)}</div>
-                    <div><strong>To:</strong> ${escapeHtml(tran.to)}</div>
-                    <div><strong>Description:</strong> ${escapeHtml(tran.description)}</div>
-                    <div><strong>Amount:</strong> ₹${formatAmount(tran.amount)}</div>
+                    <div><strong>To:</strong> ${formatDestination(tran)}</div>
+                    <div><strong>Description:</strong> ${escapeHtml(tran.description)}${tran.tags ? ` <span class="split-summary">${tran.tags.map(tag => '#' + escapeHtml(tag)).join(' ')}</span>` : ''}${tran.security ? ` <span class="split-summary">${escapeHtml(tran.security)} ${tran.units} @ ${tran.price}</span>` : ''}</div>
+                    <div><strong>Amount:</strong> ₹${formatAmount(tran.amount)}${tran.toAmount ? ` → ${formatAmount(tran.toAmount)}` : ''}</div>
                     <div class="action-buttons">
-                        <button class="btn-icon btn-edit" data-action="edit-transaction" data-date="${escapeHtml(tran.tranDate)}" data-time="${escapeHtml(tran.tranTime)}" title="Edit">
+                        <button class="btn-icon btn-edit" data-action="edit-transaction" data-id="${escapeHtml(tran.id)}" title="Edit">
                             <span class="material-icons">edit</span>
                         </button>
-                        <button class="btn-icon btn-delete" data-action="delete-transaction" data-date="${escapeHtml(tran.tranDate)}" data-time="${escapeHtml(tran.tranTime)}" title="Delete">
+                        <button class="btn-icon btn-delete" data-action="delete-transaction" data-id="${escapeHtml(tran.id)}" title="Delete">
                             <span class="material-icons">delete</span>
                         </button>
                     </div>
@@ -572,7 +998,7 @@ async function loadTransactions(page = 1) {
 }
 
 function updatePagination() {
-    const totalPages = Math.ceil(totalTransactions / 10);
+    const totalPages = Math.ceil(totalTransactions / pageSize);
     document.getElementById('pageInfo').textContent = `Page ${currentPage} of ${totalPages}`;
     document.getElementById('prevPage').disabled = currentPage === 1;
     document.getElementById('nextPage').disabled = currentPage === totalPages || totalPages === 0;
@@ -580,7 +1006,7 @@ function updatePagination() {
 
 function changePage(delta) {
     const newPage = currentPage + delta;
-    const totalPages = Math.ceil(totalTransactions / 10);
+    const totalPages = Math.ceil(totalTransactions / pageSize);
     
     if (newPage >= 1 && newPage <= totalPages) {
         loadTransactions(newPage);
@@ -599,7 +1025,7 @@ async function populateAccountDropdowns() {
     fromSelect.innerHTML = '<option value="">From</option>';
     toSelect.innerHTML = '<option value="">To</option>';
     
-    accounts.forEach(acc => {
+    accounts.filter(acc => !acc.closedDate).forEach(acc => {
         fromSelect.innerHTML += `<option value="${escapeHtml(acc.account)}">${escapeHtml(acc.account)}</option>`;
         toSelect.innerHTML += `<option value="${escapeHtml(acc.account)}">${escapeHtml(acc.account)}</option>`;
     });
@@ -618,6 +1044,48 @@ async function showAddTransactionForm() {
     form.scrollIntoView({ behavior: 'smooth', block: 'start' });
 }
 
+// Autocomplete from past transactions (see GET /api/suggestions)
+let suggestions = [];
+let suggestTimer = null;
+
+function suggestTransaction() {
+    // Picking a suggestion fills in the other side and the usual amount
+    const description = document.getElementById('description').value.trim();
+    const picked = suggestions.find(s => s.description === description);
+    if (picked) {
+        document.getElementById('toAccount').value = picked.to;
+        if (!document.getElementById('fromAccount').value) {
+            document.getElementById('fromAccount').value = picked.from;
+        }
+        if (!document.getElementById('amount').value) {
+            document.getElementById('amount').value = picked.amount;
+        }
+        return;
+    }
+
+    clearTimeout(suggestTimer);
+    suggestTimer = setTimeout(loadSuggestions, 250);
+}
+
+async function loadSuggestions() {
+    const params = new URLSearchParams();
+    const description = document.getElementById('description').value.trim();
+    const from = document.getElementById('fromAccount').value;
+    const time = document.getElementById('tranTime').value;
+    if (description) params.set('q', description);
+    if (from) params.set('from', from);
+    if (time) params.set('time', time);
+    if (!description && !from) return;
+
+    const data = await apiCall(`/api/suggestions?${params}`);
+    if (!data) return;
+
+    suggestions = data;
+    document.getElementById('descriptionSuggestions').innerHTML = data
+        .map(s => `<option value="${escapeHtml(s.description)}">${escapeHtml(s.to)} · ₹${formatAmount(s.amount)}</option>`)
+        .join('');
+}
+
 async function saveTransaction() {
     const dateInput = document.getElementById('tranDate').value;
     const timeInput = document.getElementById('tranTime').value;
@@ -625,6 +1093,10 @@ async function saveTransaction() {
     const to = document.getElementById('toAccount').value;
     const description = document.getElementById('description').value.trim();
     const amount = parseFloat(document.getElementById('amount').value);
+    const toAmount = parseFloat(document.getElementById('toAmount').value) || 0;
+    const security = document.getElementById('security').value.trim();
+    const units = parseFloat(document.getElementById('units').value) || 0;
+    const tags = parseTags(document.getElementById('tags').value);
 
     if (!dateInput || !timeInput || !from || !to || !description || !amount) {
         alert('Please fill all required fields');
@@ -652,6 +1124,17 @@ async function saveTransaction() {
         description: description,
         amount: amount
     };
+    if (toAmount > 0) transaction.toAmount = toAmount;
+    if (tags.length > 0) transaction.tags = tags;
+    if (security) {
+        // Negative units sell out of the From account
+        transaction.security = security;
+        transaction.units = units;
+    }
+
+    const splits = collectSplitLegs(document.getElementById('splitLegs'), to, amount);
+    if (splits === false) return;
+    if (splits) transaction.splits = splits;
 
     const result = await apiCall('/api/transactions', {
         method: 'POST',
@@ -660,6 +1143,15 @@ async function saveTransaction() {
     });
 
     if (result && result.success) {
+        if (result.rule) {
+            alert(`Categorized by rule "${result.rule}"`);
+        }
+        if (result.duplicates) {
+            const list = result.duplicates
+                .map(d => `${d.tranDate}  ${d.description}  ₹${formatAmount(d.amount)}`)
+                .join('\n');
+            alert(`Saved, but this looks like an existing transaction:\n${list}\n\nReview it under possible duplicates.`);
+        }
         cancelTransaction();
         loadTransactions(currentPage);
         loadDashboard();
@@ -674,19 +1166,77 @@ function cancelTransaction() {
     document.getElementById('toAccount').value = '';
     document.getElementById('description').value = '';
     document.getElementById('amount').value = '';
+    document.getElementById('toAmount').value = '';
+    document.getElementById('security').value = '';
+    document.getElementById('units').value = '';
+    document.getElementById('tags').value = '';
+    document.getElementById('descriptionSuggestions').innerHTML = '';
+    suggestions = [];
+    document.getElementById('splitLegs').innerHTML = '';
     editingTransaction = null;
 }
 
-async function editTransaction(date, time) {
-    editingTransaction = { oldTranDate: date, oldTranTime: time };
+// Split transactions: extra destination legs beyond the main To/Amount
+function addSplitLeg(container, leg = {}) {
+    if (!container) return;
+
+    const row = document.createElement('div');
+    row.className = 'split-leg';
+    row.innerHTML = `
+        <select class="split-to">
+            <option value="">To</option>
+            ${selectableAccounts(leg.to).map(acc => `<option value="${escapeHtml(acc.account)}" ${acc.account === leg.to ? 'selected' : ''}>${escapeHtml(acc.account)}</option>`).join('')}
+        </select>
+        <input type="number" class="split-amount" placeholder="Amount" step="0.01" value="${leg.amount !== undefined ? leg.amount : ''}">
+        <button class="btn-icon btn-cancel" data-action="remove-split-leg" title="Remove leg">
+            <span class="material-icons">remove</span>
+        </button>
+    `;
+    container.appendChild(row);
+}
+
+// Returns the legs including the main one, null for a plain transaction,
+// or false if a leg is incomplete
+function collectSplitLegs(container, to, amount) {
+    const rows = container ? container.querySelectorAll('.split-leg') : [];
+    if (rows.length === 0) return null;
+
+    const legs = [{ to: to, amount: amount }];
+    for (const row of rows) {
+        const legTo = row.querySelector('.split-to').value;
+        const legAmount = parseFloat(row.querySelector('.split-amount').value);
+        if (!legTo || !legAmount || legAmount <= 0) {
+            alert('Please fill every split leg with an account and a positive amount');
+            return false;
+        }
+        legs.push({ to: legTo, amount: legAmount });
+    }
+    return legs;
+}
+
+// Open accounts, plus an archived one that is already selected
+function selectableAccounts(selected) {
+    return accounts.filter(acc => !acc.closedDate || acc.account === selected);
+}
+
+function formatDestination(tran) {
+    if (!tran.splits || tran.splits.length === 0) {
+        return escapeHtml(tran.to);
+    }
+    return `<span class="split-summary">${tran.splits.map(leg => `${escapeHtml(leg.to)} ₹${formatAmount(leg.amount)}${leg.toAmount ? ` → ${formatAmount(leg.toAmount)}` : ''}`).join(', ')}</span>`;
+}
+
+async function editTransaction(id) {
+    editingTransaction = { id: id };
     
-    const data = await apiCall(`/api/transactions?page=${currentPage}`);
+    const data = await apiCall(`/api/transactions?${transactionQuery(currentPage)}`);
     if (!data) return;
     
-    const transaction = data.transactions.find(t => t.tranDate === date && t.tranTime === time);
+    const transaction = data.transactions.find(t => t.id === id);
     if (!transaction) return;
+    editingTransaction = { id: id, security: transaction.security, units: transaction.units };
 
-    const card = document.querySelector(`.transaction-card[data-date="${date}"][data-time="${time}"]`);
+    const card = document.querySelector(`.transaction-card[data-id="${CSS.escape(id)}"]`);
     if (!card) return;
 
     const [day, month, year] = transaction.tranDate.split('-');
@@ -694,19 +1244,28 @@ async function editTransaction(date, time) {
 
     await populateAccountDropdowns();
 
+    // The first leg of a split is edited in the main To/Amount fields
+    const legs = transaction.splits && transaction.splits.length > 0
+        ? transaction.splits
+        : [{ to: transaction.to, amount: transaction.amount }];
+
     card.innerHTML = `
         <div class="transaction-grid edit-mode">
             <input type="date" id="editTranDate" value="${dateValue}" required>
             <input type="time" id="editTranTime" value="${escapeHtml(transaction.tranTime)}" required>
             <select id="editFromAccount" required>
-                ${accounts.map(acc => `<option value="${escapeHtml(acc.account)}" ${acc.account === transaction.from ? 'selected' : ''}>${escapeHtml(acc.account)}</option>`).join('')}
+                ${selectableAccounts(transaction.from).map(acc => `<option value="${escapeHtml(acc.account)}" ${acc.account === transaction.from ? 'selected' : ''}>${escapeHtml(acc.account)}</option>`).join('')}
             </select>
             <select id="editToAccount" required>
-                ${accounts.map(acc => `<option value="${escapeHtml(acc.account)}" ${acc.account === transaction.to ? 'selected' : ''}>${escapeHtml(acc.account)}</option>`).join('')}
+                ${selectableAccounts(legs[0].to).map(acc => `<option value="${escapeHtml(acc.account)}" ${acc.account === legs[0].to ? 'selected' : ''}>${escapeHtml(acc.account)}</option>`).join('')}
             </select>
             <input type="text" id="editDescription" value="${escapeHtml(transaction.description)}" maxlength="100" required>
-            <input type="number" id="editAmount" value="${transaction.amount}" step="0.01" required>
+            <input type="number" id="editAmount" value="${legs[0].amount}" step="0.01" required>
+            <input type="text" id="editTags" value="${escapeHtml((transaction.tags || []).join(', '))}" placeholder="Tags">
             <div class="action-buttons">
+                <button class="btn-icon btn-edit" data-action="add-split-leg" data-target="editSplitLegs" title="Split across accounts">
+                    <span class="material-icons">call_split</span>
+                </button>
                 <button class="btn-icon btn-save" data-action="save-edit-transaction" title="Save">
                     <span class="material-icons">check</span>
                 </button>
@@ -715,7 +1274,11 @@ async function editTransaction(date, time) {
                 </button>
             </div>
         </div>
+        <div id="editSplitLegs" class="split-legs"></div>
     `;
+
+    const legContainer = document.getElementById('editSplitLegs');
+    legs.slice(1).forEach(leg => addSplitLeg(legContainer, leg));
 }
 
 async function saveEditTransaction() {
@@ -740,15 +1303,24 @@ async function saveEditTransaction() {
     const formattedDate = `${day}-${month}-${year}`;
 
     const updateData = {
-        oldTranDate: editingTransaction.oldTranDate,
-        oldTranTime: editingTransaction.oldTranTime,
+        id: editingTransaction.id,
         tranDate: formattedDate,
         tranTime: timeInput,
         from: from,
         to: to,
         description: description,
-        amount: amount
+        amount: amount,
+        tags: parseTags(document.getElementById('editTags').value)
     };
+    if (editingTransaction.security) {
+        // The price is derived again from the edited amount
+        updateData.security = editingTransaction.security;
+        updateData.units = editingTransaction.units;
+    }
+
+    const splits = collectSplitLegs(document.getElementById('editSplitLegs'), to, amount);
+    if (splits === false) return;
+    if (splits) updateData.splits = splits;
 
     const result = await apiCall('/api/transactions', {
         method: 'PUT',
@@ -763,13 +1335,127 @@ async function saveEditTransaction() {
     }
 }
 
-async function deleteTransaction(date, time) {
+// Statement import through saved profiles (see POST /api/import/profiles),
+// or a ledger, hledger or beancount journal
+async function showImportPanel() {
+    const profiles = await apiCall('/api/import/profiles');
+    if (!profiles) return;
+
+    document.getElementById('importProfile').innerHTML = '<option value="">Profile</option>' + profiles
+        .map(p => `<option value="${escapeHtml(p.name)}">${escapeHtml(p.name)} (${escapeHtml(p.account)})</option>`)
+        .join('') + '<option value="journal" data-journal="true">Journal (ledger, hledger, beancount)</option>';
+    document.querySelector('#importTable tbody').innerHTML = '';
+    document.getElementById('importPanel').style.display = 'block';
+}
+
+async function runImport(commit) {
+    const select = document.getElementById('importProfile');
+    const profile = select.value;
+    const journal = select.selectedOptions[0]?.dataset.journal === 'true';
+    const file = document.getElementById('importFile').files[0];
+
+    if (!profile || !file) {
+        alert('Choose a profile and a statement file');
+        return;
+    }
+    if (commit && !confirm('Import every line without an error?')) return;
+
+    const url = `/api/import/${journal ? 'journal/' : ''}${commit ? 'commit' : 'preview'}`;
+    const result = await apiCall(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(journal ? { data: await file.text() } : { profile: profile, data: await file.text() })
+    });
+    if (!result) return;
+
+    const tbody = document.querySelector('#importTable tbody');
+    tbody.innerHTML = '';
+    result.rows.forEach(row => {
+        const t = row.transaction;
+        const tr = document.createElement('tr');
+        if (row.error) tr.className = 'urgency-high';
+        else if (row.duplicates) tr.className = 'urgency-medium';
+        tr.innerHTML = `
+            <td>${row.line}</td>
+            <td>${escapeHtml(t.tranDate)}</td>
+            <td>${escapeHtml(t.from)}</td>
+            <td>${escapeHtml(t.splits ? t.splits.map(s => s.to).join(', ') : t.to)}</td>
+            <td>${escapeHtml(t.description)}</td>
+            <td>₹${formatAmount(t.amount)}</td>
+            <td>${row.error ? escapeHtml(row.error) : row.duplicates ? 'Possible duplicate' : row.rule ? `OK (${escapeHtml(row.rule)})` : 'OK'}</td>
+        `;
+        tbody.appendChild(tr);
+    });
+
+    if (commit) {
+        const created = result.accounts && result.accounts.length ? ` and ${result.accounts.length} new accounts` : '';
+        alert(`Imported ${result.imported} transactions${created}`);
+        loadTransactions(1);
+        loadDashboard();
+    }
+}
+
+// Review queue of likely duplicates (see GET /api/duplicates)
+async function loadDuplicates() {
+    const pairs = await apiCall('/api/duplicates');
+    if (!pairs) return;
+
+    const describe = t => `${escapeHtml(t.tranDate)} ${escapeHtml(t.from)} → ${escapeHtml(t.to)}<br>${escapeHtml(t.description)}`;
+    const tbody = document.querySelector('#duplicatesTable tbody');
+    tbody.innerHTML = pairs.length ? '' : '<tr><td colspan="4">No possible duplicates</td></tr>';
+    pairs.forEach(pair => {
+        const first = pair.first, second = pair.second;
+        const tr = document.createElement('tr');
+        tr.innerHTML = `
+            <td>${describe(first)}</td>
+            <td>${describe(second)}</td>
+            <td>₹${formatAmount(first.amount)}</td>
+            <td>
+                <button class="btn-secondary" data-action="merge-duplicate" data-keep="${escapeHtml(first.id)}" data-remove="${escapeHtml(second.id)}">Keep first</button>
+                <button class="btn-secondary" data-action="merge-duplicate" data-keep="${escapeHtml(second.id)}" data-remove="${escapeHtml(first.id)}">Keep second</button>
+                <button class="btn-secondary" data-action="dismiss-duplicate" data-first="${escapeHtml(first.id)}" data-second="${escapeHtml(second.id)}">Not a duplicate</button>
+            </td>
+        `;
+        tbody.appendChild(tr);
+    });
+    document.getElementById('duplicatesPanel').style.display = 'block';
+}
+
+async function mergeDuplicate(keep, remove) {
+    if (!confirm('Delete the other transaction and keep this one?')) return;
+
+    const result = await apiCall('/api/duplicates/merge', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ keep: keep, remove: remove })
+    });
+
+    if (result && result.success) {
+        loadDuplicates();
+        loadTransactions(currentPage);
+        loadDashboard();
+    }
+}
+
+async function dismissDuplicate(first, second) {
+    const result = await apiCall('/api/duplicates/dismiss', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ first: first, second: second })
+    });
+
+    if (result && result.success) {
+        loadDuplicates();
+    }
+}
+
+async function deleteTransaction(id) {
     if (!confirm('Are you sure you want to delete this transaction?')) return;
 
     const result = await apiCall('/api/transactions', {
         method: 'DELETE',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ tranDate: date, tranTime: time })
+        body: JSON.stringify({ id: id })
     });
 
     if (result && result.success) {
@@ -802,18 +1488,27 @@ async function loadAccounts() {
 
         typeAccounts.forEach(acc => {
             const card = document.createElement('div');
-            card.className = 'account-card';
+            card.className = acc.closedDate ? 'account-card closed' : 'account-card';
             card.innerHTML = `
                 <div class="account-grid">
                     <div><strong>Name:</strong> ${escapeHtml(acc.account)}</div>
-                    <div><strong>Amount:</strong> ₹${formatAmount(acc.amount)}</div>
+                    <div><strong>Amount:</strong> ${currencySymbol(acc.currency || baseCurrency)}${formatAmount(acc.amount)}</div>
                     <div><strong>In Net Worth:</strong> ${escapeHtml(acc.iinw)}</div>
-                    ${acc.budget > 0 ? `<div><strong>Budget:</strong> ₹${acc.budget.toFixed(2)}</div>` : ''}
+                    ${acc.budget > 0 ? `<div><strong>Budget:</strong> ${currencySymbol(acc.currency || baseCurrency)}${formatAmount(acc.budget)}</div>` : ''}
                     ${acc.dueDate ? `<div><strong>Due Date:</strong> ${escapeHtml(acc.dueDate)}</div>` : ''}
+                    ${acc.parent ? `<div><strong>Parent:</strong> ${escapeHtml(acc.parent)}</div>` : ''}
+                    ${acc.closedDate ? `<div><strong>Closed:</strong> ${escapeHtml(acc.closedDate)}</div>` : ''}
                     <div class="action-buttons">
                         <button class="btn-icon btn-edit" data-action="edit-account" data-account="${escapeHtml(acc.account)}" title="Edit">
                             <span class="material-icons">edit</span>
                         </button>
+                        ${acc.closedDate
+                            ? `<button class="btn-icon btn-edit" data-action="reopen-account" data-account="${escapeHtml(acc.account)}" title="Reopen">
+                                <span class="material-icons">unarchive</span>
+                            </button>`
+                            : `<button class="btn-icon btn-edit" data-action="archive-account" data-account="${escapeHtml(acc.account)}" title="Archive">
+                                <span class="material-icons">archive</span>
+                            </button>`}
                         <button class="btn-icon btn-delete" onclick="deleteAccount('${escapeHtml(acc.account).replace(/'/g, "\\'")}')">
                             <span class="material-icons">delete</span>
                         </button>
@@ -827,10 +1522,19 @@ async function loadAccounts() {
     });
 }
 
+// Options for the parent select: open accounts of the same type
+function parentOptions(type, selected, exclude) {
+    return '<option value="">No parent</option>' + accounts
+        .filter(acc => acc.type === type && acc.account !== exclude && (!acc.closedDate || acc.account === selected))
+        .map(acc => `<option value="${escapeHtml(acc.account)}" ${acc.account === selected ? 'selected' : ''}>${escapeHtml(acc.account)}</option>`)
+        .join('');
+}
+
 function showAddAccountForm() {
     editingAccount = null;
     const form = document.getElementById('addAccountForm');
     document.getElementById('accountName').disabled = false;
+    document.getElementById('accountParent').innerHTML = parentOptions(document.getElementById('accountType').value, '', '');
     form.style.display = 'block';
     form.scrollIntoView({ behavior: 'smooth', block: 'start' });
 }
@@ -842,6 +1546,7 @@ function toggleAccountFields() {
 
     budgetField.style.display = type === 'EXPENSE' ? 'block' : 'none';
     dueDateField.style.display = type === 'LIABILITIES' ? 'block' : 'none';
+    document.getElementById('accountParent').innerHTML = parentOptions(type, '', '');
 }
 
 async function saveAccount() {
@@ -851,6 +1556,8 @@ async function saveAccount() {
     const iinw = document.querySelector('input[name="iinw"]:checked')?.value || 'No';
     const budget = parseFloat(document.getElementById('accountBudget').value) || 0;
     const dateInput = document.getElementById('accountDueDate').value;
+    const parent = document.getElementById('accountParent').value;
+    const currency = document.getElementById('accountCurrency').value.trim().toUpperCase();
 
     if (!name || !type) {
         alert('Please fill required fields');
@@ -874,7 +1581,9 @@ async function saveAccount() {
         amount: amount,
         iinw: iinw,
         budget: budget,
-        dueDate: formattedDate
+        dueDate: formattedDate,
+        parent: parent,
+        currency: currency
     };
 
     let result;
@@ -908,6 +1617,8 @@ function cancelAccount() {
     document.querySelector('input[name="iinw"][value="No"]').checked = true;
     document.getElementById('accountBudget').value = '';
     document.getElementById('accountDueDate').value = '';
+    document.getElementById('accountParent').innerHTML = '<option value="">No parent</option>';
+    document.getElementById('accountCurrency').value = '';
     document.getElementById('accountBudget').style.display = 'none';
     document.getElementById('accountDueDate').style.display = 'none';
     editingAccount = null;
@@ -958,6 +1669,10 @@ async function editAccount(name) {
                     </div>
                     <input type="number" id="editAccountBudget" placeholder="Budget (for expenses)" step="0.01" value="${account.budget || ''}" style="display: ${showBudget ? 'block' : 'none'};">
                     <input type="date" id="editAccountDueDate" placeholder="Due Date" value="${dueDateValue}" style="display: ${showDueDate ? 'block' : 'none'};">
+                    <select id="editAccountParent">
+                        ${parentOptions(account.type, account.parent || '', account.account)}
+                    </select>
+                    <input type="text" id="editAccountCurrency" placeholder="Currency (blank for base)" maxlength="3" value="${escapeHtml(account.currency || '')}">
                     <div class="action-buttons">
                         <button class="btn-icon btn-save" data-action="save-edit-account" title="Save">
                             <span class="material-icons">check</span>
@@ -977,6 +1692,7 @@ async function editAccount(name) {
                 const dueDateField = card.querySelector('#editAccountDueDate');
                 budgetField.style.display = type === 'EXPENSE' ? 'block' : 'none';
                 dueDateField.style.display = type === 'LIABILITIES' ? 'block' : 'none';
+                card.querySelector('#editAccountParent').innerHTML = parentOptions(type, '', account.account);
             });
             
             card.scrollIntoView({ behavior: 'smooth', block: 'center' });
@@ -987,12 +1703,28 @@ async function editAccount(name) {
 async function deleteAccount(name) {
     if (!confirm('Are you sure you want to delete this account?')) return;
 
-    const result = await apiCall('/api/accounts', {
+    let result = await apiCall('/api/accounts', {
         method: 'DELETE',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ account: name })
+        body: JSON.stringify({ account: name }),
+        allowConflict: true
     });
 
+    // Accounts with transactions are archived or reassigned, never dropped
+    if (result && result.conflict) {
+        const target = prompt(`${result.error}.\n\nEnter an account to move them to, or leave empty to archive "${name}":`);
+        if (target === null) return;
+
+        const body = target.trim()
+            ? { account: name, action: 'reassign', reassignTo: target.trim() }
+            : { account: name, action: 'archive' };
+        result = await apiCall('/api/accounts', {
+            method: 'DELETE',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+    }
+
     if (result && result.success) {
         cancelAccount();
         loadAccounts();
@@ -1000,6 +1732,37 @@ async function deleteAccount(name) {
     }
 }
 
+async function archiveAccount(name) {
+    if (!confirm(`Archive "${name}"? It will be hidden but its history is kept.`)) return;
+
+    const result = await apiCall('/api/accounts', {
+        method: 'DELETE',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ account: name, action: 'archive' })
+    });
+
+    if (result && result.success) {
+        loadAccounts();
+        loadDashboard();
+    }
+}
+
+async function reopenAccount(name) {
+    const account = accounts.find(a => a.account === name);
+    if (!account) return;
+
+    const result = await apiCall('/api/accounts', {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ ...account, oldAccount: name, closedDate: '' })
+    });
+
+    if (result && result.success) {
+        loadAccounts();
+        loadDashboard();
+    }
+}
+
 async function saveEditAccount() {
     const newName = document.getElementById('editAccountName').value.trim();
     const type = document.getElementById('editAccountType').value;
@@ -1007,6 +1770,8 @@ async function saveEditAccount() {
     const iinw = document.querySelector('input[name="editIinw"]:checked')?.value || 'No';
     const budget = parseFloat(document.getElementById('editAccountBudget').value) || 0;
     const dateInput = document.getElementById('editAccountDueDate').value;
+    const parent = document.getElementById('editAccountParent').value;
+    const currency = document.getElementById('editAccountCurrency').value.trim().toUpperCase();
 
     if (!newName || !type) {
         alert('Please fill required fields');
@@ -1031,7 +1796,9 @@ async function saveEditAccount() {
         amount: amount,
         iinw: iinw,
         budget: budget,
-        dueDate: formattedDate
+        dueDate: formattedDate,
+        parent: parent,
+        currency: currency
     };
 
     const result = await apiCall('/api/accounts', {
@@ -1069,6 +1836,198 @@ function loadSettings() {
     }, 100);
 }
 
+// Server-side settings shared by every client
+async function loadServerSettings() {
+    const settings = await apiCall('/api/settings');
+    if (!settings) return;
+
+    document.getElementById('baseCurrency').value = settings.baseCurrency || '';
+    document.getElementById('applyRulesToggle').checked = settings.applyRulesOnEntry === 'true';
+    document.getElementById('fiscalYearStart').value = settings.fiscalYearStart || '1';
+    const [month, year] = (settings.envelopeStart || '').split('-');
+    document.getElementById('envelopeStart').value = year ? `${year}-${month}` : '';
+}
+
+// Envelope budgeting starts in the picked month; clearing it turns it off
+async function saveEnvelopeStart(picked) {
+    let envelopeStart = '';
+    if (picked) {
+        const [year, month] = picked.split('-');
+        envelopeStart = `${month}-${year}`;
+    }
+
+    const result = await apiCall('/api/settings', {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ envelopeStart })
+    });
+
+    if (result && result.success) {
+        loadDashboard();
+    } else {
+        loadServerSettings();
+    }
+}
+
+async function saveFiscalYearStart(month) {
+    const result = await apiCall('/api/settings', {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ fiscalYearStart: month })
+    });
+
+    if (result && result.success) {
+        loadDashboard();
+    } else {
+        loadServerSettings();
+    }
+}
+
+async function saveApplyRules(enabled) {
+    const result = await apiCall('/api/settings', {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ applyRulesOnEntry: enabled ? 'true' : 'false' })
+    });
+
+    if (!result || !result.success) {
+        document.getElementById('applyRulesToggle').checked = !enabled;
+    }
+}
+
+function parseTags(text) {
+    return text.split(',').map(tag => tag.trim()).filter(tag => tag);
+}
+
+// Categorization rules (see rules.go)
+async function loadRules() {
+    const [rules, accountData] = await Promise.all([apiCall('/api/rules'), apiCall('/api/accounts')]);
+    if (!rules || !accountData) return;
+
+    const options = accountData
+        .filter(acc => !acc.closedDate)
+        .map(acc => `<option value="${escapeHtml(acc.account)}">${escapeHtml(acc.account)}</option>`)
+        .join('');
+    document.getElementById('ruleAccount').innerHTML = '<option value="">Any account</option>' + options;
+    document.getElementById('ruleTarget').innerHTML = '<option value="">Target account</option>' + options;
+    document.getElementById('ruleApplyAccount').innerHTML = '<option value="">Uncategorized account</option>' + options;
+
+    const tbody = document.querySelector('#rulesTable tbody');
+    tbody.innerHTML = rules.length ? '' : '<tr><td colspan="7">No rules yet</td></tr>';
+    rules.forEach(rule => {
+        const range = rule.minAmount || rule.maxAmount
+            ? `${rule.minAmount ? formatAmount(rule.minAmount) : ''} – ${rule.maxAmount ? formatAmount(rule.maxAmount) : ''}`
+            : 'Any';
+        const tr = document.createElement('tr');
+        tr.innerHTML = `
+            <td>${escapeHtml(rule.name)}</td>
+            <td>${rule.match === 'regex' ? '/' + escapeHtml(rule.pattern) + '/' : escapeHtml(rule.pattern)}</td>
+            <td>${escapeHtml(rule.account || 'Any')}</td>
+            <td>${range}</td>
+            <td>${escapeHtml(rule.target)}</td>
+            <td>${escapeHtml(rule.description)} ${(rule.tags || []).map(tag => '#' + escapeHtml(tag)).join(' ')}</td>
+            <td>
+                <button class="btn-icon btn-delete" data-action="delete-rule" data-name="${escapeHtml(rule.name)}" title="Delete">
+                    <span class="material-icons">delete</span>
+                </button>
+            </td>
+        `;
+        tbody.appendChild(tr);
+    });
+}
+
+async function saveRule() {
+    const rule = {
+        name: document.getElementById('ruleName').value.trim(),
+        match: document.getElementById('ruleMatch').value,
+        pattern: document.getElementById('rulePattern').value.trim(),
+        account: document.getElementById('ruleAccount').value,
+        target: document.getElementById('ruleTarget').value,
+        minAmount: parseFloat(document.getElementById('ruleMin').value) || 0,
+        maxAmount: parseFloat(document.getElementById('ruleMax').value) || 0,
+        description: document.getElementById('ruleDescription').value.trim(),
+        tags: parseTags(document.getElementById('ruleTags').value)
+    };
+
+    if (!rule.name || !rule.pattern || !rule.target) {
+        alert('Name, pattern and target account are required');
+        return;
+    }
+
+    const result = await apiCall('/api/rules', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(rule)
+    });
+
+    if (result && result.success) {
+        ['ruleName', 'rulePattern', 'ruleMin', 'ruleMax', 'ruleDescription', 'ruleTags']
+            .forEach(id => document.getElementById(id).value = '');
+        loadRules();
+    }
+}
+
+async function deleteRule(name) {
+    if (!confirm(`Delete rule "${name}"?`)) return;
+
+    const result = await apiCall('/api/rules', {
+        method: 'DELETE',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ name: name })
+    });
+
+    if (result && result.success) {
+        loadRules();
+    }
+}
+
+async function applyRules() {
+    const account = document.getElementById('ruleApplyAccount').value;
+    if (!account) {
+        alert('Choose the account holding uncategorized transactions');
+        return;
+    }
+
+    const request = dryRun => apiCall('/api/rules/apply', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ account: account, dryRun: dryRun })
+    });
+
+    const preview = await request(true);
+    if (!preview) return;
+    if (preview.changed === 0) {
+        alert('No transactions match a rule');
+        return;
+    }
+    if (!confirm(`Categorize ${preview.changed} transactions?`)) return;
+
+    const result = await request(false);
+    if (result && result.success) {
+        alert(`Categorized ${result.changed} transactions`);
+    }
+}
+
+async function saveBaseCurrency() {
+    const baseCurrency = document.getElementById('baseCurrency').value.trim().toUpperCase();
+
+    if (!/^[A-Z]{3}$/.test(baseCurrency)) {
+        alert('Base currency must be a 3-letter code');
+        return;
+    }
+
+    const result = await apiCall('/api/settings', {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ baseCurrency })
+    });
+
+    if (result && result.success) {
+        alert('Base currency updated');
+        loadDashboard();
+    }
+}
+
 function setupColorPicker() {
     const colorOptions = document.querySelectorAll('.color-option');
     const currentTheme = localStorage.getItem('theme') || 'purple';
@@ -1178,6 +2137,26 @@ function formatAmount(amount) {
     return (parseFloat(amount) || 0).toFixed(2);
 }
 
+// The symbol written before amounts in a currency, e.g. ₹ for INR, or the
+// code itself when the browser has no symbol for it
+function currencySymbol(currency) {
+    try {
+        const parts = new Intl.NumberFormat(undefined, {
+            style: 'currency',
+            currency: currency,
+            currencyDisplay: 'narrowSymbol'
+        }).formatToParts(0);
+        const symbol = parts.find(part => part.type === 'currency').value;
+        return symbol === currency ? escapeHtml(currency) + ' ' : escapeHtml(symbol);
+    } catch (e) {
+        return escapeHtml(currency) + ' ';
+    }
+}
+
+function formatBaseAmount(amount) {
+    return currencySymbol(baseCurrency) + formatAmount(amount);
+}
+
 function formatDateForInput(date) {
     const year = date.getFullYear();
     const month = String(date.getMonth() + 1).padStart(2, '0');
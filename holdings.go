@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Securities (funds, stocks) are bought and sold with ordinary transactions
+// that also carry Security, Units and Price. A buy has positive units and
+// holds them in To; a sell has negative units taken out of From. Amount is
+// still the cash paid or received, so account balances stay at cost.
+//
+// Holdings are derived from those transactions with average cost: a sale
+// removes its share of the cost basis and the difference to the proceeds is
+// the realized gain. Market value uses the latest price in prices.csv on or
+// before today, or the price of the last trade when none is newer.
+
+var priceHeader = []string{"Date", "Security", "Price"}
+
+var pricesMutex sync.Mutex
+
+type SecurityPrice struct {
+	Date     string  `json:"date"`
+	Security string  `json:"security"`
+	Price    Decimal `json:"price"`
+}
+
+// Holding is one security held in one account, in the account's currency
+type Holding struct {
+	Account        string  `json:"account"`
+	Security       string  `json:"security"`
+	Units          Decimal `json:"units"`
+	CostBasis      Money   `json:"costBasis"`
+	Price          Decimal `json:"price"`
+	PriceDate      string  `json:"priceDate"`
+	MarketValue    Money   `json:"marketValue"`
+	UnrealizedGain Money   `json:"unrealizedGain"`
+	RealizedGain   Money   `json:"realizedGain"`
+}
+
+// InvestmentSummary totals every holding in the base currency
+type InvestmentSummary struct {
+	CostBasis      Money `json:"costBasis"`
+	MarketValue    Money `json:"marketValue"`
+	UnrealizedGain Money `json:"unrealizedGain"`
+	RealizedGain   Money `json:"realizedGain"`
+}
+
+// HoldingAccount is the account the units of a buy or sell are held in
+func (t Transaction) HoldingAccount() string {
+	if t.Units < 0 {
+		return t.From
+	}
+	return t.To
+}
+
+// validateInvestment checks the security fields of a transaction. Given
+// units, a missing amount is derived from the price and a missing price
+// from the amount.
+func validateInvestment(t *Transaction) error {
+	t.Security = sanitizeInput(t.Security)
+	if t.Security == "" {
+		if t.Units != 0 || t.Price != 0 {
+			return errors.New("units and price need a security")
+		}
+		return nil
+	}
+
+	if len(t.Security) > 50 {
+		return errors.New("security name too long (max 50 characters)")
+	}
+	if len(t.Splits) > 0 {
+		return errors.New("a security transaction cannot be split")
+	}
+	if t.Units == 0 {
+		return errors.New("units required for a security transaction")
+	}
+	if t.Price < 0 {
+		return errors.New("price cannot be negative")
+	}
+
+	units := t.Units.Abs()
+	if t.Amount == 0 && t.Price > 0 {
+		t.Amount = unitsValue(units, t.Price)
+	}
+	if t.Price == 0 && t.Amount > 0 {
+		t.Price = unitPrice(t.Amount, units)
+	}
+	return nil
+}
+
+// checkUnitsHeld rejects a sale of more units than the account holds on
+// the sale date, leaving out the transaction being updated
+func checkUnitsHeld(t Transaction) error {
+	if t.Security == "" || t.Units >= 0 {
+		return nil
+	}
+
+	transactions, err := readAllTransactions()
+	if err != nil {
+		return err
+	}
+	var others []Transaction
+	for _, other := range transactions {
+		if other.ID != t.ID {
+			others = append(others, other)
+		}
+	}
+
+	var held Decimal
+	for _, h := range computeHoldings(others, nil, t.TranDate) {
+		if h.Account == t.From && h.Security == t.Security {
+			held = h.Units
+		}
+	}
+	if t.Units.Abs() > held {
+		return fmt.Errorf("%s holds only %s units of %s", t.From, held, t.Security)
+	}
+	return nil
+}
+
+// unitsValue is units times price, rounded to the minor unit
+func unitsValue(units, price Decimal) Money {
+	r := new(big.Rat).Mul(units.Rat(), price.Rat())
+	r.Mul(r, big.NewRat(int64(MoneyUnit), 1))
+	v, err := roundMinorUnits(r)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// unitPrice is the price per unit paid for an amount
+func unitPrice(amount Money, units Decimal) Decimal {
+	if units == 0 {
+		return 0
+	}
+	r := new(big.Rat).Quo(big.NewRat(int64(amount), int64(MoneyUnit)), units.Rat())
+	r.Mul(r, big.NewRat(int64(DecimalUnit), 1))
+	v, err := roundMinorUnits(r)
+	if err != nil {
+		return 0
+	}
+	return Decimal(v)
+}
+
+func readPrices() ([]SecurityPrice, error) {
+	rows, cols, err := store.ReadTable("prices")
+	if err != nil {
+		return nil, err
+	}
+
+	var prices []SecurityPrice
+	for _, record := range rows {
+		price, _ := ParseDecimal(columnValue(record, cols, "Price"))
+		prices = append(prices, SecurityPrice{
+			Date:     columnValue(record, cols, "Date"),
+			Security: columnValue(record, cols, "Security"),
+			Price:    price,
+		})
+	}
+	return prices, nil
+}
+
+func writePrices(prices []SecurityPrice) error {
+	sort.SliceStable(prices, func(i, j int) bool {
+		return dateKey(prices[i].Date) < dateKey(prices[j].Date)
+	})
+
+	rows := make([][]string, 0, len(prices))
+	for _, p := range prices {
+		rows = append(rows, []string{p.Date, p.Security, p.Price.String()})
+	}
+	return store.WriteTable("prices", priceHeader, rows)
+}
+
+func validatePrice(p *SecurityPrice) error {
+	p.Security = sanitizeInput(p.Security)
+
+	if !isValidDate(p.Date) {
+		return errors.New("invalid date format (use DD-MM-YYYY)")
+	}
+	if p.Security == "" {
+		return errors.New("security required")
+	}
+	if len(p.Security) > 50 {
+		return errors.New("security name too long (max 50 characters)")
+	}
+	if p.Price <= 0 {
+		return errors.New("price must be positive")
+	}
+	return nil
+}
+
+// computeHoldings replays every buy and sell up to asOf and values what is
+// left at the latest known price
+func computeHoldings(transactions []Transaction, prices []SecurityPrice, asOf string) []Holding {
+	var trades []Transaction
+	for _, t := range transactions {
+		if t.Security != "" && t.Units != 0 && !compareDates(t.TranDate, asOf) {
+			trades = append(trades, t)
+		}
+	}
+	sort.SliceStable(trades, func(i, j int) bool {
+		return dateKey(trades[i].TranDate)+trades[i].TranTime < dateKey(trades[j].TranDate)+trades[j].TranTime
+	})
+
+	var holdings []*Holding
+	byKey := make(map[string]*Holding)
+	for _, t := range trades {
+		key := t.HoldingAccount() + "\x00" + t.Security
+		h, ok := byKey[key]
+		if !ok {
+			h = &Holding{Account: t.HoldingAccount(), Security: t.Security}
+			byKey[key] = h
+			holdings = append(holdings, h)
+		}
+
+		if t.Units > 0 {
+			h.Units += t.Units
+			h.CostBasis += t.Legs()[0].Received()
+		} else {
+			sold := t.Units.Abs()
+			if sold > h.Units {
+				sold = h.Units
+			}
+			var cost Money
+			if h.Units > 0 {
+				share := new(big.Rat).Quo(sold.Rat(), h.Units.Rat())
+				// share is at most 1, so the cost cannot overflow
+				cost, _ = h.CostBasis.Convert(share)
+			}
+			h.Units -= sold
+			h.CostBasis -= cost
+			h.RealizedGain += t.Amount - cost
+		}
+		h.Price = t.Price
+		h.PriceDate = t.TranDate
+	}
+
+	// Quotes newer than the last trade replace its price
+	for _, p := range prices {
+		if compareDates(p.Date, asOf) {
+			continue
+		}
+		for _, h := range holdings {
+			if h.Security == p.Security && !compareDates(h.PriceDate, p.Date) {
+				h.Price = p.Price
+				h.PriceDate = p.Date
+			}
+		}
+	}
+
+	result := make([]Holding, 0, len(holdings))
+	for _, h := range holdings {
+		h.MarketValue = unitsValue(h.Units, h.Price)
+		h.UnrealizedGain = h.MarketValue - h.CostBasis
+		result = append(result, *h)
+	}
+	return result
+}
+
+// applyHoldings adds the gains on each account's securities to the account
+func applyHoldings(accounts []Account, holdings []Holding) {
+	for i := range accounts {
+		for _, h := range holdings {
+			if h.Account == accounts[i].Name {
+				accounts[i].UnrealizedGain += h.UnrealizedGain
+				accounts[i].RealizedGain += h.RealizedGain
+				accounts[i].MarketValue = accounts[i].Value()
+			}
+		}
+	}
+}
+
+// summarizeHoldings totals the holdings in the base currency
+func summarizeHoldings(holdings []Holding, accounts []Account, conv *Converter, asOf string) InvestmentSummary {
+	var sum InvestmentSummary
+	for _, h := range holdings {
+		currency := accountCurrency(findAccount(accounts, h.Account), conv.Base)
+		sum.CostBasis += conv.ToBase(h.CostBasis, currency, asOf)
+		sum.MarketValue += conv.ToBase(h.MarketValue, currency, asOf)
+		sum.UnrealizedGain += conv.ToBase(h.UnrealizedGain, currency, asOf)
+		sum.RealizedGain += conv.ToBase(h.RealizedGain, currency, asOf)
+	}
+	return sum
+}
+
+func handlePrices(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		prices, err := readPrices()
+		if err != nil {
+			respondError(w, "Failed to load prices", http.StatusInternalServerError)
+			return
+		}
+		if prices == nil {
+			prices = []SecurityPrice{}
+		}
+		json.NewEncoder(w).Encode(prices)
+
+	case http.MethodPost:
+		var price SecurityPrice
+		if err := json.NewDecoder(r.Body).Decode(&price); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+
+		if err := validatePrice(&price); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pricesMutex.Lock()
+		prices, err := readPrices()
+		if err == nil {
+			// One price per security and day; a new one replaces the old
+			replaced := false
+			for i := range prices {
+				if prices[i].Date == price.Date && prices[i].Security == price.Security {
+					prices[i] = price
+					replaced = true
+				}
+			}
+			if !replaced {
+				prices = append(prices, price)
+			}
+			err = writePrices(prices)
+		}
+		pricesMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to save price", http.StatusInternalServerError)
+			return
+		}
+
+		logSecurityEvent("PRICE_ADD", getClientIP(r), fmt.Sprintf("Set %s price on %s: %s", price.Security, price.Date, price.Price))
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bank statements are imported through a saved profile. For CSV files it
+// says which columns hold the date, description and amount; OFX and QIF
+// files need only the accounts. Every statement line becomes a transaction
+// between the profile's Account and its DefaultAccount: money in is
+// DefaultAccount -> Account, money out is Account -> DefaultAccount. Lines
+// are previewed first and committed through the same validation as
+// transactions entered by hand, then categorized by the rules in rules.go.
+// A line whose FITID is already on a transaction of the account is
+// reported instead of imported again.
+
+var importProfileHeader = []string{"Name", "Account", "DefaultAccount", "DateColumn", "DateFormat", "DescriptionColumn", "AmountColumn", "DebitColumn", "CreditColumn", "Sign", "SkipRows", "Delimiter", "Format"}
+
+// Statement formats
+const (
+	FORMAT_CSV = "csv"
+	FORMAT_OFX = "ofx" // OFX 1.x (SGML) and 2.x (XML), also sold as QFX
+	FORMAT_QIF = "qif"
+)
+
+// Sign conventions for a single amount column
+const (
+	SIGN_NORMAL   = "normal"   // positive is money in
+	SIGN_INVERTED = "inverted" // positive is money out, as on card statements
+)
+
+// IMPORT_TIME is the time given to statement lines, which carry only a date
+const IMPORT_TIME = "00:00"
+
+var importProfilesMutex sync.Mutex
+
+var dateFormatPattern = regexp.MustCompile(`^(DD|MM|YYYY|YY|Mon|[-/. ])+$`)
+
+type ImportProfile struct {
+	Name              string `json:"name"`
+	Account           string `json:"account"`        // the account the statement is for
+	DefaultAccount    string `json:"defaultAccount"` // the other side of every line
+	DateColumn        string `json:"dateColumn"`     // header name or 1-based column number
+	DateFormat        string `json:"dateFormat"`     // e.g. DD/MM/YYYY, YYYY-MM-DD, DD-Mon-YY
+	DescriptionColumn string `json:"descriptionColumn"`
+	AmountColumn      string `json:"amountColumn"` // a signed amount, or
+	DebitColumn       string `json:"debitColumn"`  // money out and
+	CreditColumn      string `json:"creditColumn"` // money in as separate columns
+	Sign              string `json:"sign"`
+	SkipRows          int    `json:"skipRows"`  // lines before the header row
+	Delimiter         string `json:"delimiter"` // ",", ";" or "tab"
+	Format            string `json:"format"`    // csv (default), ofx or qif
+}
+
+// ImportRow is one parsed statement line. Line is the line number in the
+// file; Error explains why the line cannot be imported. Rule names the
+// rule that categorized the line. Duplicates lists existing transactions
+// the line looks like; it is imported anyway unless excluded.
+type ImportRow struct {
+	Line        int         `json:"line"`
+	Transaction Transaction `json:"transaction"`
+	Error       string      `json:"error,omitempty"`
+	Rule        string      `json:"rule,omitempty"`
+	Duplicates  []string    `json:"duplicates,omitempty"`
+}
+
+func readImportProfiles() ([]ImportProfile, error) {
+	rows, cols, err := store.ReadTable("import_profiles")
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []ImportProfile
+	for _, record := range rows {
+		skip, _ := strconv.Atoi(columnValue(record, cols, "SkipRows"))
+		profiles = append(profiles, ImportProfile{
+			Name:              columnValue(record, cols, "Name"),
+			Account:           columnValue(record, cols, "Account"),
+			DefaultAccount:    columnValue(record, cols, "DefaultAccount"),
+			DateColumn:        columnValue(record, cols, "DateColumn"),
+			DateFormat:        columnValue(record, cols, "DateFormat"),
+			DescriptionColumn: columnValue(record, cols, "DescriptionColumn"),
+			AmountColumn:      columnValue(record, cols, "AmountColumn"),
+			DebitColumn:       columnValue(record, cols, "DebitColumn"),
+			CreditColumn:      columnValue(record, cols, "CreditColumn"),
+			Sign:              columnValue(record, cols, "Sign"),
+			SkipRows:          skip,
+			Delimiter:         columnValue(record, cols, "Delimiter"),
+			Format:            columnValue(record, cols, "Format"),
+		})
+	}
+	return profiles, nil
+}
+
+func writeImportProfiles(profiles []ImportProfile) error {
+	rows := make([][]string, 0, len(profiles))
+	for _, p := range profiles {
+		rows = append(rows, []string{
+			p.Name,
+			p.Account,
+			p.DefaultAccount,
+			p.DateColumn,
+			p.DateFormat,
+			p.DescriptionColumn,
+			p.AmountColumn,
+			p.DebitColumn,
+			p.CreditColumn,
+			p.Sign,
+			strconv.Itoa(p.SkipRows),
+			p.Delimiter,
+			p.Format,
+		})
+	}
+	return store.WriteTable("import_profiles", importProfileHeader, rows)
+}
+
+func findImportProfile(profiles []ImportProfile, name string) int {
+	for i, p := range profiles {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadImportProfile returns the saved profile with the given name
+func loadImportProfile(name string) (ImportProfile, error) {
+	profiles, err := readImportProfiles()
+	if err != nil {
+		return ImportProfile{}, err
+	}
+	i := findImportProfile(profiles, name)
+	if i < 0 {
+		return ImportProfile{}, fmt.Errorf("unknown import profile: %s", name)
+	}
+	return profiles[i], nil
+}
+
+func validateImportProfile(p *ImportProfile) error {
+	p.Name = sanitizeInput(p.Name)
+	p.Account = sanitizeInput(p.Account)
+	p.DefaultAccount = sanitizeInput(p.DefaultAccount)
+	p.DateColumn = sanitizeInput(p.DateColumn)
+	p.DateFormat = strings.TrimSpace(p.DateFormat)
+	p.DescriptionColumn = sanitizeInput(p.DescriptionColumn)
+	p.AmountColumn = sanitizeInput(p.AmountColumn)
+	p.DebitColumn = sanitizeInput(p.DebitColumn)
+	p.CreditColumn = sanitizeInput(p.CreditColumn)
+
+	if p.Name == "" || len(p.Name) > 50 {
+		return errors.New("profile name required (max 50 characters)")
+	}
+	if p.Account == "" || p.DefaultAccount == "" {
+		return errors.New("account and defaultAccount required")
+	}
+	if p.Account == p.DefaultAccount {
+		return errors.New("account and defaultAccount must differ")
+	}
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{p.Account, p.DefaultAccount} {
+		if findAccount(accounts, name).Name == "" {
+			return fmt.Errorf("unknown account: %s", name)
+		}
+	}
+
+	switch p.Format {
+	case "":
+		p.Format = FORMAT_CSV
+	case FORMAT_CSV, FORMAT_OFX, FORMAT_QIF:
+	default:
+		return errors.New("format must be csv, ofx or qif")
+	}
+
+	if p.DateFormat == "" {
+		p.DateFormat = "DD-MM-YYYY"
+	}
+	if !dateFormatPattern.MatchString(p.DateFormat) {
+		return errors.New("dateFormat may only use DD, MM, Mon, YYYY, YY and - / . or space")
+	}
+
+	// OFX carries its own layout; QIF only needs the date order
+	if p.Format != FORMAT_CSV {
+		return nil
+	}
+
+	if p.DateColumn == "" || p.DescriptionColumn == "" {
+		return errors.New("dateColumn and descriptionColumn required")
+	}
+
+	if p.AmountColumn == "" && (p.DebitColumn == "" || p.CreditColumn == "") {
+		return errors.New("give amountColumn, or both debitColumn and creditColumn")
+	}
+	if p.AmountColumn != "" && (p.DebitColumn != "" || p.CreditColumn != "") {
+		return errors.New("use either amountColumn or debitColumn and creditColumn")
+	}
+	switch p.Sign {
+	case "":
+		p.Sign = SIGN_NORMAL
+	case SIGN_NORMAL, SIGN_INVERTED:
+	default:
+		return errors.New("sign must be normal or inverted")
+	}
+
+	if p.SkipRows < 0 || p.SkipRows > 100 {
+		return errors.New("skipRows must be between 0 and 100")
+	}
+	switch p.Delimiter {
+	case "":
+		p.Delimiter = ","
+	case ",", ";", "tab":
+	default:
+		return errors.New("delimiter must be , ; or tab")
+	}
+	return nil
+}
+
+// dateLayout turns a profile date format such as DD/MM/YYYY into a Go
+// time layout
+func dateLayout(format string) string {
+	return strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "DD", "02", "Mon", "Jan").Replace(format)
+}
+
+// parseStatementAmount reads a bank amount such as "1,250.00", "₹ 40",
+// "(12.50)" or "-3"
+func parseStatementAmount(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+	s = strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '+' {
+			return r
+		}
+		return -1
+	}, s)
+
+	m, err := ParseMoney(s)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		m = -m
+	}
+	return m, nil
+}
+
+// statementColumn resolves a profile column given by header name or
+// 1-based number; "" yields -1
+func statementColumn(header []string, column string) (int, error) {
+	if column == "" {
+		return -1, nil
+	}
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 || n > len(header) {
+			return 0, fmt.Errorf("column %d is out of range", n)
+		}
+		return n - 1, nil
+	}
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q not found in the header", column)
+}
+
+// parseStatement reads a statement in the profile's format. Lines that
+// cannot be read are returned with an Error instead of failing the whole
+// file.
+func parseStatement(p ImportProfile, data []byte) ([]ImportRow, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	switch p.Format {
+	case FORMAT_OFX:
+		return parseOFX(p, data)
+	case FORMAT_QIF:
+		return parseQIF(p, data)
+	default:
+		return parseCSVStatement(p, data)
+	}
+}
+
+// fitDescription shortens a statement description a whole character at a
+// time until it fits MAX_DESCRIPTION once validateTransaction escapes it
+func fitDescription(description string) string {
+	runes := []rune(strings.TrimSpace(description))
+	// Escaping never makes a character shorter than one byte
+	if len(runes) > MAX_DESCRIPTION {
+		runes = runes[:MAX_DESCRIPTION]
+	}
+	for len(runes) > 0 && len(sanitizeInput(string(runes))) > MAX_DESCRIPTION {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+// statementTransaction turns a signed statement amount, positive for money
+// into the profile's account, into a transaction
+func (p ImportProfile) statementTransaction(date, tranTime, description string, amount Money) Transaction {
+	// validateTransaction escapes the description later
+	description = fitDescription(description)
+	t := Transaction{TranDate: date, TranTime: tranTime, Description: description}
+	if amount >= 0 {
+		t.From, t.To, t.Amount = p.DefaultAccount, p.Account, amount
+	} else {
+		t.From, t.To, t.Amount = p.Account, p.DefaultAccount, -amount
+	}
+	return t
+}
+
+func parseCSVStatement(p ImportProfile, data []byte) ([]ImportRow, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+	switch p.Delimiter {
+	case ";":
+		reader.Comma = ';'
+	case "tab":
+		reader.Comma = '\t'
+	}
+
+	var header []string
+	skipped := 0
+	for header == nil {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil, errors.New("statement has no header row")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if skipped < p.SkipRows {
+			skipped++
+			continue
+		}
+		header = record
+	}
+
+	dateCol, err := statementColumn(header, p.DateColumn)
+	if err != nil {
+		return nil, err
+	}
+	descCol, err := statementColumn(header, p.DescriptionColumn)
+	if err != nil {
+		return nil, err
+	}
+	amountCol, err := statementColumn(header, p.AmountColumn)
+	if err != nil {
+		return nil, err
+	}
+	debitCol, err := statementColumn(header, p.DebitColumn)
+	if err != nil {
+		return nil, err
+	}
+	creditCol, err := statementColumn(header, p.CreditColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	layout := dateLayout(p.DateFormat)
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rows = append(rows, ImportRow{Line: line, Error: err.Error()})
+			continue
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		row := ImportRow{Line: line}
+		description := field(record, descCol)
+
+		date, err := time.Parse(layout, field(record, dateCol))
+		if err != nil {
+			row.Transaction = p.statementTransaction("", IMPORT_TIME, description, 0)
+			row.Error = fmt.Sprintf("invalid date %q (expected %s)", field(record, dateCol), p.DateFormat)
+			rows = append(rows, row)
+			continue
+		}
+
+		// amount is signed: positive is money into the account
+		var amount Money
+		if amountCol >= 0 {
+			amount, err = parseStatementAmount(field(record, amountCol))
+			if p.Sign == SIGN_INVERTED {
+				amount = -amount
+			}
+		} else {
+			var debit, credit Money
+			debit, err = parseStatementAmount(field(record, debitCol))
+			if err == nil {
+				credit, err = parseStatementAmount(field(record, creditCol))
+			}
+			if debit < 0 {
+				debit = -debit
+			}
+			if credit < 0 {
+				credit = -credit
+			}
+			amount = credit - debit
+		}
+		row.Transaction = p.statementTransaction(date.Format("02-01-2006"), IMPORT_TIME, description, amount)
+		if err != nil {
+			row.Error = "invalid amount"
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// markImported flags lines whose FITID the account already has, or that
+// repeat an earlier line of the same statement
+func markImported(rows []ImportRow, account string, transactions []Transaction) {
+	seen := make(map[string]bool)
+	for _, t := range transactions {
+		if t.FITID != "" && (t.From == account || t.To == account) {
+			seen[t.FITID] = true
+		}
+	}
+
+	for i := range rows {
+		fitid := rows[i].Transaction.FITID
+		if fitid == "" || rows[i].Error != "" {
+			continue
+		}
+		if seen[fitid] {
+			rows[i].Error = fmt.Sprintf("already imported (FITID %s)", fitid)
+		}
+		seen[fitid] = true
+	}
+}
+
+// categorizeImportRows runs the rules over every valid line, replacing the
+// profile's DefaultAccount
+func categorizeImportRows(rows []ImportRow, account string) error {
+	rules, err := readRules()
+	if err != nil {
+		return err
+	}
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	for i := range rows {
+		if rows[i].Error == "" {
+			rows[i].Rule = applyRules(rules, &rows[i].Transaction, account, accounts)
+		}
+	}
+	return nil
+}
+
+// markDuplicates lists the existing transactions each valid line may
+// duplicate, such as the same purchase entered by hand
+func markDuplicates(rows []ImportRow, transactions []Transaction) {
+	for i := range rows {
+		if rows[i].Error == "" {
+			rows[i].Duplicates = duplicateIDs(findDuplicates(rows[i].Transaction, transactions))
+		}
+	}
+}
+
+// validateImportRows runs every readable line through validateTransaction
+func validateImportRows(rows []ImportRow) {
+	for i := range rows {
+		if rows[i].Error != "" {
+			continue
+		}
+		if err := validateTransaction(&rows[i].Transaction); err != nil {
+			rows[i].Error = err.Error()
+		}
+	}
+}
+
+// importStatement parses and validates a statement and, when commit is
+// set, adds every line without an error in one batch. Lines listed in
+// exclude are left out.
+func importStatement(p ImportProfile, data []byte, exclude []int, commit bool) ([]ImportRow, int, error) {
+	rows, err := parseStatement(p, data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	excluded := make(map[int]bool, len(exclude))
+	for _, line := range exclude {
+		excluded[line] = true
+	}
+	kept := rows[:0]
+	for _, row := range rows {
+		if !excluded[row.Line] {
+			kept = append(kept, row)
+		}
+	}
+	rows = kept
+
+	transactions, err := readAllTransactions()
+	if err != nil {
+		return nil, 0, err
+	}
+	markImported(rows, p.Account, transactions)
+	validateImportRows(rows)
+	if err := categorizeImportRows(rows, p.Account); err != nil {
+		return nil, 0, err
+	}
+	markDuplicates(rows, transactions)
+	if !commit {
+		return rows, 0, nil
+	}
+
+	var trans []Transaction
+	for _, row := range rows {
+		if row.Error == "" {
+			trans = append(trans, row.Transaction)
+		}
+	}
+	if len(trans) == 0 {
+		return rows, 0, nil
+	}
+	if err := addTransactions(trans); err != nil {
+		return rows, 0, err
+	}
+	if err := recalculateAllData(); err != nil {
+		log.Printf("Error recalculating data: %v", err)
+	}
+	return rows, len(trans), nil
+}
+
+func handleImportProfiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		profiles, err := readImportProfiles()
+		if err != nil {
+			respondError(w, "Failed to load import profiles", http.StatusInternalServerError)
+			return
+		}
+		if profiles == nil {
+			profiles = []ImportProfile{}
+		}
+		json.NewEncoder(w).Encode(profiles)
+
+	case http.MethodPost:
+		var profile ImportProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+
+		if err := validateImportProfile(&profile); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Saving under an existing name replaces that profile
+		importProfilesMutex.Lock()
+		profiles, err := readImportProfiles()
+		if err == nil {
+			if i := findImportProfile(profiles, profile.Name); i >= 0 {
+				profiles[i] = profile
+			} else {
+				profiles = append(profiles, profile)
+			}
+			err = writeImportProfiles(profiles)
+		}
+		importProfilesMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to save import profile", http.StatusInternalServerError)
+			return
+		}
+
+		logSecurityEvent("IMPORT_PROFILE_SAVE", getClientIP(r), fmt.Sprintf("Saved import profile: %s", profile.Name))
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "profile": profile})
+
+	case http.MethodDelete:
+		var data map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+		name := sanitizeInput(data["name"])
+
+		importProfilesMutex.Lock()
+		profiles, err := readImportProfiles()
+		i := -1
+		if err == nil {
+			if i = findImportProfile(profiles, name); i >= 0 {
+				err = writeImportProfiles(append(profiles[:i], profiles[i+1:]...))
+			}
+		}
+		importProfilesMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to delete import profile", http.StatusInternalServerError)
+			return
+		}
+		if i < 0 {
+			respondError(w, "Import profile not found", http.StatusNotFound)
+			return
+		}
+
+		logSecurityEvent("IMPORT_PROFILE_DELETE", getClientIP(r), fmt.Sprintf("Deleted import profile: %s", name))
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleImport previews (/api/import/preview) or commits
+// (/api/import/commit) a statement sent as {"profile", "data", "exclude"}
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Profile string `json:"profile"`
+		Data    string `json:"data"`
+		Exclude []int  `json:"exclude"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request data", http.StatusBadRequest)
+		return
+	}
+	commit := strings.HasSuffix(r.URL.Path, "/commit")
+
+	profile, err := loadImportProfile(sanitizeInput(req.Profile))
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, imported, err := importStatement(profile, []byte(req.Data), req.Exclude, commit)
+	if err != nil {
+		if commit && rows != nil {
+			respondError(w, "Failed to import transactions", http.StatusInternalServerError)
+		} else {
+			respondError(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if rows == nil {
+		rows = []ImportRow{}
+	}
+
+	if commit {
+		logSecurityEvent("IMPORT", getClientIP(r), fmt.Sprintf("Imported %d of %d lines with profile %s", imported, len(rows), profile.Name))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"rows":     rows,
+		"imported": imported,
+	})
+}
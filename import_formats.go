@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OFX and QIF statements for the import in import.go. Both give signed
+// amounts, positive for money into the account. OFX lines keep the bank's
+// FITID; QIF has none, so each line gets one hashed from its fields.
+
+// ofxTagPattern matches a tag and the text up to the next tag. OFX 1.x is
+// SGML where leaf elements are never closed, so a leaf's value is simply
+// the text after its opening tag; the closing tags of OFX 2.x are skipped.
+var ofxTagPattern = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)>([^<]*)`)
+
+// parseOFX reads the STMTTRN entries of an OFX or QFX file
+func parseOFX(p ImportProfile, data []byte) ([]ImportRow, error) {
+	var rows []ImportRow
+	var fields map[string]string
+	line := 0
+	found := false
+
+	for _, m := range ofxTagPattern.FindAllSubmatchIndex(data, -1) {
+		closing := m[3] > m[2]
+		tag := strings.ToUpper(string(data[m[4]:m[5]]))
+		value := strings.TrimSpace(html.UnescapeString(string(data[m[6]:m[7]])))
+
+		switch {
+		case tag == "OFX":
+			found = true
+		case tag == "STMTTRN" && !closing:
+			fields = make(map[string]string)
+			line = bytes.Count(data[:m[0]], []byte("\n")) + 1
+		case tag == "STMTTRN" && closing:
+			if fields != nil {
+				rows = append(rows, ofxRow(p, line, fields))
+			}
+			fields = nil
+		case fields != nil && !closing && value != "":
+			if _, ok := fields[tag]; !ok {
+				fields[tag] = value
+			}
+		}
+	}
+
+	if !found {
+		return nil, errors.New("not an OFX file")
+	}
+	return rows, nil
+}
+
+func ofxRow(p ImportProfile, line int, fields map[string]string) ImportRow {
+	description := fields["NAME"]
+	if description == "" {
+		description = fields["MEMO"]
+	}
+	if description == "" {
+		description = fields["TRNTYPE"]
+	}
+
+	row := ImportRow{Line: line}
+
+	// DTPOSTED is YYYYMMDD, optionally followed by HHMMSS and a time zone
+	posted := fields["DTPOSTED"]
+	date, err := time.Parse("20060102", firstN(posted, 8))
+	if err != nil {
+		row.Transaction = p.statementTransaction("", IMPORT_TIME, description, 0)
+		row.Error = fmt.Sprintf("invalid date %q", posted)
+		return row
+	}
+	tranTime := IMPORT_TIME
+	if len(posted) >= 12 && isValidTime(posted[8:10]+":"+posted[10:12]) {
+		tranTime = posted[8:10] + ":" + posted[10:12]
+	}
+
+	// Some banks write a decimal comma
+	raw := fields["TRNAMT"]
+	if strings.Contains(raw, ",") && !strings.Contains(raw, ".") {
+		raw = strings.Replace(raw, ",", ".", 1)
+	}
+	amount, err := parseStatementAmount(raw)
+
+	row.Transaction = p.statementTransaction(date.Format("02-01-2006"), tranTime, description, amount)
+	row.Transaction.FITID = sanitizeInput(fields["FITID"])
+	if err != nil || raw == "" {
+		row.Error = "invalid amount"
+	}
+	return row
+}
+
+func firstN(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
+
+// qifLists are the QIF sections that list accounts, categories and the
+// like rather than transactions
+var qifLists = map[string]bool{
+	"!ACCOUNT":        true,
+	"!TYPE:CAT":       true,
+	"!TYPE:CLASS":     true,
+	"!TYPE:MEMORIZED": true,
+	"!TYPE:PRICES":    true,
+	"!TYPE:SECURITY":  true,
+}
+
+// parseQIF reads the records of a QIF file. Dates follow the order of the
+// profile's DateFormat, e.g. MM/DD/YY for most US exports.
+func parseQIF(p ImportProfile, data []byte) ([]ImportRow, error) {
+	order := dateOrder(p.DateFormat)
+	var rows []ImportRow
+	fields := make(map[byte]string)
+	start := 0
+	seen := make(map[string]int)
+	list := false
+
+	flush := func() {
+		defer func() { fields = make(map[byte]string) }()
+		if fields['D'] == "" && fields['T'] == "" && fields['U'] == "" {
+			return // account or category lists, not a transaction
+		}
+
+		description := fields['P']
+		if description == "" {
+			description = fields['M']
+		}
+		row := ImportRow{Line: start}
+
+		date, err := parseQIFDate(fields['D'], order)
+		if err != nil {
+			row.Transaction = p.statementTransaction("", IMPORT_TIME, description, 0)
+			row.Error = fmt.Sprintf("invalid date %q (expected %s)", fields['D'], p.DateFormat)
+			rows = append(rows, row)
+			return
+		}
+
+		raw := fields['T']
+		if raw == "" {
+			raw = fields['U']
+		}
+		amount, err := parseStatementAmount(raw)
+		row.Transaction = p.statementTransaction(date, IMPORT_TIME, description, amount)
+		// parseStatementAmount drops what is not part of a number, so
+		// text with no digits at all would read as zero
+		if err != nil || !strings.ContainsAny(raw, "0123456789") {
+			row.Error = "invalid amount"
+		}
+
+		// Identical lines in one file are told apart by their position
+		key := strings.Join([]string{date, raw, fields['P'], fields['M'], fields['N']}, "\x00")
+		seen[key]++
+		sum := sha256.Sum256([]byte(key + "\x00" + strconv.Itoa(seen[key])))
+		row.Transaction.FITID = "QIF-" + hex.EncodeToString(sum[:8])
+
+		rows = append(rows, row)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		if text[0] == '!' {
+			// Option and Clear lines leave the section as it is
+			header := strings.ToUpper(strings.TrimSpace(text))
+			if header == "!ACCOUNT" || strings.HasPrefix(header, "!TYPE:") {
+				list = qifLists[header]
+			}
+			continue
+		}
+		if text[0] == '^' {
+			if list {
+				fields = make(map[byte]string)
+			} else {
+				flush()
+			}
+			continue
+		}
+		if len(fields) == 0 {
+			start = lineNo
+		}
+		// Split lines (S, E, $) belong to the category breakdown
+		if _, ok := fields[text[0]]; !ok {
+			fields[text[0]] = strings.TrimSpace(text[1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 && !list {
+		flush()
+	}
+	return rows, nil
+}
+
+// dateOrder returns the order of day, month and year in a date format,
+// e.g. "MDY" for MM/DD/YY
+func dateOrder(format string) string {
+	type part struct {
+		at   int
+		kind byte
+	}
+	var parts []part
+	for token, kind := range map[string]byte{"DD": 'D', "MM": 'M', "Mon": 'M', "YY": 'Y'} {
+		if i := strings.Index(format, token); i >= 0 {
+			parts = append(parts, part{i, kind})
+		}
+	}
+	order := ""
+	for len(parts) > 0 {
+		first := 0
+		for i := range parts {
+			if parts[i].at < parts[first].at {
+				first = i
+			}
+		}
+		if !strings.ContainsRune(order, rune(parts[first].kind)) {
+			order += string(parts[first].kind)
+		}
+		parts = append(parts[:first], parts[first+1:]...)
+	}
+	if len(order) != 3 {
+		return "DMY"
+	}
+	return order
+}
+
+var qifDatePattern = regexp.MustCompile(`\d+`)
+
+// parseQIFDate reads dates such as 1/ 5'26, 01/05/2026 or 2026-01-05 and
+// returns DD-MM-YYYY. A date starting with a four-digit year is read as
+// year, month, day whatever the order.
+func parseQIFDate(s, order string) (string, error) {
+	nums := qifDatePattern.FindAllString(s, -1)
+	if len(nums) != 3 {
+		return "", errors.New("invalid date")
+	}
+	if len(nums[0]) == 4 {
+		order = "YMD"
+	}
+
+	var day, month, year int
+	for i, kind := range order {
+		n, _ := strconv.Atoi(nums[i])
+		switch kind {
+		case 'D':
+			day = n
+		case 'M':
+			month = n
+		case 'Y':
+			year = n
+		}
+	}
+	if year < 100 {
+		if year >= 70 {
+			year += 1900
+		} else {
+			year += 2000
+		}
+	}
+
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if date.Day() != day || int(date.Month()) != month {
+		return "", errors.New("invalid date")
+	}
+	return date.Format("02-01-2006"), nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var testImportProfile = ImportProfile{Account: "HDFC", DefaultAccount: "Uncategorized", DateFormat: "DD/MM/YYYY"}
+
+// wantRow is the part of an ImportRow the parser tests check
+type wantRow struct {
+	line     int
+	date     string
+	tranTime string
+	from     string
+	to       string
+	desc     string
+	amount   Money
+	fitid    string
+	err      string
+}
+
+func checkImportRows(t *testing.T, name string, got []ImportRow, want []wantRow) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d rows, want %d: %+v", name, len(got), len(want), got)
+		return
+	}
+	for i, w := range want {
+		g := got[i]
+		tran := g.Transaction
+		if w.err != "" || g.Error != "" {
+			if g.Error != w.err {
+				t.Errorf("%s: row %d error = %q, want %q", name, i, g.Error, w.err)
+			}
+			continue
+		}
+		if g.Line != w.line || tran.TranDate != w.date || tran.TranTime != w.tranTime ||
+			tran.From != w.from || tran.To != w.to || tran.Description != w.desc || tran.Amount != w.amount {
+			t.Errorf("%s: row %d = line %d, %s %s, %s -> %s, %q, %s; want line %d, %s %s, %s -> %s, %q, %s",
+				name, i, g.Line, tran.TranDate, tran.TranTime, tran.From, tran.To, tran.Description, tran.Amount,
+				w.line, w.date, w.tranTime, w.from, w.to, w.desc, w.amount)
+		}
+		if w.fitid != "" && tran.FITID != w.fitid {
+			t.Errorf("%s: row %d FITID = %q, want %q", name, i, tran.FITID, w.fitid)
+		}
+	}
+}
+
+// OFX 1.x is SGML: leaf elements such as <TRNAMT> are never closed
+const sgmlOFX = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+ENCODING:USASCII
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS><DTSERVER>20261016</SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>INR
+<BANKTRANLIST>
+<DTSTART>20261001
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20261002093000.000[+5.30:IST]
+<TRNAMT>-450.00
+<FITID>2026100201
+<NAME>SWIGGY &amp; CO
+<MEMO>Food order
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20261005
+<TRNAMT>85000,00
+<FITID>2026100502
+<MEMO>SALARY OCT
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>FEE
+<DTPOSTED>20261007
+<TRNAMT>-10
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>2026-10-08
+<TRNAMT>-5
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20261009
+<NAME>No amount
+</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>84540.00<DTASOF>20261016</LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+// OFX 2.x is XML with every element closed
+const xmlOFX = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+<CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS><BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20261003120000</DTPOSTED><TRNAMT>-12.5</TRNAMT><FITID>A1</FITID><NAME>Metro card</NAME></STMTTRN>
+<STMTTRN>
+  <TRNTYPE>ATM</TRNTYPE>
+  <DTPOSTED>20261004</DTPOSTED>
+  <TRNAMT>-2000.00</TRNAMT>
+  <FITID>A2</FITID>
+  <NAME></NAME>
+  <MEMO>ATM withdrawal</MEMO>
+</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT</TRNTYPE><DTPOSTED>20261006</DTPOSTED><TRNAMT>100.00</TRNAMT><FITID>A3</FITID><PAYEE><NAME>Refund</NAME></PAYEE><NAME>Ignored</NAME></STMTTRN>
+</BANKTRANLIST></CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1>
+</OFX>
+`
+
+func TestParseOFX(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []wantRow
+	}{
+		{
+			name: "SGML",
+			data: sgmlOFX,
+			want: []wantRow{
+				{line: 12, date: "02-10-2026", tranTime: "09:30", from: "HDFC", to: "Uncategorized", desc: "SWIGGY & CO", amount: 45000, fitid: "2026100201"},
+				// A decimal comma, and MEMO when there is no NAME
+				{line: 20, date: "05-10-2026", tranTime: "00:00", from: "Uncategorized", to: "HDFC", desc: "SALARY OCT", amount: 8500000, fitid: "2026100502"},
+				// No NAME or MEMO: the transaction type, and no FITID
+				{line: 27, date: "07-10-2026", tranTime: "00:00", from: "HDFC", to: "Uncategorized", desc: "FEE", amount: 1000},
+				{err: `invalid date "2026-10-08"`},
+				{err: "invalid amount"},
+			},
+		},
+		{
+			name: "XML",
+			data: xmlOFX,
+			want: []wantRow{
+				{line: 5, date: "03-10-2026", tranTime: "12:00", from: "HDFC", to: "Uncategorized", desc: "Metro card", amount: 1250, fitid: "A1"},
+				// An empty NAME falls back to MEMO
+				{line: 6, date: "04-10-2026", tranTime: "00:00", from: "HDFC", to: "Uncategorized", desc: "ATM withdrawal", amount: 200000, fitid: "A2"},
+				// The first NAME wins
+				{line: 14, date: "06-10-2026", tranTime: "00:00", from: "Uncategorized", to: "HDFC", desc: "Refund", amount: 10000, fitid: "A3"},
+			},
+		},
+		{
+			name: "no transactions",
+			data: "<OFX><BANKMSGSRSV1></BANKMSGSRSV1></OFX>",
+		},
+		{
+			name: "lower case tags",
+			data: "<ofx><stmttrn><dtposted>20261001<trnamt>7<fitid>x1<name>Tea</stmttrn></ofx>",
+			want: []wantRow{
+				{line: 1, date: "01-10-2026", tranTime: "00:00", from: "Uncategorized", to: "HDFC", desc: "Tea", amount: 700, fitid: "x1"},
+			},
+		},
+		{
+			// A transaction cut off before its closing tag is dropped
+			name: "unterminated",
+			data: "<OFX><STMTTRN><DTPOSTED>20261001<TRNAMT>7<FITID>x1",
+		},
+	}
+
+	for _, tt := range tests {
+		rows, err := parseOFX(testImportProfile, []byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		checkImportRows(t, tt.name, rows, tt.want)
+	}
+
+	for _, data := range []string{"", "Date,Amount\n01/10/2026,5\n", "!Type:Bank\nD10/01'26\nT-5\n^\n"} {
+		if _, err := parseOFX(testImportProfile, []byte(data)); err == nil {
+			t.Errorf("parseOFX(%q) read a file that is not OFX", data)
+		}
+	}
+}
+
+func TestParseQIF(t *testing.T) {
+	us := testImportProfile
+	us.DateFormat = "MM/DD/YY"
+
+	tests := []struct {
+		name    string
+		profile ImportProfile
+		data    string
+		want    []wantRow
+	}{
+		{
+			name:    "day first",
+			profile: testImportProfile,
+			data:    "!Type:Bank\nD05/10/2026\nT-1,250.00\nPSWIGGY\nMDinner\n^\nD6/10/26\nU300\nMRefund\n^\n",
+			want: []wantRow{
+				{line: 2, date: "05-10-2026", tranTime: "00:00", from: "HDFC", to: "Uncategorized", desc: "SWIGGY", amount: 125000},
+				// U when there is no T, and the memo when there is no payee
+				{line: 7, date: "06-10-2026", tranTime: "00:00", from: "Uncategorized", to: "HDFC", desc: "Refund", amount: 30000},
+			},
+		},
+		{
+			name:    "month first",
+			profile: us,
+			data:    "!Type:CCard\r\nD10/ 5'26\r\nT-42.10\r\nPCoffee\r\n^\r\nD12/31/99\r\nT-1\r\nPOld\r\n^\r\n",
+			want: []wantRow{
+				{line: 2, date: "05-10-2026", tranTime: "00:00", from: "HDFC", to: "Uncategorized", desc: "Coffee", amount: 4210},
+				{line: 6, date: "31-12-1999", tranTime: "00:00", from: "HDFC", to: "Uncategorized", desc: "Old", amount: 100},
+			},
+		},
+		{
+			// The split lines break the total down by category; the
+			// transaction is imported once for its total
+			name:    "splits",
+			profile: testImportProfile,
+			data:    "!Type:Bank\nD16/10/2026\nT-100.00\nPSupermarket\nLGroceries\nSGroceries\nEFood\n$-60.00\nSHousehold\nESoap\n$-40.00\n^\n",
+			want: []wantRow{
+				{line: 2, date: "16-10-2026", tranTime: "00:00", from: "HDFC", to: "Uncategorized", desc: "Supermarket", amount: 10000},
+			},
+		},
+		{
+			name:    "lists and options",
+			profile: testImportProfile,
+			data: "!Option:AutoSwitch\n!Account\nNChecking\nTBank\n^\n!Clear:AutoSwitch\n" +
+				"!Type:Cat\nNFood\nDFood and drink\nE\n^\n" +
+				"!Type:Bank\nD01/10/2026\nT5\nPInterest\n^\n",
+			want: []wantRow{
+				{line: 13, date: "01-10-2026", tranTime: "00:00", from: "Uncategorized", to: "HDFC", desc: "Interest", amount: 500},
+			},
+		},
+		{
+			name:    "no closing caret",
+			profile: testImportProfile,
+			data:    "!Type:Bank\nD01/10/2026\nT-5\nPLast",
+			want: []wantRow{
+				{line: 2, date: "01-10-2026", tranTime: "00:00", from: "HDFC", to: "Uncategorized", desc: "Last", amount: 500},
+			},
+		},
+		{
+			name:    "bad lines",
+			profile: us,
+			data:    "!Type:Bank\nD13/01/26\nT-5\n^\nD01/13/26\nTabc\n^\nT-5\nPNo date\n^\n",
+			want: []wantRow{
+				{err: `invalid date "13/01/26" (expected MM/DD/YY)`},
+				{err: "invalid amount"},
+				{err: `invalid date "" (expected MM/DD/YY)`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		rows, err := parseQIF(tt.profile, []byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		checkImportRows(t, tt.name, rows, tt.want)
+		for i, row := range rows {
+			if row.Error == "" && !strings.HasPrefix(row.Transaction.FITID, "QIF-") {
+				t.Errorf("%s: row %d FITID = %q", tt.name, i, row.Transaction.FITID)
+			}
+		}
+	}
+}
+
+func TestParseQIFDate(t *testing.T) {
+	tests := []struct {
+		in    string
+		order string
+		want  string // "" for an error
+	}{
+		{"05/10/2026", "DMY", "05-10-2026"},
+		{"05/10/2026", "MDY", "10-05-2026"},
+		{"5.10.26", "DMY", "05-10-2026"},
+		{"10/ 5'26", "MDY", "05-10-2026"},
+		{"1/ 5' 6", "MDY", "05-01-2006"},
+		// Two-digit years from 70 are the 1900s
+		{"31/12/69", "DMY", "31-12-2069"},
+		{"01/01/70", "DMY", "01-01-1970"},
+		{"12/31'99", "MDY", "31-12-1999"},
+		{"2026-10-05", "YMD", "05-10-2026"},
+		{"2026-10-05", "DMY", "05-10-2026"},
+		{"2026-10-05", "MDY", "05-10-2026"},
+		{"29/02/2024", "DMY", "29-02-2024"},
+
+		{"29/02/2026", "DMY", ""},
+		{"05/13/2026", "DMY", ""},
+		{"13/05/2026", "MDY", ""},
+		{"00/05/2026", "DMY", ""},
+		{"05/10", "DMY", ""},
+		{"05/10/2026 12:00", "DMY", ""},
+		{"", "DMY", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := parseQIFDate(tt.in, tt.order)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseQIFDate(%q, %s) = %s, want an error", tt.in, tt.order, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseQIFDate(%q, %s) = %q, %v, want %q", tt.in, tt.order, got, err, tt.want)
+		}
+	}
+}
+
+func TestDateOrder(t *testing.T) {
+	tests := map[string]string{
+		"DD/MM/YYYY": "DMY",
+		"MM/DD/YY":   "MDY",
+		"MM/DD/YYYY": "MDY",
+		"YYYY-MM-DD": "YMD",
+		"DD-Mon-YY":  "DMY",
+		"Mon DD YY":  "MDY",
+		"":           "DMY",
+		"DD/MM":      "DMY",
+	}
+	for format, want := range tests {
+		if got := dateOrder(format); got != want {
+			t.Errorf("dateOrder(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestImportFITIDs(t *testing.T) {
+	// The same QIF file gives the same FITIDs each time, so a second
+	// import is caught; identical lines within it still differ
+	qif := "!Type:Bank\nD01/10/2026\nT-5\nPTea\n^\nD01/10/2026\nT-5\nPTea\n^\nD02/10/2026\nT-5\nPTea\n^\n"
+	first, _ := parseQIF(testImportProfile, []byte(qif))
+	again, _ := parseQIF(testImportProfile, []byte(qif))
+	if len(first) != 3 || len(again) != 3 {
+		t.Fatalf("got %d and %d rows, want 3", len(first), len(again))
+	}
+	ids := make(map[string]bool)
+	for i := range first {
+		if first[i].Transaction.FITID != again[i].Transaction.FITID {
+			t.Errorf("row %d FITID changed from %s to %s", i, first[i].Transaction.FITID, again[i].Transaction.FITID)
+		}
+		ids[first[i].Transaction.FITID] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("identical lines share a FITID: %v", ids)
+	}
+
+	rows, err := parseOFX(testImportProfile, []byte(`<OFX>
+<STMTTRN><DTPOSTED>20261001<TRNAMT>-5<FITID>F1<NAME>Already imported</STMTTRN>
+<STMTTRN><DTPOSTED>20261001<TRNAMT>-5<FITID>F2<NAME>On another account</STMTTRN>
+<STMTTRN><DTPOSTED>20261002<TRNAMT>-5<FITID>F3<NAME>New</STMTTRN>
+<STMTTRN><DTPOSTED>20261002<TRNAMT>-5<FITID>F3<NAME>Repeated in the file</STMTTRN>
+<STMTTRN><DTPOSTED>bad<TRNAMT>-5<FITID>F4<NAME>Unreadable</STMTTRN>
+<STMTTRN><DTPOSTED>20261003<TRNAMT>-5<FITID>F4<NAME>Readable</STMTTRN>
+<STMTTRN><DTPOSTED>20261003<TRNAMT>-5<NAME>No FITID</STMTTRN>
+<STMTTRN><DTPOSTED>20261003<TRNAMT>-5<NAME>No FITID</STMTTRN>
+</OFX>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := []Transaction{
+		{From: "HDFC", To: "Food", FITID: "F1"},
+		{From: "ICICI", To: "Food", FITID: "F2"},
+	}
+	markImported(rows, "HDFC", existing)
+
+	want := []string{
+		"already imported (FITID F1)",
+		"",
+		"",
+		"already imported (FITID F3)",
+		`invalid date "bad"`,
+		"",
+		"",
+		"",
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i, w := range want {
+		if rows[i].Error != w {
+			t.Errorf("row %d (%s) error = %q, want %q", i, rows[i].Transaction.Description, rows[i].Error, w)
+		}
+	}
+}
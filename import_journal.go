@@ -0,0 +1,792 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Plain-text accounting journals, in beancount or ledger-cli/hledger
+// syntax, are imported the other way round from export.go. The top-level
+// account (Assets, Liabilities, Income, Expenses) gives the type and the
+// rest of the path the parent accounts: Expenses:Food:Dining becomes
+// Dining under Food. Equity is imported as income, since opening balances
+// are a source of money. Accounts that do not exist yet are created.
+//
+// Each journal transaction needs a single posting taking money out; the
+// postings it pays into become the legs of a split. Several postings
+// paying into a single one become one transaction each. A posting may
+// leave out its amount, and one in another currency than its source
+// carries the amount paid as a price (@@ total or @ per unit). Securities,
+// lot costs, balance assertions and other directives are not imported.
+
+// journalRootTypes maps top-level journal accounts to account types
+var journalRootTypes = map[string]string{
+	"assets":      "ASSET",
+	"liabilities": "LIABILITIES",
+	"equity":      "INCOME",
+	"income":      "INCOME",
+	"revenue":     "INCOME",
+	"revenues":    "INCOME",
+	"expenses":    "EXPENSE",
+}
+
+var currencySymbols = map[string]string{
+	"$": "USD",
+	"€": "EUR",
+	"£": "GBP",
+	"¥": "JPY",
+	"₹": "INR",
+}
+
+// A sign, a commodity before or after the number, e.g. -30.00 INR, $-12,
+// -₹1,250.50, 10 "NIFTY 50"
+var journalAmountPattern = regexp.MustCompile(`^(-?)\s*("[^"]*"|[^\d\s.,"+-]*)\s*(-?\d[\d,]*(?:\.\d*)?)\s*("[^"]*"|[^\d\s.,"+-]\S*)?$`)
+
+// Beancount metadata, e.g. time: "09:00"
+var journalMetaPattern = regexp.MustCompile(`^([a-z][A-Za-z0-9_-]*):(\s.*)?$`)
+
+// Ledger tags, e.g. :food:travel:
+var journalTagsPattern = regexp.MustCompile(`^(:[^:\s]+)+:$`)
+
+var transactionIDPattern = regexp.MustCompile(fmt.Sprintf(`^[0-9a-f]{%d}$`, 2*TRANSACTION_ID_LENGTH))
+
+var journalDirectives = map[string]bool{
+	"open": true, "close": true, "balance": true, "pad": true, "price": true, "note": true,
+	"document": true, "event": true, "commodity": true, "custom": true, "query": true,
+}
+
+type journalPosting struct {
+	Account      string
+	Amount       Money
+	Currency     string
+	Cost         Money // the amount paid in CostCurrency, from @@ or @
+	CostCurrency string
+	Elided       bool // no amount given; it balances the others
+}
+
+type journalEntry struct {
+	Line        int
+	Date        string // DD-MM-YYYY
+	Description string
+	Meta        map[string]string
+	Tags        []string
+	Postings    []journalPosting
+	Error       string
+}
+
+// journalDeclaration is an account declared by beancount's open and close
+// or ledger's account directive
+type journalDeclaration struct {
+	Currency   string
+	ClosedDate string
+}
+
+// parseJournalDate reads YYYY-MM-DD or YYYY/MM/DD, ignoring a ledger
+// auxiliary date after =
+func parseJournalDate(s string) (string, error) {
+	s, _, _ = strings.Cut(s, "=")
+	for _, layout := range []string{"2006-01-02", "2006/01/02", "2006.01.02"} {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d.Format("02-01-2006"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q (use YYYY-MM-DD)", s)
+}
+
+// journalCurrency turns a commodity into a currency code; empty means the
+// base currency
+func journalCurrency(commodity string) (string, error) {
+	if code, ok := currencySymbols[commodity]; ok {
+		return code, nil
+	}
+	if commodity == "" || isValidCurrency(commodity) {
+		return commodity, nil
+	}
+	return "", fmt.Errorf("commodity %s is not a currency (securities are not imported)", commodity)
+}
+
+// splitJournalAmount separates an amount into its signed number and its
+// currency
+func splitJournalAmount(s string) (string, string, error) {
+	m := journalAmountPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || (m[1] != "" && strings.HasPrefix(m[3], "-")) || (m[2] != "" && m[4] != "") {
+		return "", "", fmt.Errorf("invalid amount %q", strings.TrimSpace(s))
+	}
+	currency, err := journalCurrency(m[2] + m[4])
+	return m[1] + strings.ReplaceAll(m[3], ",", ""), currency, err
+}
+
+// parseJournalAmount reads an amount with its currency
+func parseJournalAmount(s string) (Money, string, error) {
+	number, currency, err := splitJournalAmount(s)
+	if err != nil {
+		return 0, "", err
+	}
+	amount, err := ParseMoney(number)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid amount %q", strings.TrimSpace(s))
+	}
+	return amount, currency, nil
+}
+
+// parsePosting reads an indented posting line, without its comment
+func parsePosting(line string) (journalPosting, error) {
+	var p journalPosting
+	line = strings.TrimSpace(strings.TrimLeft(line, " \t*!"))
+	if strings.HasPrefix(line, "[") || strings.HasPrefix(line, "(") {
+		return p, errors.New("virtual postings are not supported")
+	}
+
+	// The account ends at two spaces or a tab. Beancount accounts have no
+	// spaces, so there one space before a number is enough.
+	end := strings.IndexAny(line, "\t")
+	if i := strings.Index(line, "  "); i >= 0 && (end < 0 || i < end) {
+		end = i
+	}
+	if i := strings.Index(line, " "); end < 0 && i >= 0 && strings.ContainsAny(line[i:], "0123456789") {
+		end = i
+	}
+	if end < 0 {
+		p.Account, p.Elided = line, true
+		return p, nil
+	}
+	p.Account = line[:end]
+	rest := strings.TrimSpace(line[end:])
+
+	if i := strings.Index(rest, "="); i >= 0 {
+		rest = strings.TrimSpace(rest[:i]) // a ledger balance assertion
+	}
+	if strings.Contains(rest, "{") {
+		return p, errors.New("lot costs ({...}) are not supported")
+	}
+	if rest == "" {
+		p.Elided = true
+		return p, nil
+	}
+
+	amount, price, total := rest, "", false
+	if i := strings.Index(rest, "@@"); i >= 0 {
+		amount, price, total = rest[:i], rest[i+2:], true
+	} else if i := strings.Index(rest, "@"); i >= 0 {
+		amount, price = rest[:i], rest[i+1:]
+	}
+
+	var err error
+	if p.Amount, p.Currency, err = parseJournalAmount(amount); err != nil {
+		return p, err
+	}
+	if price == "" {
+		return p, nil
+	}
+
+	// A unit price may have more decimals than an amount
+	number, currency, err := splitJournalAmount(price)
+	if err != nil {
+		return p, err
+	}
+	unit, ok := new(big.Rat).SetString(number)
+	if !ok || unit.Sign() < 0 {
+		return p, fmt.Errorf("invalid price %q", strings.TrimSpace(price))
+	}
+	if p.Amount == 0 {
+		return p, errors.New("a posting with a price needs an amount")
+	}
+	if total {
+		units := int64(p.Amount)
+		if units < 0 {
+			units = -units
+		}
+		unit.Mul(unit, big.NewRat(int64(MoneyUnit), units))
+	}
+	cost, err := p.Amount.Convert(unit)
+	if err != nil {
+		return p, fmt.Errorf("price %q out of range", strings.TrimSpace(price))
+	}
+	p.Cost, p.CostCurrency = cost, currency
+	return p, nil
+}
+
+// parseJournalComment reads tags and key: value metadata from a ledger or
+// hledger comment, e.g. ":food:travel:" or "id:3b33, time:09:00, food:"
+func parseJournalComment(e *journalEntry, comment string) {
+	comment = strings.TrimSpace(comment)
+	if journalTagsPattern.MatchString(comment) {
+		e.Tags = append(e.Tags, strings.Split(strings.Trim(comment, ":"), ":")...)
+		return
+	}
+	for _, part := range strings.Split(comment, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch name = strings.ToLower(name); {
+		case value != "":
+			e.Meta[name] = value
+		case name != "id" && name != "time" && name != "fitid":
+			e.Tags = append(e.Tags, name)
+		}
+	}
+}
+
+// parseJournalHeader reads what follows the date of a transaction: a
+// beancount flag, payee, narration and #tags, or a ledger status, (code),
+// description and comment
+func parseJournalHeader(e *journalEntry, rest string) {
+	rest = strings.TrimSpace(rest)
+	if flag, after, ok := strings.Cut(rest, " "); ok && (flag == "*" || flag == "!" || flag == "txn") {
+		rest = strings.TrimSpace(after)
+	} else if rest == "*" || rest == "!" || rest == "txn" {
+		rest = ""
+	}
+
+	if strings.HasPrefix(rest, `"`) {
+		var texts []string
+		for strings.HasPrefix(rest, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			texts = append(texts, b.String())
+			rest = strings.TrimSpace(rest[min(i+1, len(rest)):])
+		}
+		var parts []string
+		for _, text := range texts {
+			if text = strings.TrimSpace(text); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		e.Description = strings.Join(parts, " - ")
+
+		rest, comment, _ := strings.Cut(rest, ";")
+		for _, word := range strings.Fields(rest) {
+			if strings.HasPrefix(word, "#") {
+				e.Tags = append(e.Tags, word[1:])
+			}
+		}
+		parseJournalComment(e, comment)
+		return
+	}
+
+	if strings.HasPrefix(rest, "(") {
+		if i := strings.Index(rest, ")"); i >= 0 {
+			rest = rest[i+1:]
+		}
+	}
+	description, comment, _ := strings.Cut(rest, ";")
+	e.Description = strings.TrimSpace(description)
+	parseJournalComment(e, comment)
+}
+
+// parseJournal reads the transactions and account declarations of a
+// beancount or ledger journal. Directives other than open, close and
+// account are skipped.
+func parseJournal(data []byte) ([]journalEntry, map[string]journalDeclaration, error) {
+	var entries []journalEntry
+	declared := make(map[string]journalDeclaration)
+	var current *journalEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" {
+			current = nil
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			// Postings, metadata and comments of the open transaction;
+			// lines under skipped directives are ignored
+			if current == nil {
+				continue
+			}
+			text := strings.TrimSpace(line)
+			if strings.HasPrefix(text, ";") {
+				parseJournalComment(current, strings.TrimLeft(text, ";"))
+				continue
+			}
+			if m := journalMetaPattern.FindStringSubmatch(text); m != nil {
+				current.Meta[strings.ToLower(m[1])] = strings.Trim(strings.TrimSpace(m[2]), `"`)
+				continue
+			}
+			text, _, _ = strings.Cut(text, ";")
+			p, err := parsePosting(text)
+			if err != nil && current.Error == "" {
+				current.Error = fmt.Sprintf("line %d: %v", n, err)
+			}
+			current.Postings = append(current.Postings, p)
+			continue
+		}
+
+		current = nil
+		fields := strings.Fields(line)
+		switch {
+		case strings.ContainsRune(";#%|*", rune(line[0])):
+			continue
+		case fields[0] == "account" && len(fields) > 1:
+			name, _, _ := strings.Cut(strings.TrimSpace(line[len("account"):]), ";")
+			if name = strings.TrimSpace(name); name != "" {
+				declared[name] = declared[name]
+			}
+			continue
+		case line[0] < '0' || line[0] > '9':
+			continue
+		}
+
+		date, err := parseJournalDate(fields[0])
+		if len(fields) > 2 && journalDirectives[fields[1]] {
+			if err != nil {
+				continue
+			}
+			// 2024-01-01 open Assets:Bank INR, 2024-12-31 close Assets:Bank
+			decl := declared[fields[2]]
+			switch fields[1] {
+			case "open":
+				if len(fields) > 3 {
+					decl.Currency, _ = journalCurrency(strings.Split(fields[3], ",")[0])
+				}
+				declared[fields[2]] = decl
+			case "close":
+				decl.ClosedDate = date
+				declared[fields[2]] = decl
+			}
+			continue
+		}
+
+		entries = append(entries, journalEntry{Line: n, Date: date, Meta: make(map[string]string)})
+		current = &entries[len(entries)-1]
+		if err != nil {
+			current.Error = err.Error()
+			continue
+		}
+		parseJournalHeader(current, strings.TrimSpace(line[len(fields[0]):]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return entries, declared, nil
+}
+
+// journalTransactions turns a journal entry into transactions between
+// journal account names, and reports the currency each account was used in
+func journalTransactions(e journalEntry, base string) ([]Transaction, map[string]string, error) {
+	postings := append([]journalPosting(nil), e.Postings...)
+	for i := range postings {
+		if postings[i].Currency == "" {
+			postings[i].Currency = base
+		}
+		if postings[i].Cost != 0 && postings[i].CostCurrency == "" {
+			postings[i].CostCurrency = base
+		}
+	}
+
+	// The posting without an amount takes what balances the others
+	elided := -1
+	sums := make(map[string]Money)
+	for i, p := range postings {
+		switch {
+		case p.Elided && elided >= 0:
+			return nil, nil, errors.New("only one posting may leave out its amount")
+		case p.Elided:
+			elided = i
+		case p.Cost != 0:
+			sums[p.CostCurrency] += p.Cost
+		default:
+			sums[p.Currency] += p.Amount
+		}
+	}
+	if elided >= 0 {
+		if len(sums) != 1 {
+			return nil, nil, errors.New("cannot work out the missing amount across currencies")
+		}
+		for currency, sum := range sums {
+			postings[elided].Amount, postings[elided].Currency = -sum, currency
+		}
+	}
+
+	var sources, destinations []journalPosting
+	currencies := make(map[string]string)
+	for _, p := range postings {
+		if _, ok := currencies[p.Account]; !ok {
+			currencies[p.Account] = p.Currency
+		}
+		if p.Amount < 0 {
+			sources = append(sources, p)
+		} else if p.Amount > 0 {
+			destinations = append(destinations, p)
+		}
+	}
+	if len(sources) == 0 || len(destinations) == 0 {
+		return nil, nil, errors.New("a transaction needs postings out of and into an account")
+	}
+
+	t := Transaction{TranDate: e.Date, Description: e.Description}
+	switch {
+	case len(sources) == 1:
+		s := sources[0]
+		t.From, t.Amount = s.Account, -s.Amount
+		var legs []Split
+		var total Money
+		for _, d := range destinations {
+			leg := Split{To: d.Account}
+			switch {
+			case d.Currency == s.Currency:
+				leg.Amount = d.Amount
+			case d.Cost != 0 && d.CostCurrency == s.Currency:
+				leg.Amount, leg.ToAmount = d.Cost, d.Amount
+			case len(destinations) == 1:
+				leg.Amount, leg.ToAmount = t.Amount, d.Amount
+			default:
+				return nil, nil, fmt.Errorf("posting to %s in %s needs a price in %s", d.Account, d.Currency, s.Currency)
+			}
+			total += leg.Amount
+			legs = append(legs, leg)
+		}
+		if total != t.Amount {
+			return nil, nil, fmt.Errorf("transaction does not balance (off by %s %s)", total-t.Amount, s.Currency)
+		}
+		if len(legs) == 1 {
+			t.To, t.ToAmount = legs[0].To, legs[0].ToAmount
+		} else {
+			t.Splits = legs
+		}
+		return []Transaction{t}, currencies, nil
+
+	case len(destinations) == 1:
+		d := destinations[0]
+		var trans []Transaction
+		var total Money
+		for _, s := range sources {
+			if s.Currency != d.Currency {
+				return nil, nil, errors.New("postings paying into one account must share its currency")
+			}
+			part := t
+			part.From, part.To, part.Amount = s.Account, d.Account, -s.Amount
+			total += part.Amount
+			trans = append(trans, part)
+		}
+		if total != d.Amount {
+			return nil, nil, fmt.Errorf("transaction does not balance (off by %s %s)", d.Amount-total, d.Currency)
+		}
+		return trans, currencies, nil
+
+	default:
+		return nil, nil, errors.New("postings out of several accounts into several accounts are not supported")
+	}
+}
+
+// journalAccounts maps every journal account name to an account name,
+// creating the accounts, and their parents, that do not exist yet. An
+// existing account of the same name and type is used as is; a name taken
+// by another account gets the whole path, e.g. Food-Dining. Names are kept
+// unescaped, since validation escapes them. failed explains the accounts
+// that cannot be created.
+func journalAccounts(used []string, declared map[string]journalDeclaration, existing []Account, base string) (names map[string]string, created []Account, failed map[string]error) {
+	names = make(map[string]string)
+	failed = make(map[string]error)
+	taken := make(map[string]bool)
+	for _, acc := range existing {
+		taken[acc.Name] = true
+	}
+
+	var resolve func(full string) (string, error)
+	resolve = func(full string) (string, error) {
+		if name, ok := names[full]; ok {
+			return name, nil
+		}
+		if err, ok := failed[full]; ok {
+			return "", err
+		}
+
+		name, err := func() (string, error) {
+			parts := strings.Split(full, ":")
+			typ, ok := journalRootTypes[strings.ToLower(parts[0])]
+			if !ok {
+				return "", fmt.Errorf("account %s must start with Assets, Liabilities, Equity, Income or Expenses", full)
+			}
+
+			parent := ""
+			if len(parts) > 2 {
+				var err error
+				if parent, err = resolve(strings.Join(parts[:len(parts)-1], ":")); err != nil {
+					return "", err
+				}
+			}
+
+			name := parts[len(parts)-1]
+			if acc := findAccount(existing, sanitizeInput(name)); acc.Name != "" && acc.Type == typ {
+				return name, nil
+			}
+			if taken[sanitizeInput(name)] && len(parts) > 2 {
+				name = strings.Join(parts[1:], "-")
+			}
+			for n, candidate := 2, name; taken[sanitizeInput(name)]; n++ {
+				name = fmt.Sprintf("%s-%d", candidate, n)
+			}
+
+			acc := Account{Name: name, Type: typ, IINW: "No", Parent: parent}
+			if typ == "ASSET" || typ == "LIABILITIES" {
+				acc.IINW = "Yes"
+			}
+			decl := declared[full]
+			acc.Currency, acc.ClosedDate = decl.Currency, decl.ClosedDate
+			if acc.Currency == base {
+				acc.Currency = ""
+			}
+			if err := validateAccount(&acc); err != nil {
+				return "", fmt.Errorf("account %s: %v", full, err)
+			}
+			taken[acc.Name] = true
+			created = append(created, acc)
+			return name, nil
+		}()
+		if err != nil {
+			failed[full] = err
+			return "", err
+		}
+		names[full] = name
+		return name, nil
+	}
+
+	for _, full := range used {
+		resolve(full)
+	}
+	return names, created, failed
+}
+
+// importJournal parses and validates a journal and, when commit is set,
+// adds every transaction without an error and the accounts the journal
+// uses in one batch. It returns the transactions and the new accounts.
+func importJournal(data []byte, commit bool) ([]ImportRow, []Account, int, error) {
+	entries, declared, err := parseJournal(data)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	// A journal may be the first thing imported into a new data directory
+	accounts, err := readAccounts()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, 0, err
+	}
+	existing, err := readAllTransactions()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	base := getSetting("baseCurrency")
+
+	// Declared accounts first, then in order of use; a new account takes
+	// the currency it is first used in unless declared with one
+	var used []string
+	firstCurrency := make(map[string]string)
+	for full := range declared {
+		used = append(used, full)
+	}
+	sort.Strings(used)
+	type built struct {
+		trans      []Transaction
+		currencies map[string]string
+		err        error
+	}
+	builds := make([]built, len(entries))
+	for i, e := range entries {
+		if e.Error != "" {
+			continue
+		}
+		b := &builds[i]
+		b.trans, b.currencies, b.err = journalTransactions(e, base)
+		for _, p := range e.Postings {
+			if _, ok := firstCurrency[p.Account]; !ok && b.err == nil {
+				firstCurrency[p.Account] = b.currencies[p.Account]
+				used = append(used, p.Account)
+			}
+		}
+	}
+	for full, currency := range firstCurrency {
+		if decl := declared[full]; decl.Currency == "" {
+			decl.Currency = currency
+			declared[full] = decl
+		}
+	}
+	names, created, failed := journalAccounts(used, declared, accounts, base)
+	all := append(append([]Account(nil), accounts...), created...)
+
+	seenIDs := make(map[string]bool)
+	seenFITIDs := make(map[string]bool)
+	for _, t := range existing {
+		seenIDs[t.ID] = true
+		if t.FITID != "" {
+			seenFITIDs[t.FITID] = true
+		}
+	}
+
+	var rows []ImportRow
+	for i, e := range entries {
+		if e.Error != "" {
+			rows = append(rows, ImportRow{Line: e.Line, Transaction: Transaction{TranDate: e.Date, Description: e.Description}, Error: e.Error})
+			continue
+		}
+		if builds[i].err != nil {
+			rows = append(rows, ImportRow{Line: e.Line, Transaction: Transaction{TranDate: e.Date, Description: e.Description}, Error: builds[i].err.Error()})
+			continue
+		}
+
+		for _, t := range builds[i].trans {
+			row := ImportRow{Line: e.Line}
+			row.Error = journalRowError(&t, e, len(builds[i].trans), names, failed, builds[i].currencies, all, base)
+			if row.Error == "" {
+				switch {
+				case t.ID != "" && seenIDs[t.ID]:
+					row.Error = fmt.Sprintf("already imported (ID %s)", t.ID)
+				case t.FITID != "" && seenFITIDs[t.FITID]:
+					row.Error = fmt.Sprintf("already imported (FITID %s)", t.FITID)
+				default:
+					if err := validateTransactionWith(&t, all); err != nil {
+						row.Error = err.Error()
+					}
+				}
+			}
+			if row.Error == "" {
+				seenIDs[t.ID] = true
+				if t.FITID != "" {
+					seenFITIDs[t.FITID] = true
+				}
+			}
+			row.Transaction = t
+			rows = append(rows, row)
+		}
+	}
+	markDuplicates(rows, existing)
+	if !commit {
+		return rows, created, 0, nil
+	}
+
+	var trans []Transaction
+	for _, row := range rows {
+		if row.Error == "" {
+			trans = append(trans, row.Transaction)
+		}
+	}
+	if len(trans) == 0 && len(created) == 0 {
+		return rows, created, 0, nil
+	}
+	byYear, err := transactionYearBatch(trans)
+	if err != nil {
+		return rows, created, 0, err
+	}
+	if err := store.Commit(Batch{Accounts: all, Transactions: byYear}); err != nil {
+		return rows, created, 0, err
+	}
+	if err := recalculateAllData(); err != nil {
+		log.Printf("Error recalculating data: %v", err)
+	}
+	return rows, created, len(trans), nil
+}
+
+// journalRowError fills in the accounts, time, tags and IDs of a
+// transaction built from e, and explains why it cannot be imported
+func journalRowError(t *Transaction, e journalEntry, parts int, names map[string]string, failed map[string]error, currencies map[string]string, accounts []Account, base string) string {
+	t.TranTime = IMPORT_TIME
+	if isValidTime(e.Meta["time"]) {
+		t.TranTime = e.Meta["time"]
+	}
+	t.Description = firstN(strings.TrimSpace(t.Description), 100)
+	t.Tags = e.Tags
+	// IDs from an export are kept, so importing it again is safe; a
+	// transaction split into parts cannot keep one
+	if parts == 1 {
+		if id := e.Meta["id"]; transactionIDPattern.MatchString(id) {
+			t.ID = id
+		}
+		t.FITID = firstN(e.Meta["fitid"], 255)
+	}
+
+	rename := func(full string) (string, error) {
+		name, ok := names[full]
+		if !ok {
+			return "", failed[full]
+		}
+		have := accountCurrency(findAccount(accounts, sanitizeInput(name)), base)
+		if have != currencies[full] {
+			return "", fmt.Errorf("account %s holds %s, not %s", full, have, currencies[full])
+		}
+		return name, nil
+	}
+
+	var err error
+	legs := t.Legs()
+	if t.From, err = rename(t.From); err != nil {
+		return err.Error()
+	}
+	for i := range legs {
+		if legs[i].To, err = rename(legs[i].To); err != nil {
+			return err.Error()
+		}
+	}
+	if len(t.Splits) > 0 {
+		t.Splits = legs
+	} else {
+		t.To = legs[0].To
+	}
+	return ""
+}
+
+// handleImportJournal previews (/api/import/journal/preview) or commits
+// (/api/import/journal/commit) a journal sent as {"data"}
+func handleImportJournal(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Data string `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request data", http.StatusBadRequest)
+		return
+	}
+	commit := strings.HasSuffix(r.URL.Path, "/commit")
+
+	rows, created, imported, err := importJournal([]byte(req.Data), commit)
+	if err != nil {
+		if commit && rows != nil {
+			respondError(w, "Failed to import journal", http.StatusInternalServerError)
+		} else {
+			respondError(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if rows == nil {
+		rows = []ImportRow{}
+	}
+	if created == nil {
+		created = []Account{}
+	}
+
+	if commit {
+		logSecurityEvent("IMPORT_JOURNAL", getClientIP(r), fmt.Sprintf("Imported %d transactions and %d new accounts from a journal", imported, len(created)))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"rows":     rows,
+		"accounts": created,
+		"imported": imported,
+	})
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useTestStore points the store at an empty data directory for one test
+func useTestStore(t *testing.T) {
+	t.Helper()
+	saved := store
+	store = newCSVStore(t.TempDir())
+	t.Cleanup(func() { store = saved })
+}
+
+func testTransactionID(n int) string {
+	return fmt.Sprintf("%0*x", 2*TRANSACTION_ID_LENGTH, n)
+}
+
+// testJournal is a ledger as it is stored: descriptions escaped, tags
+// sorted, amounts in each account's currency
+func testJournal() Journal {
+	return Journal{
+		Base: "INR",
+		Accounts: []Account{
+			{Name: "HDFC", Type: "ASSET", IINW: "Yes"},
+			{Name: "Wise", Type: "ASSET", IINW: "Yes", Currency: "USD"},
+			{Name: "Card", Type: "LIABILITIES", IINW: "Yes"},
+			{Name: "Salary", Type: "INCOME", IINW: "No"},
+			{Name: "Food", Type: "EXPENSE", IINW: "No"},
+			{Name: "Dining", Type: "EXPENSE", IINW: "No", Parent: "Food"},
+			{Name: "Travel", Type: "EXPENSE", IINW: "No"},
+		},
+		Transactions: []Transaction{
+			{
+				ID: testTransactionID(1), TranDate: "01-10-2026", TranTime: "09:00",
+				From: "Salary", To: "HDFC", Description: "October salary", Amount: 8500000,
+				FITID: "SAL-2026-10", Tags: []string{"payroll"},
+			},
+			{
+				// Several postings into the accounts paid
+				ID: testTransactionID(2), TranDate: "02-10-2026", TranTime: "13:05",
+				From: "HDFC", Description: sanitizeInput("Tea & snacks"), Amount: 50000,
+				Splits: []Split{{To: "Food", Amount: 30000}, {To: "Dining", Amount: 20000}},
+			},
+			{
+				ID: testTransactionID(3), TranDate: "05-10-2026", TranTime: "10:00",
+				From: "HDFC", To: "Wise", Description: "Top up", Amount: 832500, ToAmount: 10000,
+			},
+			{
+				// Paid in dollars into a rupee account
+				ID: testTransactionID(4), TranDate: "06-10-2026", TranTime: "08:15",
+				From: "Wise", To: "Travel", Description: "Train tickets", Amount: 5000, ToAmount: 416250,
+			},
+			{
+				ID: testTransactionID(5), TranDate: "06-10-2026", TranTime: "21:40",
+				From: "Card", To: "Dining", Description: sanitizeInput(`Cafe "Blue"`), Amount: 125050,
+				Tags: []string{"cafe", "weekend"},
+			},
+		},
+	}
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	for _, format := range []string{EXPORT_LEDGER, EXPORT_HLEDGER, EXPORT_BEANCOUNT} {
+		useTestStore(t)
+		j := testJournal()
+
+		var buf bytes.Buffer
+		if err := writeJournal(&buf, format, j); err != nil {
+			t.Fatalf("%s: writeJournal: %v", format, err)
+		}
+		exported := buf.String()
+
+		rows, created, imported, err := importJournal(buf.Bytes(), true)
+		if err != nil {
+			t.Fatalf("%s: importJournal: %v", format, err)
+		}
+		for _, row := range rows {
+			if row.Error != "" {
+				t.Errorf("%s: line %d: %s", format, row.Line, row.Error)
+			}
+		}
+		if imported != len(j.Transactions) || len(created) != len(j.Accounts) {
+			t.Errorf("%s: imported %d transactions and %d accounts, want %d and %d\n%s",
+				format, imported, len(created), len(j.Transactions), len(j.Accounts), exported)
+		}
+
+		accounts, err := readAccounts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range j.Accounts {
+			got := findAccount(accounts, want.Name)
+			if got.Type != want.Type || got.Parent != want.Parent || got.Currency != want.Currency || got.IINW != want.IINW {
+				t.Errorf("%s: account %s = %+v, want %+v", format, want.Name, got, want)
+			}
+		}
+
+		transactions, err := readAllTransactions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		byID := make(map[string]Transaction)
+		for _, tran := range transactions {
+			byID[tran.ID] = tran
+		}
+		for _, want := range j.Transactions {
+			if got, ok := byID[want.ID]; !ok {
+				t.Errorf("%s: transaction %s (%s) was not imported", format, want.ID, want.Description)
+			} else if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: transaction %s\n got %+v\nwant %+v", format, want.ID, got, want)
+			}
+		}
+
+		// The IDs in the export stop a second import
+		rows, created, imported, err = importJournal([]byte(exported), true)
+		if err != nil {
+			t.Fatalf("%s: second importJournal: %v", format, err)
+		}
+		if imported != 0 || len(created) != 0 {
+			t.Errorf("%s: second import added %d transactions and %d accounts", format, imported, len(created))
+		}
+		for _, row := range rows {
+			if !strings.HasPrefix(row.Error, "already imported") {
+				t.Errorf("%s: second import of line %d: error %q", format, row.Line, row.Error)
+			}
+		}
+	}
+}
+
+// A hand-written journal with what the export never writes: elided
+// amounts, currency symbols, unit prices, several sources and comments
+const testLedger = `; Household ledger
+* An org-mode heading
+
+2026-01-01 open Assets:Wise USD
+2026-01-01 open Assets:Old
+2026-09-30 close Assets:Old
+2026-01-01 price USD 83.25 INR
+
+2026-10-01 * "Grocer" "Weekly shop" #groceries
+  time: "18:45"
+  Expenses:Food        1,200.50 INR
+  Expenses:Household     299.50 INR  ; soap
+  Assets:Bank
+
+2026/10/02 (1042) Coffee  ; :cafe:
+    ; paid abroad
+    Expenses:Food:Dining    $4.50
+    Liabilities:Card
+
+2026-10-03 Exchange
+    Assets:Wise        100 USD @ 83.25 INR
+    Assets:Bank       -8325 INR
+
+2026-10-04 Salary  ; id:zz, time:09:00, payroll:
+    Assets:Bank        85000
+    Assets:Savings     15000
+    Income:Salary
+
+2026-10-05 Rent
+    Expenses:Rent   20,000
+    Assets:Bank    -15,000
+    Assets:Savings  -5,000
+
+2026-10-06 Two elided
+    Expenses:Food
+    Assets:Bank
+
+2026-10-07 Unbalanced
+    Expenses:Food  10
+    Assets:Bank   -9
+
+2026-10-08 Buy shares
+    Assets:Broker   10 NIFTY @@ 5000 INR
+    Assets:Bank    -5000 INR
+
+2026-10-09 Virtual
+    [Budget:Food]  10
+    Assets:Bank
+
+2026-10-10 Into a closed account
+    Assets:Old     10
+    Assets:Bank
+`
+
+func TestImportJournal(t *testing.T) {
+	useTestStore(t)
+
+	rows, created, _, err := importJournal([]byte(testLedger), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		line int
+		tran Transaction // the fields checked: dates, accounts, amounts, tags
+		err  string
+	}{
+		{line: 9, tran: Transaction{
+			TranDate: "01-10-2026", TranTime: "18:45", From: "Bank", Description: "Grocer - Weekly shop", Amount: 150000,
+			Splits: []Split{{To: "Food", Amount: 120050}, {To: "Household", Amount: 29950}}, Tags: []string{"groceries"},
+		}},
+		{line: 15, tran: Transaction{
+			TranDate: "02-10-2026", TranTime: "00:00", From: "Card", To: "Dining", Description: "Coffee", Amount: 450,
+			Tags: []string{"cafe"},
+		}},
+		{line: 20, tran: Transaction{
+			TranDate: "03-10-2026", TranTime: "00:00", From: "Bank", To: "Wise", Description: "Exchange", Amount: 832500, ToAmount: 10000,
+		}},
+		{line: 24, tran: Transaction{
+			TranDate: "04-10-2026", TranTime: "09:00", From: "Salary", Description: "Salary", Amount: 10000000,
+			Splits: []Split{{To: "Bank", Amount: 8500000}, {To: "Savings", Amount: 1500000}}, Tags: []string{"payroll"},
+		}},
+		// Two postings paying into one are a transaction each
+		{line: 29, tran: Transaction{TranDate: "05-10-2026", TranTime: "00:00", From: "Bank", To: "Rent", Description: "Rent", Amount: 1500000}},
+		{line: 29, tran: Transaction{TranDate: "05-10-2026", TranTime: "00:00", From: "Savings", To: "Rent", Description: "Rent", Amount: 500000}},
+		{line: 34, err: "only one posting may leave out its amount"},
+		{line: 38, err: "transaction does not balance (off by 1.00 INR)"},
+		{line: 42, err: "line 43: commodity NIFTY is not a currency (securities are not imported)"},
+		{line: 46, err: "line 47: virtual postings are not supported"},
+		{line: 50, err: "account Old was closed on 30-09-2026"},
+	}
+
+	if len(rows) != len(want) {
+		for _, row := range rows {
+			t.Logf("line %d: %+v %s", row.Line, row.Transaction, row.Error)
+		}
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i, w := range want {
+		row := rows[i]
+		if row.Line != w.line || row.Error != w.err {
+			t.Errorf("row %d: line %d, error %q; want line %d, error %q", i, row.Line, row.Error, w.line, w.err)
+			continue
+		}
+		if w.err != "" {
+			continue
+		}
+		got := row.Transaction
+		got.ID, got.FITID = "", ""
+		if !reflect.DeepEqual(got, w.tran) {
+			t.Errorf("row %d (line %d)\n got %+v\nwant %+v", i, w.line, got, w.tran)
+		}
+	}
+
+	// The ID in a comment is kept only when it looks like one
+	if rows[3].Transaction.ID != "" {
+		t.Errorf("kept ID %q", rows[3].Transaction.ID)
+	}
+
+	accounts := make(map[string]Account)
+	for _, acc := range created {
+		accounts[acc.Name] = acc
+	}
+	wantAccounts := []Account{
+		{Name: "Bank", Type: "ASSET", IINW: "Yes"},
+		{Name: "Wise", Type: "ASSET", IINW: "Yes", Currency: "USD"},
+		{Name: "Old", Type: "ASSET", IINW: "Yes", ClosedDate: "30-09-2026"},
+		{Name: "Savings", Type: "ASSET", IINW: "Yes"},
+		{Name: "Card", Type: "LIABILITIES", IINW: "Yes", Currency: "USD"},
+		{Name: "Salary", Type: "INCOME", IINW: "No"},
+		{Name: "Food", Type: "EXPENSE", IINW: "No"},
+		{Name: "Dining", Type: "EXPENSE", IINW: "No", Parent: "Food", Currency: "USD"},
+		{Name: "Household", Type: "EXPENSE", IINW: "No"},
+		{Name: "Rent", Type: "EXPENSE", IINW: "No"},
+	}
+	for _, w := range wantAccounts {
+		if got := accounts[w.Name]; !reflect.DeepEqual(got, w) {
+			t.Errorf("account %s = %+v, want %+v", w.Name, got, w)
+		}
+	}
+	if len(created) != len(wantAccounts) {
+		t.Errorf("created %d accounts, want %d: %+v", len(created), len(wantAccounts), created)
+	}
+}
+
+func TestParsePosting(t *testing.T) {
+	tests := []struct {
+		line string
+		want journalPosting
+		err  string
+	}{
+		{line: "  Expenses:Food  12.50", want: journalPosting{Account: "Expenses:Food", Amount: 1250}},
+		{line: "Expenses:Eating Out  12.50 INR", want: journalPosting{Account: "Expenses:Eating Out", Amount: 1250, Currency: "INR"}},
+		{line: "Expenses:Food 12.50 INR", want: journalPosting{Account: "Expenses:Food", Amount: 1250, Currency: "INR"}},
+		{line: "Expenses:Food\t-₹1,250.50", want: journalPosting{Account: "Expenses:Food", Amount: -125050, Currency: "INR"}},
+		{line: "Expenses:Food  $-3", want: journalPosting{Account: "Expenses:Food", Amount: -300, Currency: "USD"}},
+		{line: "* Assets:Bank", want: journalPosting{Account: "Assets:Bank", Elided: true}},
+		{line: "Assets:Bank  = 500 INR", want: journalPosting{Account: "Assets:Bank", Elided: true}},
+		{line: "Assets:Bank  -10 INR = 490 INR", want: journalPosting{Account: "Assets:Bank", Amount: -1000, Currency: "INR"}},
+		{
+			line: "Assets:Wise  100 USD @@ 8,325 INR",
+			want: journalPosting{Account: "Assets:Wise", Amount: 10000, Currency: "USD", Cost: 832500, CostCurrency: "INR"},
+		},
+		{
+			// A unit price may have more decimals than an amount
+			line: "Assets:Wise  -3 USD @ 83.2567 INR",
+			want: journalPosting{Account: "Assets:Wise", Amount: -300, Currency: "USD", Cost: -24977, CostCurrency: "INR"},
+		},
+		{line: "[Budget:Food]  10", err: "virtual postings are not supported"},
+		{line: "(Budget:Food)  10", err: "virtual postings are not supported"},
+		{line: "Assets:Broker  10 NIFTY {5000 INR}", err: "lot costs ({...}) are not supported"},
+		{line: "Assets:Broker  10 NIFTY", err: "commodity NIFTY is not a currency (securities are not imported)"},
+		{line: "Assets:Bank  ten", err: `invalid amount "ten"`},
+		{line: "Assets:Bank  -$-5", err: `invalid amount "-$-5"`},
+		{line: "Assets:Wise  0 USD @@ 5 INR", err: "a posting with a price needs an amount"},
+		{line: "Assets:Wise  1 USD @ -5 INR", err: `invalid price "-5 INR"`},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePosting(tt.line)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parsePosting(%q) error = %v, want %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parsePosting(%q) = %+v, %v, want %+v", tt.line, got, err, tt.want)
+		}
+	}
+}
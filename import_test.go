@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFitDescription(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "short", in: "  UPI/SWIGGY  ", want: "UPI/SWIGGY"},
+		{name: "exactly the limit", in: strings.Repeat("a", 100), want: strings.Repeat("a", 100)},
+		{name: "too long", in: strings.Repeat("a", 150), want: strings.Repeat("a", 100)},
+		// 3-byte characters: 33 fit in 99 bytes, a 34th would split
+		{name: "multi-byte", in: strings.Repeat("₹", 50), want: strings.Repeat("₹", 33)},
+		// & grows to &amp; when escaped, so fewer characters fit
+		{name: "escaped", in: strings.Repeat("&", 30), want: strings.Repeat("&", 20)},
+		{name: "escaped tail", in: strings.Repeat("a", 98) + "<b>", want: strings.Repeat("a", 98)},
+		// The CSV injection guard adds a leading quote
+		{name: "formula", in: "=" + strings.Repeat("a", 120), want: "=" + strings.Repeat("a", 98)},
+	}
+
+	for _, tt := range tests {
+		got := fitDescription(tt.in)
+		if got != tt.want {
+			t.Errorf("%s: fitDescription = %q, want %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: fitDescription cut a character: %q", tt.name, got)
+		}
+		if n := len(sanitizeInput(got)); n > MAX_DESCRIPTION {
+			t.Errorf("%s: escaped description is %d bytes", tt.name, n)
+		}
+	}
+}
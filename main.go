@@ -5,7 +5,6 @@ import (
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
-	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -32,8 +31,12 @@ const (
 	LOGIN_LOCKOUT_MINUTES = 15
 	MAX_REQUEST_SIZE      = 10 * 1024 * 1024 // 10MB
 	CSRF_TOKEN_LENGTH     = 32
+	TRANSACTION_ID_LENGTH = 8
+	MAX_DESCRIPTION       = 100 // bytes, after HTML escaping
 )
 
+var transactionHeader = []string{"ID", "TranDate", "TranTime", "From", "To", "Description", "Amount", "ToAmount", "Security", "Units", "Price", "FITID", "Tags"}
+
 var (
 	PASSWORD_HASH     string
 	READONLY_PASSWORD string
@@ -41,7 +44,6 @@ var (
 	sessionMutex      sync.RWMutex
 	loginAttempts     = make(map[string]*LoginAttempt)
 	loginAttemptsMux  sync.RWMutex
-	fileMutex         sync.Mutex
 	csrfTokens        = make(map[string]time.Time)
 	csrfMutex         sync.RWMutex
 	readOnlyMode      = false
@@ -60,31 +62,102 @@ type LoginAttempt struct {
 }
 
 type Transaction struct {
-	TranDate    string  `json:"tranDate"`
-	TranTime    string  `json:"tranTime"`
-	From        string  `json:"from"`
-	To          string  `json:"to"`
-	Description string  `json:"description"`
-	Amount      float64 `json:"amount"`
+	ID          string   `json:"id"`
+	TranDate    string   `json:"tranDate"`
+	TranTime    string   `json:"tranTime"`
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	Description string   `json:"description"`
+	Amount      Money    `json:"amount"`
+	ToAmount    Money    `json:"toAmount,omitempty"` // received, when To holds another currency
+	Security    string   `json:"security,omitempty"`
+	Units       Decimal  `json:"units,omitempty"` // positive for a buy, negative for a sell
+	Price       Decimal  `json:"price,omitempty"`
+	FITID       string   `json:"fitid,omitempty"` // bank's ID for imported lines
+	Tags        []string `json:"tags,omitempty"`
+	Splits      []Split  `json:"splits,omitempty"`
+}
+
+// Split is one destination leg of a split transaction. A split transaction
+// has an empty To and its Amount is the sum of the legs.
+type Split struct {
+	To       string `json:"to"`
+	Amount   Money  `json:"amount"`
+	ToAmount Money  `json:"toAmount,omitempty"`
+}
+
+// Received is what the destination account is credited, in its own
+// currency
+func (s Split) Received() Money {
+	if s.ToAmount != 0 {
+		return s.ToAmount
+	}
+	return s.Amount
+}
+
+// Value is the balance at market prices: cost plus the gains on any
+// securities held
+func (a Account) Value() Money {
+	return a.Amount + a.RealizedGain + a.UnrealizedGain
+}
+
+// Closed reports whether the account has been archived
+func (a Account) Closed() bool {
+	return a.ClosedDate != ""
+}
+
+// openAccounts drops archived accounts, for views that list what is in use
+func openAccounts(accounts []Account) []Account {
+	open := make([]Account, 0, len(accounts))
+	for _, a := range accounts {
+		if !a.Closed() {
+			open = append(open, a)
+		}
+	}
+	return open
+}
+
+// Legs returns the destination legs of a transaction; a plain transaction
+// has exactly one
+func (t Transaction) Legs() []Split {
+	if len(t.Splits) > 0 {
+		return t.Splits
+	}
+	return []Split{{To: t.To, Amount: t.Amount, ToAmount: t.ToAmount}}
 }
 
 type Account struct {
-	Name    string  `json:"account"`
-	Type    string  `json:"type"`
-	Amount  float64 `json:"amount"`
-	IINW    string  `json:"iinw"`
-	Budget  float64 `json:"budget"`
-	DueDate string  `json:"dueDate"`
+	Name       string `json:"account"`
+	Type       string `json:"type"`
+	Amount     Money  `json:"amount"`
+	IINW       string `json:"iinw"`
+	Budget     Money  `json:"budget"`
+	DueDate    string `json:"dueDate"`
+	ClosedDate string `json:"closedDate,omitempty"` // set when archived
+	Parent     string `json:"parent,omitempty"`
+	Currency   string `json:"currency,omitempty"` // empty means the base currency
+
+	// Computed from holdings for the dashboard, never stored
+	MarketValue    Money `json:"marketValue,omitempty"`
+	UnrealizedGain Money `json:"unrealizedGain,omitempty"`
+	RealizedGain   Money `json:"realizedGain,omitempty"`
 }
 
 type Record struct {
-	Date        string  `json:"date"`
-	NetWorth    float64 `json:"netWorth"`
-	Assets      float64 `json:"assets"`
-	Liabilities float64 `json:"liabilities"`
-	Expenses    float64 `json:"expenses"`
+	Date        string `json:"date"`
+	NetWorth    Money  `json:"netWorth"`
+	Assets      Money  `json:"assets"`
+	Liabilities Money  `json:"liabilities"`
+	Expenses    Money  `json:"expenses"`
 }
 
+var errTransactionNotFound = errors.New("transaction not found")
+
+var (
+	errAccountNotFound = errors.New("account not found")
+	errCannotReassign  = errors.New("cannot reassign")
+)
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -93,8 +166,18 @@ func main() {
 	// Command line flags
 	passwordFlag := flag.String("p", "", "Set password for login")
 	readOnlyFlag := flag.Bool("r", false, "Run in read-only mode (no edits allowed)")
+	storeFlag := flag.String("store", "csv", "Storage backend: csv or sqlite")
+	dbFlag := flag.String("db", filepath.Join(DATA_DIR, "arthik.db"), "SQLite database file (with -store sqlite)")
 	flag.Parse()
 
+	// One-shot commands such as `arthik migrate` run and exit
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Handle password flag
 	if *passwordFlag != "" {
 		READONLY_PASSWORD = *passwordFlag
@@ -119,7 +202,17 @@ func main() {
 	}
 
 	initDirectories()
+
+	var err error
+	store, err = openStore(*storeFlag, DATA_DIR, *dbFlag)
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", *storeFlag, err)
+	}
+	defer store.Close()
+	log.Printf("Using %s storage", *storeFlag)
+
 	initializeData()
+	runRecurringBatch(log.Default())
 	go startDailyBatch()
 	go cleanupSessions()
 	go cleanupLoginAttempts()
@@ -132,7 +225,32 @@ func main() {
 	mux.HandleFunc("/api/dashboard", requireAuth(handleDashboard))
 	mux.HandleFunc("/api/transactions", requireAuth(handleTransactions))
 	mux.HandleFunc("/api/accounts", requireAuth(handleAccounts))
+	mux.HandleFunc("/api/accounts/tree", requireAuth(handleAccountTree))
+	mux.HandleFunc("/api/recurring", requireAuth(handleRecurring))
+	mux.HandleFunc("/api/recurring/upcoming", requireAuth(handleRecurringUpcoming))
+	mux.HandleFunc("/api/recurring/skip", requireAuth(handleRecurringAction))
+	mux.HandleFunc("/api/recurring/post", requireAuth(handleRecurringAction))
 	mux.HandleFunc("/api/settings", requireAuth(handleSettings))
+	mux.HandleFunc("/api/rates", requireAuth(handleRates))
+	mux.HandleFunc("/api/prices", requireAuth(handlePrices))
+	mux.HandleFunc("/api/import/profiles", requireAuth(handleImportProfiles))
+	mux.HandleFunc("/api/import/preview", requireAuth(handleImport))
+	mux.HandleFunc("/api/import/commit", requireAuth(handleImport))
+	mux.HandleFunc("/api/import/journal/preview", requireAuth(handleImportJournal))
+	mux.HandleFunc("/api/import/journal/commit", requireAuth(handleImportJournal))
+	mux.HandleFunc("/api/suggestions", requireAuth(handleSuggestions))
+	mux.HandleFunc("/api/export", requireAuth(handleExport))
+	mux.HandleFunc("/api/reports/income-statement", requireAuth(handleIncomeStatement))
+	mux.HandleFunc("/api/reports/balance-sheet", requireAuth(handleBalanceSheet))
+	mux.HandleFunc("/api/reports/trends", requireAuth(handleTrends))
+	mux.HandleFunc("/api/budgets", requireAuth(handleBudgets))
+	mux.HandleFunc("/api/envelopes", requireAuth(handleEnvelopes))
+	mux.HandleFunc("/api/envelopes/move", requireAuth(handleEnvelopeMove))
+	mux.HandleFunc("/api/rules", requireAuth(handleRules))
+	mux.HandleFunc("/api/rules/apply", requireAuth(handleApplyRules))
+	mux.HandleFunc("/api/duplicates", requireAuth(handleDuplicates))
+	mux.HandleFunc("/api/duplicates/merge", requireAuth(handleDuplicates))
+	mux.HandleFunc("/api/duplicates/dismiss", requireAuth(handleDuplicates))
 	mux.HandleFunc("/api/readonly-info", handleReadonlyInfo)
 	mux.HandleFunc("/health", handleHealth)
 
@@ -427,6 +545,15 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// month picks the budget shown (MM-YYYY, default this month)
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().Format("01-2006")
+	} else if !isValidMonth(month) {
+		respondError(w, "invalid month format (use MM-YYYY)", http.StatusBadRequest)
+		return
+	}
+
 	accounts, err := readAccounts()
 	if err != nil {
 		log.Printf("Error reading accounts: %v", err)
@@ -434,6 +561,11 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	plan, err := loadBudgetPlan()
+	if err != nil {
+		log.Printf("Error reading budgets: %v", err)
+	}
+
 	records, err := readRecords()
 	if err != nil {
 		log.Printf("Error reading records: %v", err)
@@ -451,33 +583,52 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 	// Sort accounts by usage
 	accounts = sortAccountsByUsage(accounts, transactions)
 
-	netWorth := 0.0
-	assets := 0.0
-	liabilities := 0.0
+	conv, err := loadConverter()
+	if err != nil {
+		log.Printf("Error reading exchange rates: %v", err)
+	}
+	asOf := time.Now().Format("02-01-2006")
+
+	prices, err := readPrices()
+	if err != nil {
+		log.Printf("Error reading prices: %v", err)
+	}
+	holdings := computeHoldings(transactions, prices, asOf)
+	applyHoldings(accounts, holdings)
+
+	var netWorth, assets, liabilities Money
 
 	for _, acc := range accounts {
 		if acc.IINW == "Yes" {
+			amount := conv.ToBase(acc.Amount, accountCurrency(acc, conv.Base), asOf)
 			if acc.Type == "ASSET" {
-				netWorth += acc.Amount
-				assets += acc.Amount
+				netWorth += amount
+				assets += amount
 			} else if acc.Type == "LIABILITIES" {
-				netWorth += acc.Amount  // acc.Amount is already negative
-				liabilities += acc.Amount
+				netWorth += amount  // amount is already negative
+				liabilities += amount
 			}
 		}
 	}
 
-	currentMonth := time.Now().Format("01-2006")
-	budgetData := calculateBudget(transactions, accounts, currentMonth)
+	budgetData := calculateBudget(transactions, accounts, plan, month, conv)
+	applyBudgets(accounts, plan.Entries, month)
 	upcomingBills := getUpcomingBills(accounts)
 
+	// Archived accounts still count towards net worth above but are not
+	// shown as pills or in the portfolio
 	response := map[string]interface{}{
 		"netWorth":      netWorth,
 		"assets":        assets,
 		"liabilities":   liabilities,
 		"records":       records,
-		"accounts":      accounts,
+		"accounts":      openAccounts(accounts),
+		"portfolio":     portfolioAccounts(accounts, conv),
+		"holdings":      holdings,
+		"investments":   summarizeHoldings(holdings, accounts, conv, asOf),
+		"baseCurrency":  conv.Base,
 		"budget":        budgetData,
+		"budgetMonth":   month,
 		"upcomingBills": upcomingBills,
 		"csrfToken":     session.CSRFToken,
 		"readOnly":      readOnlyMode,
@@ -491,19 +642,9 @@ func handleTransactions(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		page := 1
-		if p := r.URL.Query().Get("page"); p != "" {
-			var err error
-			page, err = strconv.Atoi(p)
-			if err != nil || page < 1 {
-				page = 1
-			}
-		}
-
-		// Limit page size
-		pageSize := 30
-		if page > 1000 {
-			respondError(w, "Page number too large", http.StatusBadRequest)
+		filter, err := parseTransactionFilter(r.URL.Query())
+		if err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -513,20 +654,31 @@ func handleTransactions(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		start := (page - 1) * pageSize
-		end := start + pageSize
-		if start > len(transactions) {
-			start = len(transactions)
+		accounts, err := readAccounts()
+		if err != nil {
+			respondError(w, "Failed to load accounts", http.StatusInternalServerError)
+			return
+		}
+
+		conv, err := loadConverter()
+		if err != nil {
+			log.Printf("Error reading exchange rates: %v", err)
 		}
-		if end > len(transactions) {
-			end = len(transactions)
+
+		matched := filterTransactions(transactions, accounts, filter)
+
+		// Matched amounts are added up in the base currency
+		var totalAmount Money
+		for _, t := range matched {
+			totalAmount += conv.ToBase(t.Amount, accountCurrency(findAccount(accounts, t.From), conv.Base), t.TranDate)
 		}
-		result := transactions[start:end]
 
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"transactions": result,
-			"total":        len(transactions),
-			"page":         page,
+			"transactions": pageOf(matched, filter.Page, filter.PageSize),
+			"total":        len(matched),
+			"totalAmount":  totalAmount,
+			"page":         filter.Page,
+			"pageSize":     filter.PageSize,
 		})
 
 	case http.MethodPost:
@@ -541,6 +693,24 @@ func handleTransactions(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// IDs are always assigned by the server
+		id, err := newTransactionID()
+		if err != nil {
+			respondError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		tran.ID = id
+		rule := categorizeEntry(&tran)
+
+		// A likely duplicate is still saved; the caller is warned and the
+		// pair shows up in the duplicate review queue
+		existing, err := readAllTransactions()
+		if err != nil {
+			respondError(w, "Failed to load transactions", http.StatusInternalServerError)
+			return
+		}
+		duplicates := findDuplicates(tran, existing)
+
 		if err := addTransaction(tran); err != nil {
 			respondError(w, "Failed to add transaction", http.StatusInternalServerError)
 			return
@@ -550,17 +720,34 @@ func handleTransactions(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error recalculating data: %v", err)
 		}
 
-		logSecurityEvent("TRANSACTION_ADD", getClientIP(r), fmt.Sprintf("Added transaction: %s", tran.Description))
-		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+		logSecurityEvent("TRANSACTION_ADD", getClientIP(r), fmt.Sprintf("Added transaction %s: %s", tran.ID, tran.Description))
+		response := map[string]interface{}{"success": true, "id": tran.ID}
+		if rule != "" {
+			response["rule"] = rule
+		}
+		if len(duplicates) > 0 {
+			response["duplicates"] = duplicates
+		}
+		json.NewEncoder(w).Encode(response)
 
 	case http.MethodPut:
-		var data map[string]interface{}
-		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		var tran Transaction
+		if err := json.NewDecoder(r.Body).Decode(&tran); err != nil {
 			respondError(w, "Invalid request data", http.StatusBadRequest)
 			return
 		}
 
-		if err := updateTransaction(data); err != nil {
+		tran.ID = sanitizeInput(tran.ID)
+		if tran.ID == "" {
+			respondError(w, "Transaction ID required", http.StatusBadRequest)
+			return
+		}
+
+		if err := updateTransaction(tran); err != nil {
+			if errors.Is(err, errTransactionNotFound) {
+				respondError(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			respondError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -569,7 +756,7 @@ func handleTransactions(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error recalculating data: %v", err)
 		}
 
-		logSecurityEvent("TRANSACTION_UPDATE", getClientIP(r), "Updated transaction")
+		logSecurityEvent("TRANSACTION_UPDATE", getClientIP(r), fmt.Sprintf("Updated transaction: %s", tran.ID))
 		json.NewEncoder(w).Encode(map[string]bool{"success": true})
 
 	case http.MethodDelete:
@@ -579,15 +766,17 @@ func handleTransactions(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		tranDate := sanitizeInput(data["tranDate"])
-		tranTime := sanitizeInput(data["tranTime"])
-
-		if tranDate == "" || tranTime == "" {
-			respondError(w, "Transaction date and time required", http.StatusBadRequest)
+		id := sanitizeInput(data["id"])
+		if id == "" {
+			respondError(w, "Transaction ID required", http.StatusBadRequest)
 			return
 		}
 
-		if err := deleteTransaction(tranDate, tranTime); err != nil {
+		if err := deleteTransaction(id); err != nil {
+			if errors.Is(err, errTransactionNotFound) {
+				respondError(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			respondError(w, "Failed to delete transaction", http.StatusInternalServerError)
 			return
 		}
@@ -596,7 +785,7 @@ func handleTransactions(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error recalculating data: %v", err)
 		}
 
-		logSecurityEvent("TRANSACTION_DELETE", getClientIP(r), fmt.Sprintf("Deleted transaction: %s %s", tranDate, tranTime))
+		logSecurityEvent("TRANSACTION_DELETE", getClientIP(r), fmt.Sprintf("Deleted transaction: %s", id))
 		json.NewEncoder(w).Encode(map[string]bool{"success": true})
 
 	default:
@@ -620,6 +809,12 @@ func handleAccounts(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			accounts = sortAccountsByUsage(accounts, transactions)
 		}
+
+		budgets, err := readBudgets()
+		if err != nil {
+			log.Printf("Error reading budgets: %v", err)
+		}
+		applyBudgets(accounts, budgets, time.Now().Format("01-2006"))
 		
 		json.NewEncoder(w).Encode(accounts)
 
@@ -635,6 +830,16 @@ func handleAccounts(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		existing, err := readAccounts()
+		if err != nil {
+			respondError(w, "Failed to load accounts", http.StatusInternalServerError)
+			return
+		}
+		if err := validateAccountParent(existing, acc, ""); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		if err := addAccount(acc); err != nil {
 			respondError(w, "Failed to add account", http.StatusInternalServerError)
 			return
@@ -645,7 +850,9 @@ func handleAccounts(w http.ResponseWriter, r *http.Request) {
 
 	case http.MethodPut:
 		var data map[string]interface{}
-		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err != nil {
 			respondError(w, "Invalid request data", http.StatusBadRequest)
 			return
 		}
@@ -671,30 +878,84 @@ func handleAccounts(w http.ResponseWriter, r *http.Request) {
 		if typeVal, ok := data["type"].(string); ok {
 			acc.Type = sanitizeInput(typeVal)
 		}
-		if amountVal, ok := data["amount"].(float64); ok {
-			acc.Amount = amountVal
+		if amountVal, ok := data["amount"].(json.Number); ok {
+			amount, err := ParseMoney(amountVal.String())
+			if err != nil {
+				respondError(w, "Invalid amount", http.StatusBadRequest)
+				return
+			}
+			acc.Amount = amount
 		}
 		if iinwVal, ok := data["iinw"].(string); ok {
 			acc.IINW = sanitizeInput(iinwVal)
 		}
-		if budgetVal, ok := data["budget"].(float64); ok {
-			acc.Budget = budgetVal
+		budgetSet := false
+		if budgetVal, ok := data["budget"].(json.Number); ok {
+			budget, err := ParseMoney(budgetVal.String())
+			if err != nil {
+				respondError(w, "Invalid budget", http.StatusBadRequest)
+				return
+			}
+			acc.Budget = budget
+			budgetSet = true
 		}
 		if dueDateVal, ok := data["dueDate"].(string); ok {
 			acc.DueDate = sanitizeInput(dueDateVal)
 		}
 
+		existing, err := readAccounts()
+		if err != nil {
+			respondError(w, "Failed to load accounts", http.StatusInternalServerError)
+			return
+		}
+
+		// closedDate archives the account, an empty one reopens it; when
+		// it is left out the account keeps its current state. Parent works
+		// the same way.
+		if closedVal, ok := data["closedDate"].(string); ok {
+			acc.ClosedDate = sanitizeInput(closedVal)
+		} else {
+			acc.ClosedDate = findAccount(existing, oldAccount).ClosedDate
+		}
+		if parentVal, ok := data["parent"].(string); ok {
+			acc.Parent = sanitizeInput(parentVal)
+		} else {
+			acc.Parent = findAccount(existing, oldAccount).Parent
+		}
+		if currencyVal, ok := data["currency"].(string); ok {
+			acc.Currency = sanitizeInput(currencyVal)
+		} else {
+			acc.Currency = findAccount(existing, oldAccount).Currency
+		}
+
 		if err := validateAccount(&acc); err != nil {
 			respondError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		if err := validateAccountParent(existing, acc, oldAccount); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// A budget changed here applies from this month on; the account
+		// keeps the budget for months before its budget history
+		budget := acc.Budget
+		acc.Budget = findAccount(existing, oldAccount).Budget
+
 		// Update account (with support for name change)
 		if err := updateAccountWithNameChange(oldAccount, acc); err != nil {
 			respondError(w, "Failed to update account", http.StatusInternalServerError)
 			return
 		}
 
+		if budgetSet && acc.Type == "EXPENSE" {
+			if err := saveAccountBudget(acc, budget); err != nil {
+				respondError(w, "Failed to save budget", http.StatusInternalServerError)
+				return
+			}
+		}
+
 		logSecurityEvent("ACCOUNT_UPDATE", getClientIP(r), fmt.Sprintf("Updated account: %s", acc.Name))
 		json.NewEncoder(w).Encode(map[string]bool{"success": true})
 
@@ -711,12 +972,65 @@ func handleAccounts(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := deleteAccount(accountName); err != nil {
-			respondError(w, "Failed to delete account", http.StatusInternalServerError)
+		// An account still in use is archived or has its transactions
+		// reassigned; it is never deleted out from under its history
+		switch data["action"] {
+		case "archive":
+			err := archiveAccount(accountName, time.Now().Format("02-01-2006"))
+			if errors.Is(err, errAccountNotFound) {
+				respondError(w, "Account not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				respondError(w, "Failed to archive account", http.StatusInternalServerError)
+				return
+			}
+			logSecurityEvent("ACCOUNT_ARCHIVE", getClientIP(r), fmt.Sprintf("Archived account: %s", accountName))
+
+		case "reassign":
+			target := sanitizeInput(data["reassignTo"])
+			if target == "" {
+				respondError(w, "reassignTo account required", http.StatusBadRequest)
+				return
+			}
+			err := reassignAccount(accountName, target)
+			switch {
+			case errors.Is(err, errAccountNotFound):
+				respondError(w, "Account not found", http.StatusNotFound)
+				return
+			case errors.Is(err, errCannotReassign):
+				respondError(w, err.Error(), http.StatusBadRequest)
+				return
+			case err != nil:
+				respondError(w, "Failed to reassign account", http.StatusInternalServerError)
+				return
+			}
+			if err := recalculateAllData(); err != nil {
+				log.Printf("Error recalculating data: %v", err)
+			}
+			logSecurityEvent("ACCOUNT_DELETE", getClientIP(r), fmt.Sprintf("Deleted account %s, reassigned to %s", accountName, target))
+
+		case "":
+			refs, err := countAccountReferences(accountName)
+			if err != nil {
+				respondError(w, "Failed to check account usage", http.StatusInternalServerError)
+				return
+			}
+			if refs > 0 {
+				respondError(w, fmt.Sprintf("Account is used by %d transactions; archive it or reassign them to another account", refs), http.StatusConflict)
+				return
+			}
+			if err := deleteAccount(accountName); err != nil {
+				respondError(w, "Failed to delete account", http.StatusInternalServerError)
+				return
+			}
+			logSecurityEvent("ACCOUNT_DELETE", getClientIP(r), fmt.Sprintf("Deleted account: %s", accountName))
+
+		default:
+			respondError(w, "action must be archive or reassign", http.StatusBadRequest)
 			return
 		}
 
-		logSecurityEvent("ACCOUNT_DELETE", getClientIP(r), fmt.Sprintf("Deleted account: %s", accountName))
 		json.NewEncoder(w).Encode(map[string]bool{"success": true})
 
 	default:
@@ -727,7 +1041,16 @@ func handleAccounts(w http.ResponseWriter, r *http.Request) {
 func handleSettings(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	if r.Method != http.MethodPost {
+	// GET and PUT manage stored settings; POST changes the password
+	switch r.Method {
+	case http.MethodGet:
+		handleGetSettings(w, r)
+		return
+	case http.MethodPut:
+		handleUpdateSettings(w, r)
+		return
+	case http.MethodPost:
+	default:
 		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -782,6 +1105,16 @@ func handleSettings(w http.ResponseWriter, r *http.Request) {
 
 // Validation functions
 func validateTransaction(t *Transaction) error {
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	return validateTransactionWith(t, accounts)
+}
+
+// validateTransactionWith validates t against the given accounts, such as
+// the accounts a journal import is about to create
+func validateTransactionWith(t *Transaction, accounts []Account) error {
 	if t.TranDate == "" || t.TranTime == "" {
 		return errors.New("transaction date and time required")
 	}
@@ -798,22 +1131,99 @@ func validateTransaction(t *Transaction) error {
 	t.To = sanitizeInput(t.To)
 	t.Description = sanitizeInput(t.Description)
 
-	if t.From == "" || t.To == "" {
+	tags, err := normalizeTags(t.Tags)
+	if err != nil {
+		return err
+	}
+	t.Tags = tags
+
+	if err := normalizeSplits(t); err != nil {
+		return err
+	}
+
+	if err := validateInvestment(t); err != nil {
+		return err
+	}
+
+	if t.From == "" || (t.To == "" && len(t.Splits) == 0) {
 		return errors.New("from and to accounts required")
 	}
 
-	if len(t.Description) > 100 {
-		return errors.New("description too long (max 100 characters)")
+	if len(t.Description) > MAX_DESCRIPTION {
+		return fmt.Errorf("description too long (max %d characters)", MAX_DESCRIPTION)
 	}
 
 	if t.Amount <= 0 {
 		return errors.New("amount must be positive")
 	}
 
-	if t.Amount > 999999999.99 {
+	if t.Amount > MAX_AMOUNT {
 		return errors.New("amount too large")
 	}
 
+	for _, name := range append([]string{t.From}, legAccounts(*t)...) {
+		acc := findAccount(accounts, name)
+		if acc.Name == "" {
+			return fmt.Errorf("unknown account: %s", name)
+		}
+		if acc.Closed() && compareDates(t.TranDate, acc.ClosedDate) {
+			return fmt.Errorf("account %s was closed on %s", name, acc.ClosedDate)
+		}
+	}
+	if t.Security != "" && findAccount(accounts, t.HoldingAccount()).Type != "ASSET" {
+		return errors.New("securities must be held in an asset account")
+	}
+	if err := checkUnitsHeld(*t); err != nil {
+		return err
+	}
+
+	return resolveToAmounts(t, accounts)
+}
+
+func legAccounts(t Transaction) []string {
+	var names []string
+	for _, leg := range t.Legs() {
+		names = append(names, leg.To)
+	}
+	return names
+}
+
+// normalizeSplits validates the legs of a split transaction and derives
+// its total. A single leg is stored as a plain transaction.
+func normalizeSplits(t *Transaction) error {
+	if len(t.Splits) == 0 {
+		return nil
+	}
+
+	if len(t.Splits) > 50 {
+		return errors.New("too many split legs (max 50)")
+	}
+
+	var total Money
+	for i := range t.Splits {
+		leg := &t.Splits[i]
+		leg.To = sanitizeInput(leg.To)
+		if leg.To == "" {
+			return errors.New("every split leg needs a to account")
+		}
+		if leg.Amount <= 0 {
+			return errors.New("split amounts must be positive")
+		}
+		// Bounding each leg keeps the total from overflowing
+		if leg.Amount > MAX_AMOUNT {
+			return errors.New("split amount too large")
+		}
+		total += leg.Amount
+	}
+
+	if len(t.Splits) == 1 {
+		t.To = t.Splits[0].To
+		t.ToAmount = t.Splits[0].ToAmount
+		t.Splits = nil
+	} else {
+		t.To = ""
+	}
+	t.Amount = total
 	return nil
 }
 
@@ -844,11 +1254,22 @@ func validateAccount(a *Account) error {
 		return errors.New("invalid due date format (use DD-MM-YYYY)")
 	}
 
-	if a.Amount < -999999999.99 || a.Amount > 999999999.99 {
+	a.Parent = sanitizeInput(a.Parent)
+	a.Currency = strings.ToUpper(sanitizeInput(a.Currency))
+	if a.Currency != "" && !isValidCurrency(a.Currency) {
+		return errors.New("currency must be a 3-letter code such as INR or USD")
+	}
+
+	a.ClosedDate = sanitizeInput(a.ClosedDate)
+	if a.ClosedDate != "" && !isValidDate(a.ClosedDate) {
+		return errors.New("invalid closed date format (use DD-MM-YYYY)")
+	}
+
+	if a.Amount < -MAX_AMOUNT || a.Amount > MAX_AMOUNT {
 		return errors.New("amount out of range")
 	}
 
-	if a.Budget < 0 || a.Budget > 999999999.99 {
+	if a.Budget < 0 || a.Budget > MAX_AMOUNT {
 		return errors.New("budget out of range")
 	}
 
@@ -915,48 +1336,46 @@ func initDirectories() {
 }
 
 func initializeData() {
-	accountPath := filepath.Join(DATA_DIR, "account.csv")
-	if _, err := os.Stat(accountPath); os.IsNotExist(err) {
-		file, err := os.OpenFile(accountPath, os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			log.Fatalf("Failed to create account file: %v", err)
-		}
-		defer file.Close()
+	accounts, accErr := store.ReadAccounts()
+	if accErr != nil && !os.IsNotExist(accErr) {
+		log.Fatalf("Failed to read accounts: %v", accErr)
+	}
 
-		writer := csv.NewWriter(file)
-		writer.Write([]string{"Account", "Type", "Amount", "IINW", "Budget", "DueDate"})
-		writer.Write([]string{"Salary", "INCOME", "-1000", "No", "0", ""})
-		writer.Write([]string{"ICICIBank", "ASSET", "950", "Yes", "0", ""})
-		writer.Write([]string{"Food", "EXPENSE", "50", "No", "500", ""})
-		writer.Flush()
+	years, err := store.TransactionYears()
+	if err != nil {
+		log.Fatalf("Failed to list transactions: %v", err)
 	}
 
-	tranPath := filepath.Join(DATA_DIR, "tran_2025.csv")
-	if _, err := os.Stat(tranPath); os.IsNotExist(err) {
-		file, err := os.OpenFile(tranPath, os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			log.Fatalf("Failed to create transaction file: %v", err)
+	if len(accounts) == 0 && len(years) == 0 {
+		if err := store.WriteAccounts([]Account{
+			{Name: "Salary", Type: "INCOME", Amount: -1000 * MoneyUnit, IINW: "No"},
+			{Name: "ICICIBank", Type: "ASSET", Amount: 950 * MoneyUnit, IINW: "Yes"},
+			{Name: "Food", Type: "EXPENSE", Amount: 50 * MoneyUnit, IINW: "No", Budget: 500 * MoneyUnit},
+		}); err != nil {
+			log.Fatalf("Failed to create accounts: %v", err)
 		}
-		defer file.Close()
 
-		writer := csv.NewWriter(file)
-		writer.Write([]string{"TranDate", "TranTime", "From", "To", "Description", "Amount"})
-		writer.Write([]string{"28-10-2025", "13:00", "Salary", "ICICIBank", "SalaryCredit", "1000"})
-		writer.Write([]string{"29-10-2025", "17:00", "ICICIBank", "Food", "Dinner", "50"})
-		writer.Flush()
+		if err := store.WriteTransactions("2025", []Transaction{
+			{TranDate: "29-10-2025", TranTime: "17:00", From: "ICICIBank", To: "Food", Description: "Dinner", Amount: 50 * MoneyUnit},
+			{TranDate: "28-10-2025", TranTime: "13:00", From: "Salary", To: "ICICIBank", Description: "SalaryCredit", Amount: 1000 * MoneyUnit},
+		}); err != nil {
+			log.Fatalf("Failed to create transactions: %v", err)
+		}
+	} else if accErr != nil {
+		// Transactions exist but the account list is missing
+		if err := store.WriteAccounts(nil); err != nil {
+			log.Fatalf("Failed to create accounts: %v", err)
+		}
 	}
 
-	recordPath := filepath.Join(DATA_DIR, "record.csv")
-	if _, err := os.Stat(recordPath); os.IsNotExist(err) {
-		file, err := os.OpenFile(recordPath, os.O_CREATE|os.O_WRONLY, 0600)
-		if err != nil {
-			log.Fatalf("Failed to create record file: %v", err)
+	if _, err := store.ReadRecords(); err != nil {
+		if err := store.WriteRecords(nil); err != nil {
+			log.Fatalf("Failed to create records: %v", err)
 		}
-		defer file.Close()
+	}
 
-		writer := csv.NewWriter(file)
-		writer.Write([]string{"Date", "NetWorth", "Assets", "Liabilities", "Expenses"})
-		writer.Flush()
+	if err := backfillTransactionIDs(); err != nil {
+		log.Printf("Error assigning transaction IDs: %v", err)
 	}
 
 	if err := recalculateAllData(); err != nil {
@@ -965,38 +1384,7 @@ func initializeData() {
 }
 
 func readAccounts() ([]Account, error) {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-
-	file, err := os.Open(filepath.Join(DATA_DIR, "account.csv"))
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, err
-	}
-
-	var accounts []Account
-	for i, record := range records {
-		if i == 0 || len(record) < 6 {
-			continue
-		}
-		amount, _ := strconv.ParseFloat(record[2], 64)
-		budget, _ := strconv.ParseFloat(record[4], 64)
-		accounts = append(accounts, Account{
-			Name:    record[0],
-			Type:    record[1],
-			Amount:  amount,
-			IINW:    record[3],
-			Budget:  budget,
-			DueDate: record[5],
-		})
-	}
-	return accounts, nil
+	return store.ReadAccounts()
 }
 
 func sortAccountsByUsage(accounts []Account, transactions []Transaction) []Account {
@@ -1005,11 +1393,16 @@ func sortAccountsByUsage(accounts []Account, transactions []Transaction) []Accou
 	// Count usage of each account in transactions
 	for _, tran := range transactions {
 		usageCount[tran.From]++
-		usageCount[tran.To]++
+		for _, leg := range tran.Legs() {
+			usageCount[leg.To]++
+		}
 	}
 	
-	// Sort accounts by usage (highest first)
+	// Sort accounts by usage (highest first), archived accounts last
 	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Closed() != accounts[j].Closed() {
+			return !accounts[i].Closed()
+		}
 		return usageCount[accounts[i].Name] > usageCount[accounts[j].Name]
 	})
 	
@@ -1017,29 +1410,22 @@ func sortAccountsByUsage(accounts []Account, transactions []Transaction) []Accou
 }
 
 func addAccount(acc Account) error {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-
-	file, err := os.OpenFile(filepath.Join(DATA_DIR, "account.csv"), os.O_APPEND|os.O_WRONLY, 0600)
+	accounts, err := readAccounts()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	err = writer.Write([]string{
-		acc.Name,
-		acc.Type,
-		fmt.Sprintf("%.2f", acc.Amount),
-		acc.IINW,
-		fmt.Sprintf("%.2f", acc.Budget),
-		acc.DueDate,
-	})
-	if err != nil {
-		return err
+	return store.WriteAccounts(append(accounts, acc))
+}
+
+// saveAccounts writes the account list with the most used accounts first
+func saveAccounts(accounts []Account) error {
+	transactions, err := readAllTransactions()
+	if err == nil {
+		accounts = sortAccountsByUsage(accounts, transactions)
 	}
-	writer.Flush()
-	return writer.Error()
+
+	return store.WriteAccounts(accounts)
 }
 
 func updateAccount(acc Account) error {
@@ -1055,37 +1441,8 @@ func updateAccount(acc Account) error {
 			break
 		}
 	}
-	
-	// Read all transactions to calculate usage and sort
-	transactions, err := readAllTransactions()
-	if err == nil {
-		accounts = sortAccountsByUsage(accounts, transactions)
-	}
 
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-
-	file, err := os.Create(filepath.Join(DATA_DIR, "account.csv"))
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	writer.Write([]string{"Account", "Type", "Amount", "IINW", "Budget", "DueDate"})
-
-	for _, a := range accounts {
-		writer.Write([]string{
-			a.Name,
-			a.Type,
-			fmt.Sprintf("%.2f", a.Amount),
-			a.IINW,
-			fmt.Sprintf("%.2f", a.Budget),
-			a.DueDate,
-		})
-	}
-	writer.Flush()
-	return writer.Error()
+	return saveAccounts(accounts)
 }
 
 func updateAccountWithNameChange(oldName string, acc Account) error {
@@ -1116,98 +1473,244 @@ func updateAccountWithNameChange(oldName string, acc Account) error {
 	if !found {
 		return errors.New("Account not found")
 	}
-	
-	// Read all transactions to calculate usage and sort
-	transactions, err := readAllTransactions()
-	if err == nil {
-		accounts = sortAccountsByUsage(accounts, transactions)
+
+	if oldName == acc.Name {
+		return saveAccounts(accounts)
 	}
 
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+	reparentChildren(accounts, oldName, acc.Name)
 
-	file, err := os.Create(filepath.Join(DATA_DIR, "account.csv"))
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	return renameAccountReferences(oldName, acc.Name, accounts)
+}
 
-	writer := csv.NewWriter(file)
-	writer.Write([]string{"Account", "Type", "Amount", "IINW", "Budget", "DueDate"})
+// renameAccountReferences points every transaction and feature table row at
+// the new account name and writes them together with the given account
+// list, so a crash can never leave history referring to a name that no
+// longer exists. Renames and reassignments on delete both use it.
+func renameAccountReferences(oldName, newName string, accounts []Account) error {
+	recurringMutex.Lock()
+	defer recurringMutex.Unlock()
 
-	for _, a := range accounts {
-		writer.Write([]string{
-			a.Name,
-			a.Type,
-			fmt.Sprintf("%.2f", a.Amount),
-			a.IINW,
-			fmt.Sprintf("%.2f", a.Budget),
-			a.DueDate,
-		})
+	batch := Batch{
+		Transactions: make(map[string][]Transaction),
+		Tables:       make(map[string]Table),
 	}
-	writer.Flush()
-	
-	return writer.Error()
-}
 
-func deleteAccount(name string) error {
-	accounts, err := readAccounts()
+	years, err := store.TransactionYears()
 	if err != nil {
 		return err
 	}
 
-	// Filter out the account to delete
-	var filteredAccounts []Account
-	for _, a := range accounts {
-		if a.Name != name {
-			filteredAccounts = append(filteredAccounts, a)
+	var allTransactions []Transaction
+	for _, year := range years {
+		transactions, err := store.ReadTransactions(year)
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for i := range transactions {
+			t := &transactions[i]
+			if t.From == oldName {
+				t.From = newName
+				changed = true
+			}
+			if t.To == oldName {
+				t.To = newName
+				changed = true
+			}
+			for j := range t.Splits {
+				if t.Splits[j].To == oldName {
+					t.Splits[j].To = newName
+					changed = true
+				}
+			}
 		}
+		if changed {
+			batch.Transactions[year] = transactions
+		}
+		allTransactions = append(allTransactions, transactions...)
 	}
-	
-	// Read all transactions to calculate usage and sort
+
+	for name, columns := range accountColumns {
+		rows, cols, err := store.ReadTable(name)
+		if err != nil {
+			return err
+		}
+
+		moved := make(map[int]bool)
+		for r, row := range rows {
+			for _, column := range columns {
+				if i, ok := cols[column]; ok && i < len(row) && row[i] == oldName {
+					row[i] = newName
+					moved[r] = true
+				}
+			}
+		}
+		if len(moved) > 0 {
+			header := tableHeader(cols)
+			batch.Tables[name] = Table{Header: header, Rows: dropCollidingRows(name, rows, cols, moved)}
+		}
+	}
+
+	batch.Accounts = sortAccountsByUsage(accounts, allTransactions)
+	if err := store.Commit(batch); err != nil {
+		return err
+	}
+
+	log.Printf("Moved references from account %s to %s in %d transaction years and %d tables",
+		oldName, newName, len(batch.Transactions), len(batch.Tables))
+	return nil
+}
+
+// dropCollidingRows removes the rows a reassignment leaves redundant: a
+// budget moved onto a month the account already has its own budget for,
+// which is kept, and money moved from an envelope to itself. moved holds
+// the rows that were rewritten.
+func dropCollidingRows(table string, rows [][]string, cols map[string]int, moved map[int]bool) [][]string {
+	budgetKey := func(row []string) string {
+		return strings.Join([]string{columnValue(row, cols, "Account"), columnValue(row, cols, "Month"), columnValue(row, cols, "Once")}, "\x00")
+	}
+	own := make(map[string]bool)
+	if table == "budgets" {
+		for r, row := range rows {
+			if !moved[r] {
+				own[budgetKey(row)] = true
+			}
+		}
+	}
+
+	kept := rows[:0]
+	for r, row := range rows {
+		if moved[r] {
+			if table == "budgets" && own[budgetKey(row)] {
+				continue
+			}
+			if table == "allocations" && columnValue(row, cols, "From") == columnValue(row, cols, "To") {
+				continue
+			}
+		}
+		kept = append(kept, row)
+	}
+	return kept
+}
+
+// countAccountReferences counts the transactions and feature table rows that
+// use an account
+func countAccountReferences(name string) (int, error) {
 	transactions, err := readAllTransactions()
-	if err == nil {
-		filteredAccounts = sortAccountsByUsage(filteredAccounts, transactions)
+	if err != nil {
+		return 0, err
 	}
 
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+	count := 0
+	for _, t := range transactions {
+		for _, n := range append([]string{t.From}, legAccounts(t)...) {
+			if n == name {
+				count++
+				break
+			}
+		}
+	}
 
-	file, err := os.Create(filepath.Join(DATA_DIR, "account.csv"))
+	for table, columns := range accountColumns {
+		rows, cols, err := store.ReadTable(table)
+		if err != nil {
+			return 0, err
+		}
+		for _, row := range rows {
+			for _, column := range columns {
+				if columnValue(row, cols, column) == name {
+					count++
+					break
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// archiveAccount closes an account instead of deleting it, keeping its
+// history intact
+func archiveAccount(name, closedDate string) error {
+	accounts, err := readAccounts()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	writer.Write([]string{"Account", "Type", "Amount", "IINW", "Budget", "DueDate"})
+	for i := range accounts {
+		if accounts[i].Name == name {
+			accounts[i].ClosedDate = closedDate
+			return saveAccounts(accounts)
+		}
+	}
+	return errAccountNotFound
+}
 
-	for _, a := range filteredAccounts {
-		writer.Write([]string{
-			a.Name,
-			a.Type,
-			fmt.Sprintf("%.2f", a.Amount),
-			a.IINW,
-			fmt.Sprintf("%.2f", a.Budget),
-			a.DueDate,
-		})
+// reassignAccount moves every reference to name onto target and removes
+// name. Amounts are moved as they are, so both must hold the same currency.
+func reassignAccount(name, target string) error {
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
 	}
-	writer.Flush()
-	return writer.Error()
+
+	acc, to := findAccount(accounts, name), findAccount(accounts, target)
+	if acc.Name == "" {
+		return errAccountNotFound
+	}
+	if name == target {
+		return fmt.Errorf("%w an account to itself", errCannotReassign)
+	}
+	if to.Name == "" {
+		return fmt.Errorf("%w to unknown account: %s", errCannotReassign, target)
+	}
+	base := getSetting("baseCurrency")
+	if from, into := accountCurrency(acc, base), accountCurrency(to, base); from != into {
+		return fmt.Errorf("%w %s (%s) to %s (%s); the currencies differ", errCannotReassign, name, from, target, into)
+	}
+
+	var remaining []Account
+	for _, a := range accounts {
+		if a.Name != name {
+			remaining = append(remaining, a)
+		}
+	}
+	reparentChildren(remaining, name, acc.Parent)
+
+	return renameAccountReferences(name, target, remaining)
+}
+
+func deleteAccount(name string) error {
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
+	}
+
+	// Filter out the account to delete
+	var filteredAccounts []Account
+	for _, a := range accounts {
+		if a.Name != name {
+			filteredAccounts = append(filteredAccounts, a)
+		}
+	}
+	reparentChildren(filteredAccounts, name, findAccount(accounts, name).Parent)
+
+	return saveAccounts(filteredAccounts)
 }
 
 func readAllTransactions() ([]Transaction, error) {
 	var allTransactions []Transaction
 
-	files, err := filepath.Glob(filepath.Join(DATA_DIR, "tran_*.csv"))
+	years, err := store.TransactionYears()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, filePath := range files {
-		transactions, err := readTransactionsFromFile(filePath)
+	for _, year := range years {
+		transactions, err := store.ReadTransactions(year)
 		if err != nil {
-			log.Printf("Error reading file %s: %v", filePath, err)
+			log.Printf("Error reading transactions for %s: %v", year, err)
 			continue
 		}
 		allTransactions = append(allTransactions, transactions...)
@@ -1224,12 +1727,19 @@ func readAllTransactions() ([]Transaction, error) {
 }
 
 func addTransaction(tran Transaction) error {
+	if tran.ID == "" {
+		id, err := newTransactionID()
+		if err != nil {
+			return err
+		}
+		tran.ID = id
+	}
+
 	year := tran.TranDate[6:10]
-	filePath := filepath.Join(DATA_DIR, "tran_"+year+".csv")
 
-	// Read existing transactions from the file
-	existingTransactions, err := readTransactionsFromFile(filePath)
-	if err != nil && !os.IsNotExist(err) {
+	// Read existing transactions for the year
+	existingTransactions, err := store.ReadTransactions(year)
+	if err != nil {
 		return err
 	}
 
@@ -1244,147 +1754,177 @@ func addTransaction(tran Transaction) error {
 		return compareDates(existingTransactions[i].TranDate, existingTransactions[j].TranDate)
 	})
 
-	// Write all transactions back to file
-	return writeTransactionsToFile(filePath, existingTransactions)
+	// Write all transactions back
+	return store.WriteTransactions(year, existingTransactions)
 }
 
-func updateTransaction(data map[string]interface{}) error {
-	oldDate, ok := data["oldTranDate"].(string)
-	if !ok {
-		return errors.New("invalid oldTranDate")
-	}
-	oldTime, ok := data["oldTranTime"].(string)
-	if !ok {
-		return errors.New("invalid oldTranTime")
-	}
-
-	newDate, ok := data["tranDate"].(string)
-	if !ok {
-		return errors.New("invalid tranDate")
-	}
-	newTime, ok := data["tranTime"].(string)
-	if !ok {
-		return errors.New("invalid tranTime")
-	}
-	from, ok := data["from"].(string)
-	if !ok {
-		return errors.New("invalid from")
-	}
-	to, ok := data["to"].(string)
-	if !ok {
-		return errors.New("invalid to")
-	}
-	description, ok := data["description"].(string)
-	if !ok {
-		return errors.New("invalid description")
-	}
-	amount, ok := data["amount"].(float64)
-	if !ok {
-		return errors.New("invalid amount")
-	}
-
-	if err := deleteTransaction(oldDate, oldTime); err != nil {
+// addTransactions adds many transactions in one batch, so an import lands
+// completely or not at all
+func addTransactions(trans []Transaction) error {
+	byYear, err := transactionYearBatch(trans)
+	if err != nil {
 		return err
 	}
+	return store.Commit(Batch{Transactions: byYear})
+}
 
-	tran := Transaction{
-		TranDate:    newDate,
-		TranTime:    newTime,
-		From:        from,
-		To:          to,
-		Description: description,
-		Amount:      amount,
+// transactionYearBatch adds trans to the years they fall in, newest first,
+// giving every new transaction an ID
+func transactionYearBatch(trans []Transaction) (map[string][]Transaction, error) {
+	byYear := make(map[string][]Transaction)
+	for _, tran := range trans {
+		if tran.ID == "" {
+			id, err := newTransactionID()
+			if err != nil {
+				return nil, err
+			}
+			tran.ID = id
+		}
+
+		year := tran.TranDate[6:10]
+		if _, ok := byYear[year]; !ok {
+			existing, err := store.ReadTransactions(year)
+			if err != nil {
+				return nil, err
+			}
+			byYear[year] = existing
+		}
+		byYear[year] = append(byYear[year], tran)
 	}
 
-	if err := validateTransaction(&tran); err != nil {
-		return err
+	for _, transactions := range byYear {
+		sort.Slice(transactions, func(i, j int) bool {
+			if transactions[i].TranDate == transactions[j].TranDate {
+				return transactions[i].TranTime > transactions[j].TranTime
+			}
+			return compareDates(transactions[i].TranDate, transactions[j].TranDate)
+		})
 	}
 
-	return addTransaction(tran)
+	return byYear, nil
 }
 
-func deleteTransaction(date, time string) error {
-	if len(date) < 10 {
-		return errors.New("invalid date format")
+func updateTransaction(tran Transaction) error {
+	if err := validateTransaction(&tran); err != nil {
+		return err
 	}
 
-	year := date[6:10]
-	filePath := filepath.Join(DATA_DIR, "tran_"+year+".csv")
-
-	transactions, err := readTransactionsFromFile(filePath)
+	years, err := store.TransactionYears()
 	if err != nil {
 		return err
 	}
 
-	var filtered []Transaction
-	for _, t := range transactions {
-		if !(t.TranDate == date && t.TranTime == time) {
+	// The old row may live in a different year file than the edited one;
+	// both files are written in one batch so the edit is never half done
+	batch := Batch{Transactions: make(map[string][]Transaction)}
+	found := false
+	for _, year := range years {
+		transactions, err := store.ReadTransactions(year)
+		if err != nil {
+			return err
+		}
+
+		filtered := make([]Transaction, 0, len(transactions))
+		for _, t := range transactions {
+			if t.ID == tran.ID {
+				// The bank's ID of an imported line survives edits
+				tran.FITID = t.FITID
+				found = true
+				continue
+			}
 			filtered = append(filtered, t)
 		}
+		if len(filtered) != len(transactions) {
+			batch.Transactions[year] = filtered
+			break
+		}
+	}
+	if !found {
+		return errTransactionNotFound
 	}
 
-	return writeTransactionsToFile(filePath, filtered)
-}
+	year := tran.TranDate[6:10]
+	transactions, ok := batch.Transactions[year]
+	if !ok {
+		if transactions, err = store.ReadTransactions(year); err != nil {
+			return err
+		}
+	}
+	transactions = append(transactions, tran)
+	sort.Slice(transactions, func(i, j int) bool {
+		if transactions[i].TranDate == transactions[j].TranDate {
+			return transactions[i].TranTime > transactions[j].TranTime
+		}
+		return compareDates(transactions[i].TranDate, transactions[j].TranDate)
+	})
+	batch.Transactions[year] = transactions
 
-func readTransactionsFromFile(filePath string) ([]Transaction, error) {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+	return store.Commit(batch)
+}
 
-	file, err := os.Open(filePath)
+func deleteTransaction(id string) error {
+	years, err := store.TransactionYears()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, err
-	}
+	for _, year := range years {
+		transactions, err := store.ReadTransactions(year)
+		if err != nil {
+			return err
+		}
 
-	var transactions []Transaction
-	for i, record := range records {
-		if i == 0 || len(record) < 6 {
-			continue
+		var filtered []Transaction
+		for _, t := range transactions {
+			if t.ID != id {
+				filtered = append(filtered, t)
+			}
+		}
+
+		if len(filtered) != len(transactions) {
+			return store.WriteTransactions(year, filtered)
 		}
-		amount, _ := strconv.ParseFloat(record[5], 64)
-		transactions = append(transactions, Transaction{
-			TranDate:    record[0],
-			TranTime:    record[1],
-			From:        record[2],
-			To:          record[3],
-			Description: record[4],
-			Amount:      amount,
-		})
 	}
-	return transactions, nil
-}
 
-func writeTransactionsToFile(filePath string, transactions []Transaction) error {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+	return errTransactionNotFound
+}
 
-	file, err := os.Create(filePath)
+// backfillTransactionIDs assigns IDs to rows that were written before IDs
+// existed (or were copied by hand) so every row can be addressed uniquely.
+func backfillTransactionIDs() error {
+	years, err := store.TransactionYears()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	writer.Write([]string{"TranDate", "TranTime", "From", "To", "Description", "Amount"})
+	seen := make(map[string]bool)
+	for _, year := range years {
+		transactions, err := store.ReadTransactions(year)
+		if err != nil {
+			return err
+		}
 
-	for _, t := range transactions {
-		writer.Write([]string{
-			t.TranDate,
-			t.TranTime,
-			t.From,
-			t.To,
-			t.Description,
-			fmt.Sprintf("%.2f", t.Amount),
-		})
+		changed := 0
+		for i := range transactions {
+			if transactions[i].ID == "" || seen[transactions[i].ID] {
+				id, err := newTransactionID()
+				if err != nil {
+					return err
+				}
+				transactions[i].ID = id
+				changed++
+			}
+			seen[transactions[i].ID] = true
+		}
+
+		if changed > 0 {
+			if err := store.WriteTransactions(year, transactions); err != nil {
+				return err
+			}
+			log.Printf("Assigned IDs to %d transactions in %s", changed, year)
+		}
 	}
-	writer.Flush()
-	return writer.Error()
+	return nil
 }
 
 func recalculateAllData() error {
@@ -1398,8 +1938,19 @@ func recalculateAllData() error {
 		return err
 	}
 
+	// Balances stay in each account's currency; records are in the base
+	// currency
+	conv, err := loadConverter()
+	if err != nil {
+		return err
+	}
+	currencies := make(map[string]string, len(accounts))
+	for _, acc := range accounts {
+		currencies[acc.Name] = accountCurrency(acc, conv.Base)
+	}
+
 	// Initialize account balances to zero (we'll build them up from transactions)
-	accountBalances := make(map[string]float64)
+	accountBalances := make(map[string]Money)
 	for _, acc := range accounts {
 		// Start with current balance for non-transactional accounts (MutualFunds, Stocks, Loans)
 		accountBalances[acc.Name] = 0
@@ -1408,7 +1959,7 @@ func recalculateAllData() error {
 	// Group transactions by date
 	type DailyData struct {
 		Transactions []Transaction
-		Expenses     float64
+		Expenses     Money
 	}
 	dailyData := make(map[string]*DailyData)
 
@@ -1422,9 +1973,10 @@ func recalculateAllData() error {
 		}
 		dailyData[date].Transactions = append(dailyData[date].Transactions, tran)
 
-		toAcc := findAccount(accounts, tran.To)
-		if toAcc.Type == "EXPENSE" {
-			dailyData[date].Expenses += tran.Amount
+		for _, leg := range tran.Legs() {
+			if findAccount(accounts, leg.To).Type == "EXPENSE" {
+				dailyData[date].Expenses += conv.ToBase(leg.Received(), currencies[leg.To], date)
+			}
 		}
 	}
 
@@ -1444,16 +1996,16 @@ func recalculateAllData() error {
 		// Apply transactions for this date
 		for _, tran := range dailyData[date].Transactions {
 			accountBalances[tran.From] -= tran.Amount
-			accountBalances[tran.To] += tran.Amount
+			for _, leg := range tran.Legs() {
+				accountBalances[leg.To] += leg.Received()
+			}
 		}
 
 		// Calculate net worth after this day's transactions
-		netWorth := 0.0
-		assets := 0.0
-		liabilities := 0.0
+		var netWorth, assets, liabilities Money
 
 		for _, acc := range accounts {
-			currentBalance := accountBalances[acc.Name]
+			currentBalance := conv.ToBase(accountBalances[acc.Name], currencies[acc.Name], date)
 			
 			if acc.IINW == "Yes" {
 				if acc.Type == "ASSET" {
@@ -1475,11 +2027,16 @@ func recalculateAllData() error {
 		})
 	}
 
-	// Update account balances to final values
-	for _, acc := range accounts {
-		if err := updateAccountBalance(acc.Name, accountBalances[acc.Name]); err != nil {
-			return err
-		}
+	if missing := conv.Missing(); len(missing) > 0 {
+		log.Printf("No exchange rate for %s; amounts counted unconverted", strings.Join(missing, ", "))
+	}
+
+	// Update account balances to final values, in one write
+	for i := range accounts {
+		accounts[i].Amount = accountBalances[accounts[i].Name]
+	}
+	if err := store.WriteAccounts(sortAccountsByUsage(accounts, transactions)); err != nil {
+		return err
 	}
 
 	return writeRecords(records)
@@ -1494,150 +2051,116 @@ func findAccount(accounts []Account, name string) Account {
 	return Account{}
 }
 
-func updateAccountBalance(name string, balance float64) error {
-	accounts, err := readAccounts()
-	if err != nil {
-		return err
-	}
-
-	// Update balance
-	for i := range accounts {
-		if accounts[i].Name == name {
-			accounts[i].Amount = balance
-			break
-		}
-	}
-	
-	// Read all transactions to calculate usage and sort
-	transactions, err := readAllTransactions()
-	if err == nil {
-		accounts = sortAccountsByUsage(accounts, transactions)
-	}
-
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-
-	file, err := os.Create(filepath.Join(DATA_DIR, "account.csv"))
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	writer.Write([]string{"Account", "Type", "Amount", "IINW", "Budget", "DueDate"})
-
-	for _, acc := range accounts {
-		writer.Write([]string{
-			acc.Name,
-			acc.Type,
-			fmt.Sprintf("%.2f", acc.Amount),
-			acc.IINW,
-			fmt.Sprintf("%.2f", acc.Budget),
-			acc.DueDate,
-		})
-	}
-	writer.Flush()
-	return writer.Error()
-}
-
 func readRecords() ([]Record, error) {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-
-	file, err := os.Open(filepath.Join(DATA_DIR, "record.csv"))
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, err
-	}
-
-	var result []Record
-	for i, record := range records {
-		if i == 0 || len(record) < 5 {
-			continue
-		}
-		netWorth, _ := strconv.ParseFloat(record[1], 64)
-		assets, _ := strconv.ParseFloat(record[2], 64)
-		liabilities, _ := strconv.ParseFloat(record[3], 64)
-		expenses, _ := strconv.ParseFloat(record[4], 64)
-
-		result = append(result, Record{
-			Date:        record[0],
-			NetWorth:    netWorth,
-			Assets:      assets,
-			Liabilities: liabilities,
-			Expenses:    expenses,
-		})
-	}
-	return result, nil
+	return store.ReadRecords()
 }
 
 func writeRecords(records []Record) error {
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
-
-	file, err := os.Create(filepath.Join(DATA_DIR, "record.csv"))
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	writer.Write([]string{"Date", "NetWorth", "Assets", "Liabilities", "Expenses"})
-
-	// Write records in reverse order (newest first) for display
+	// Store records in reverse order (newest first) for display
+	reversed := make([]Record, 0, len(records))
 	for i := len(records) - 1; i >= 0; i-- {
-		r := records[i]
-		writer.Write([]string{
-			r.Date,
-			fmt.Sprintf("%.2f", r.NetWorth),
-			fmt.Sprintf("%.2f", r.Assets),
-			fmt.Sprintf("%.2f", r.Liabilities),
-			fmt.Sprintf("%.2f", r.Expenses),
-		})
+		reversed = append(reversed, records[i])
 	}
-	writer.Flush()
-	return writer.Error()
+	return store.WriteRecords(reversed)
 }
 
-func calculateBudget(transactions []Transaction, accounts []Account, month string) map[string]interface{} {
-	totalBudget := 0.0
-	totalSpent := 0.0
-	breakdown := make(map[string]map[string]float64)
+// calculateBudget compares spending with the budgets (see budgets.go) for
+// a month (MM-YYYY), and for the fiscal year up to the end of that month,
+// in the base currency at the rate on the month's last day. With envelope
+// budgeting on it adds what each envelope carried over and has left.
+func calculateBudget(transactions []Transaction, accounts []Account, plan BudgetPlan, month string, conv *Converter) map[string]interface{} {
+	entries := plan.Entries
+	var totalBudget, totalSpent, yearBudget, yearSpent Money
+	budgets := make(map[string]Money)
+	spent := make(map[string]Money)
+
+	monthStart, _ := time.Parse("01-2006", month)
+	yearStart, yearLabel := fiscalYear(monthStart, plan.FiscalYearStart)
+	months := (monthStart.Year()-yearStart.Year())*12 + int(monthStart.Month()-yearStart.Month()) + 1
+	yearFrom := yearStart.Format("20060102")
+	yearTo := monthStart.AddDate(0, 1, -1).Format("20060102")
+	asOf := monthStart.AddDate(0, 1, -1)
+	if asOf.After(time.Now()) {
+		asOf = time.Now()
+	}
 
 	for _, acc := range accounts {
-		if acc.Type == "EXPENSE" && acc.Budget > 0 {
-			totalBudget += acc.Budget
-			breakdown[acc.Name] = map[string]float64{
-				"budget": acc.Budget,
-				"spent":  0,
-			}
+		if acc.Type != "EXPENSE" {
+			continue
+		}
+		currency := accountCurrency(acc, conv.Base)
+		if budget := budgetFor(acc, entries, month); budget > 0 {
+			budget = conv.ToBase(budget, currency, asOf.Format("02-01-2006"))
+			totalBudget += budget
+			budgets[acc.Name] = budget
+		}
+		for i := 0; i < months; i++ {
+			m := yearStart.AddDate(0, i, 0).Format("01-2006")
+			yearBudget += conv.ToBase(budgetFor(acc, entries, m), currency, asOf.Format("02-01-2006"))
 		}
 	}
 
 	for _, tran := range transactions {
 		if len(tran.TranDate) >= 10 {
 			tranMonth := tran.TranDate[3:10]
-			if tranMonth == month {
-				toAcc := findAccount(accounts, tran.To)
-				if toAcc.Type == "EXPENSE" {
-					totalSpent += tran.Amount
-					if breakdown[tran.To] != nil {
-						breakdown[tran.To]["spent"] += tran.Amount
+			key := dateKey(tran.TranDate)
+			inYear := key >= yearFrom && key <= yearTo
+			if tranMonth == month || inYear {
+				for _, leg := range tran.Legs() {
+					acc := findAccount(accounts, leg.To)
+					if acc.Type == "EXPENSE" {
+						amount := conv.ToBase(leg.Received(), accountCurrency(acc, conv.Base), tran.TranDate)
+						if tranMonth == month {
+							totalSpent += amount
+							spent[leg.To] += amount
+						}
+						if inYear {
+							yearSpent += amount
+						}
 					}
 				}
 			}
 		}
 	}
 
+	// Envelopes, once started, carry what is left (or overspent) into the
+	// next month
+	var envelopes *EnvelopeMonth
+	carried := make(map[string]Money)
+	available := make(map[string]Money)
+	if plan.EnvelopeStart != "" && monthKey(month) >= monthKey(plan.EnvelopeStart) {
+		envelopes = envelopeBalances(transactions, accounts, plan, month, conv)
+		for _, e := range envelopes.Envelopes {
+			carried[e.Account] = e.Carried
+			available[e.Account] = e.Available
+		}
+	}
+
+	// A parent's entry covers its children's budgets and spending
+	budgetTotals := rollupAccounts(accounts, budgets)
+	spentTotals := rollupAccounts(accounts, spent)
+	carriedTotals := rollupAccounts(accounts, carried)
+	availableTotals := rollupAccounts(accounts, available)
+	breakdown := make(map[string]map[string]Money)
+	for _, acc := range accounts {
+		if acc.Type != "EXPENSE" {
+			continue
+		}
+		if budgetTotals[acc.Name] > 0 || carriedTotals[acc.Name] != 0 || availableTotals[acc.Name] != 0 {
+			breakdown[acc.Name] = map[string]Money{
+				"budget": budgetTotals[acc.Name],
+				"spent":  spentTotals[acc.Name],
+			}
+			if envelopes != nil {
+				breakdown[acc.Name]["carried"] = carriedTotals[acc.Name]
+				breakdown[acc.Name]["available"] = availableTotals[acc.Name]
+			}
+		}
+	}
+
 	percentage := 0.0
 	if totalBudget > 0 {
-		percentage = (totalSpent / totalBudget) * 100
+		percentage = float64(totalSpent) / float64(totalBudget) * 100
 	}
 
 	return map[string]interface{}{
@@ -1645,6 +2168,15 @@ func calculateBudget(transactions []Transaction, accounts []Account, month strin
 		"totalSpent":  totalSpent,
 		"percentage":  percentage,
 		"breakdown":   breakdown,
+		// The budgets of each month of the fiscal year so far
+		"yearToDate": map[string]interface{}{
+			"fiscalYear": yearLabel,
+			"from":       yearStart.Format("02-01-2006"),
+			"months":     months,
+			"budget":     yearBudget,
+			"spent":      yearSpent,
+		},
+		"envelopes": envelopes, // null when envelope budgeting is off
 	}
 }
 
@@ -1653,7 +2185,7 @@ func getUpcomingBills(accounts []Account) []map[string]interface{} {
 	now := time.Now()
 
 	for _, acc := range accounts {
-		if acc.Type == "LIABILITIES" && acc.DueDate != "" {
+		if acc.Type == "LIABILITIES" && acc.DueDate != "" && !acc.Closed() {
 			dueDate, err := time.Parse("02-01-2006", acc.DueDate)
 			if err != nil {
 				continue
@@ -1704,11 +2236,9 @@ func startDailyBatch() {
 		logger := log.New(logFile, "", log.LstdFlags)
 		logger.Println("Starting daily batch process")
 
-		if err := recalculateAllData(); err != nil {
-			logger.Printf("Error in batch process: %v", err)
-		} else {
-			logger.Println("Daily batch completed successfully")
-		}
+		// Recalculates balances itself when it posts anything
+		runRecurringBatch(logger)
+		logger.Println("Daily batch completed")
 
 		logFile.Close()
 	}
@@ -1723,6 +2253,34 @@ func generateSecureToken(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
+// newTransactionID returns a random hex ID for a transaction row
+func newTransactionID() (string, error) {
+	bytes := make([]byte, TRANSACTION_ID_LENGTH)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// columnIndex maps CSV header names to their column positions
+func columnIndex(header []string) map[string]int {
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.TrimSpace(name)] = i
+	}
+	return cols
+}
+
+// columnValue returns the named column of a CSV row, or "" if the file
+// predates that column
+func columnValue(record []string, cols map[string]int, name string) string {
+	i, ok := cols[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
+
 func getClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header first (for proxies)
 	forwarded := r.Header.Get("X-Forwarded-For")
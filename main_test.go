@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReassignAccountChecks(t *testing.T) {
+	useTestStore(t)
+
+	accounts := []Account{
+		{Name: "HDFC", Type: "ASSET", IINW: "Yes"},
+		{Name: "SBI", Type: "ASSET", IINW: "Yes", Currency: "INR"},
+		{Name: "Wise", Type: "ASSET", IINW: "Yes", Currency: "USD"},
+	}
+	if err := store.WriteAccounts(accounts); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, from, to string
+		want           error
+		msg            string // a part of the message
+	}{
+		{name: "unknown account", from: "Axis", to: "HDFC", want: errAccountNotFound},
+		{name: "itself", from: "HDFC", to: "HDFC", want: errCannotReassign, msg: "to itself"},
+		{name: "unknown target", from: "HDFC", to: "Axis", want: errCannotReassign, msg: "unknown account: Axis"},
+		{name: "other currency", from: "Wise", to: "HDFC", want: errCannotReassign, msg: "Wise (USD) to HDFC (INR)"},
+	}
+
+	for _, tt := range tests {
+		err := reassignAccount(tt.from, tt.to)
+		if !errors.Is(err, tt.want) || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: reassignAccount(%s, %s) = %v, want %v", tt.name, tt.from, tt.to, err, tt.want)
+		}
+	}
+	if got, err := readAccounts(); err != nil || len(got) != 3 {
+		t.Fatalf("accounts after rejected reassigns = %v, %v", got, err)
+	}
+
+	// An account without a currency holds the base currency
+	if err := reassignAccount("HDFC", "SBI"); err != nil {
+		t.Fatalf("reassign HDFC to SBI: %v", err)
+	}
+	got, err := readAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || findAccount(got, "HDFC").Name != "" {
+		t.Errorf("accounts after reassign = %+v", got)
+	}
+}
+
+func TestTransactionLegs(t *testing.T) {
+	tests := []struct {
+		name string
+		tran Transaction
+		want []Split
+	}{
+		{name: "plain", tran: Transaction{To: "Food", Amount: 100}, want: []Split{{To: "Food", Amount: 100}}},
+		{name: "converted", tran: Transaction{To: "Wise", Amount: 8325, ToAmount: 100}, want: []Split{{To: "Wise", Amount: 8325, ToAmount: 100}}},
+		{
+			name: "split",
+			tran: Transaction{Amount: 300, Splits: []Split{{To: "Food", Amount: 100}, {To: "Home", Amount: 200}}},
+			want: []Split{{To: "Food", Amount: 100}, {To: "Home", Amount: 200}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.tran.Legs(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Legs() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeSplits(t *testing.T) {
+	tests := []struct {
+		name   string
+		splits []Split
+		want   Transaction // after normalizing, when there is no error
+		err    string
+	}{
+		{name: "none", want: Transaction{To: "Food", Amount: 999}},
+		{
+			name:   "sums the legs",
+			splits: []Split{{To: "Food", Amount: 100}, {To: "Home", Amount: 250}},
+			want:   Transaction{Amount: 350, Splits: []Split{{To: "Food", Amount: 100}, {To: "Home", Amount: 250}}},
+		},
+		{
+			name:   "one leg is a plain transaction",
+			splits: []Split{{To: "Wise", Amount: 8325, ToAmount: 100}},
+			want:   Transaction{To: "Wise", Amount: 8325, ToAmount: 100},
+		},
+		{
+			name:   "leg names are sanitized",
+			splits: []Split{{To: "=Food", Amount: 1}, {To: "A&B", Amount: 2}},
+			want:   Transaction{Amount: 3, Splits: []Split{{To: "'=Food", Amount: 1}, {To: "A&amp;B", Amount: 2}}},
+		},
+		{name: "missing account", splits: []Split{{To: "Food", Amount: 1}, {Amount: 2}}, err: "needs a to account"},
+		{name: "zero leg", splits: []Split{{To: "Food", Amount: 1}, {To: "Home"}}, err: "must be positive"},
+		{name: "negative leg", splits: []Split{{To: "Food", Amount: 5}, {To: "Home", Amount: -1}}, err: "must be positive"},
+		// Without a bound on each leg these would wrap around to 1
+		{
+			name:   "overflowing legs",
+			splits: []Split{{To: "Food", Amount: math.MaxInt64}, {To: "Home", Amount: math.MaxInt64}, {To: "Rent", Amount: 3}},
+			err:    "too large",
+		},
+		{name: "too many legs", splits: make([]Split, 51), err: "too many split legs"},
+	}
+
+	for _, tt := range tests {
+		tran := Transaction{To: "Food", Amount: 999, Splits: tt.splits}
+		err := normalizeSplits(&tran)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(tran, tt.want) {
+			t.Errorf("%s: normalized to %+v, %v, want %+v", tt.name, tran, err, tt.want)
+		}
+	}
+
+	// The sum of legs is checked against the limit like any amount
+	tran := Transaction{
+		TranDate: "16-10-2026", TranTime: "10:00", From: "HDFC",
+		Splits: []Split{{To: "Food", Amount: MAX_AMOUNT}, {To: "Home", Amount: 1}},
+	}
+	if err := validateTransactionWith(&tran, nil); err == nil || err.Error() != "amount too large" {
+		t.Errorf("legs summing past the limit: error = %v", err)
+	}
+}
+
+// headerRow builds a row for header from the named values
+func headerRow(header []string, values map[string]string) []string {
+	row := make([]string, len(header))
+	for i, column := range header {
+		row[i] = values[column]
+	}
+	return row
+}
+
+// seedAccountReferences fills every table that holds account names with
+// rows using old, plus rows that merely mention it
+func seedAccountReferences(t *testing.T, old string) {
+	t.Helper()
+	accounts := []Account{{Name: old, Type: "ASSET"}, {Name: "Food", Type: "EXPENSE"}, {Name: "Home", Type: "EXPENSE"}}
+	if err := store.WriteAccounts(accounts); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteTransactions("2025", []Transaction{
+		{ID: "1", TranDate: "31-12-2025", From: old, To: "Food", Description: old, Amount: 100},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteTransactions("2026", []Transaction{
+		{ID: "2", TranDate: "01-10-2026", From: "Food", To: old, Amount: 100},
+		{ID: "3", TranDate: "02-10-2026", From: "Home", Amount: 300, Splits: []Split{{To: "Food", Amount: 100}, {To: old, Amount: 200}}},
+		{ID: "4", TranDate: "03-10-2026", From: "Home", To: "Food", Amount: 100},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := map[string]Table{
+		"recurring": {Header: recurringHeader, Rows: [][]string{
+			headerRow(recurringHeader, map[string]string{"ID": "r1", "From": old, "To": "Food", "Description": old}),
+			headerRow(recurringHeader, map[string]string{"ID": "r2", "From": "Food", "To": old}),
+		}},
+		"rules": {Header: ruleHeader, Rows: [][]string{
+			headerRow(ruleHeader, map[string]string{"Name": "a", "Pattern": old, "Account": old, "Target": "Food"}),
+			headerRow(ruleHeader, map[string]string{"Name": "b", "Target": old}),
+		}},
+		"budgets": {Header: budgetHeader, Rows: [][]string{
+			headerRow(budgetHeader, map[string]string{"Account": old, "Month": "10-2026", "Amount": "10.00"}),
+		}},
+		"allocations": {Header: allocationHeader, Rows: [][]string{
+			headerRow(allocationHeader, map[string]string{"ID": "a1", "Month": "10-2026", "From": old, "To": "Food", "Amount": "5.00", "Note": old}),
+			headerRow(allocationHeader, map[string]string{"ID": "a2", "Month": "10-2026", "To": old, "Amount": "5.00"}),
+		}},
+		"import_profiles": {Header: importProfileHeader, Rows: [][]string{
+			headerRow(importProfileHeader, map[string]string{"Name": old, "Account": old, "DefaultAccount": "Food"}),
+			headerRow(importProfileHeader, map[string]string{"Name": "p2", "Account": "Home", "DefaultAccount": old}),
+		}},
+	}
+	for name, table := range tables {
+		if err := store.WriteTable(name, table.Header, table.Rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// accountColumnValues returns every stored value that names an account
+func accountColumnValues(t *testing.T) []string {
+	t.Helper()
+	transactions, err := readAllTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tran := range transactions {
+		names = append(names, tran.From)
+		names = append(names, legAccounts(tran)...)
+	}
+	// In a fixed order, so the values line up between calls
+	for _, name := range storeTables {
+		rows, cols, err := store.ReadTable(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			for _, column := range accountColumns[name] {
+				names = append(names, columnValue(row, cols, column))
+			}
+		}
+	}
+	return names
+}
+
+func TestRenameAccountReferences(t *testing.T) {
+	useTestStore(t)
+	seedAccountReferences(t, "Old")
+
+	before := accountColumnValues(t)
+	accounts := []Account{{Name: "New", Type: "ASSET"}, {Name: "Food", Type: "EXPENSE"}, {Name: "Home", Type: "EXPENSE"}}
+	if err := renameAccountReferences("Old", "New", accounts); err != nil {
+		t.Fatal(err)
+	}
+	after := accountColumnValues(t)
+
+	if len(after) != len(before) {
+		t.Fatalf("%d account references after the rename, want %d", len(after), len(before))
+	}
+	renamed := 0
+	for i := range before {
+		want := before[i]
+		if want == "Old" {
+			want = "New"
+			renamed++
+		}
+		if after[i] != want {
+			t.Errorf("reference %d is %q, want %q", i, after[i], want)
+		}
+	}
+	// From, To and a split leg over two years, then the table rows
+	if renamed != 3+2+2+1+2+2 {
+		t.Errorf("%d references renamed", renamed)
+	}
+	if n, err := countAccountReferences("Old"); err != nil || n != 0 {
+		t.Errorf("references to Old after the rename = %d, %v", n, err)
+	}
+	if got, err := readAccounts(); err != nil || findAccount(got, "Old").Name != "" || findAccount(got, "New").Name == "" {
+		t.Errorf("accounts after the rename = %+v, %v", got, err)
+	}
+
+	// Columns that only mention the name keep it
+	transactions, err := store.ReadTransactions("2025")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transactions[0].Description != "Old" {
+		t.Errorf("description renamed to %q", transactions[0].Description)
+	}
+	rules, cols, err := store.ReadTable("rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := columnValue(rules[0], cols, "Pattern"); p != "Old" {
+		t.Errorf("rule pattern renamed to %q", p)
+	}
+	profiles, cols, err := store.ReadTable("import_profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := columnValue(profiles[0], cols, "Name"); n != "Old" {
+		t.Errorf("import profile name renamed to %q", n)
+	}
+}
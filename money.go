@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount in minor units (paise, cents). Sums of Money
+// never drift the way float64 does; values are written with two decimals,
+// the same "%.2f" format the CSV files have always used.
+type Money int64
+
+// MoneyUnit is one whole unit of currency
+const MoneyUnit Money = 100
+
+// MAX_AMOUNT is the largest amount accepted from users (999,999,999.99)
+const MAX_AMOUNT Money = 99999999999
+
+var moneyPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+var errInvalidMoney = errors.New("invalid amount")
+
+var errMoneyOverflow = errors.New("amount out of range")
+
+// ParseMoney parses a decimal string such as "1250", "-12.5" or "0.01".
+// The value is converted exactly; digits beyond the second decimal are
+// rounded half away from zero.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if !moneyPattern.MatchString(s) {
+		return 0, errInvalidMoney
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, errInvalidMoney
+	}
+	r.Mul(r, big.NewRat(int64(MoneyUnit), 1))
+	return roundMinorUnits(r)
+}
+
+// Convert multiplies the amount by an exchange rate, rounding half away
+// from zero to the nearest minor unit. A result too large for Money gives
+// errMoneyOverflow.
+func (m Money) Convert(rate *big.Rat) (Money, error) {
+	return roundMinorUnits(new(big.Rat).Mul(new(big.Rat).SetInt64(int64(m)), rate))
+}
+
+// roundMinorUnits rounds a value already in minor units half away from zero
+func roundMinorUnits(r *big.Rat) (Money, error) {
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+	neg := num.Sign() < 0
+	num.Abs(num)
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+	if neg {
+		quo.Neg(quo)
+	}
+
+	if !quo.IsInt64() {
+		return 0, errMoneyOverflow
+	}
+	return Money(quo.Int64()), nil
+}
+
+// String formats the amount with exactly two decimals, e.g. "-12.50"
+func (m Money) String() string {
+	sign := ""
+	// Unsigned, so the smallest Money has a magnitude too
+	v := uint64(m)
+	if m < 0 {
+		sign = "-"
+		v = -v
+	}
+	unit := uint64(MoneyUnit)
+	frac := strconv.FormatUint(v%unit, 10)
+	if len(frac) < 2 {
+		frac = "0" + frac
+	}
+	return sign + strconv.FormatUint(v/unit, 10) + "." + frac
+}
+
+// Float64 is for ratios and charts only; never add the result back up
+func (m Money) Float64() float64 {
+	return float64(m) / float64(MoneyUnit)
+}
+
+// MarshalJSON writes the amount as a plain JSON number with two decimals
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string. Numbers are read
+// from their literal text, so 0.1 is exactly ten paise.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		*m = 0
+		return nil
+	}
+	s := string(data)
+	if len(data) >= 2 && data[0] == '"' {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return errInvalidMoney
+		}
+		s = unquoted
+	}
+
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Decimal is an exact number with six decimal places, for security units
+// and unit prices, which need more precision than Money
+type Decimal int64
+
+// DecimalUnit is the Decimal value of one
+const DecimalUnit Decimal = 1000000
+
+var errInvalidDecimal = errors.New("invalid number")
+
+// ParseDecimal parses a decimal string such as "12", "0.5" or "-3.125",
+// rounding beyond the sixth decimal half away from zero
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if !moneyPattern.MatchString(s) {
+		return 0, errInvalidDecimal
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, errInvalidDecimal
+	}
+	r.Mul(r, big.NewRat(int64(DecimalUnit), 1))
+	v, err := roundMinorUnits(r)
+	if err != nil {
+		return 0, errInvalidDecimal
+	}
+	return Decimal(v), nil
+}
+
+// Rat returns the exact value
+func (d Decimal) Rat() *big.Rat {
+	return big.NewRat(int64(d), int64(DecimalUnit))
+}
+
+// Abs returns the value without its sign
+func (d Decimal) Abs() Decimal {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// String formats the value without trailing zeros, e.g. "10.5" or "-3"
+func (d Decimal) String() string {
+	sign := ""
+	v := int64(d)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	unit := int64(DecimalUnit)
+	s := sign + strconv.FormatInt(v/unit, 10)
+	if frac := v % unit; frac != 0 {
+		digits := strconv.FormatInt(frac+unit, 10)[1:]
+		s += "." + strings.TrimRight(digits, "0")
+	}
+	return s
+}
+
+// MarshalJSON writes the value as a plain JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		*d = 0
+		return nil
+	}
+	s := string(data)
+	if len(data) >= 2 && data[0] == '"' {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return errInvalidDecimal
+		}
+		s = unquoted
+	}
+
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
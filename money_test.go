@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "0", want: 0},
+		{in: "1250", want: 125000},
+		{in: "  7  ", want: 700},
+		{in: "+3", want: 300},
+		{in: "-12.5", want: -1250},
+		{in: "0.01", want: 1},
+		{in: ".5", want: 50},
+		{in: "1.", want: 100},
+
+		// Exponents
+		{in: "1e3", want: 100000},
+		{in: "2.5E+2", want: 25000},
+		{in: "1.5e-2", want: 2},
+		{in: "-1.5e-2", want: -2},
+
+		// More than two decimals round half away from zero
+		{in: "0.125", want: 13},
+		{in: "-0.125", want: -13},
+		{in: "0.124999", want: 12},
+		{in: "0.1234567", want: 12},
+		{in: "2.0050000001", want: 201},
+		{in: "-2.0049999999", want: -200},
+
+		// The largest Money and one minor unit past it
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "92233720368547758.08", wantErr: true},
+		{in: "-92233720368547758.09", wantErr: true},
+		{in: "1e30", wantErr: true},
+
+		{in: "abc", wantErr: true},
+		{in: "1,000", wantErr: true},
+		{in: "--1", wantErr: true},
+		{in: "0x10", wantErr: true},
+		{in: "1e", wantErr: true},
+		{in: ".", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMoney(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMoney(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{1, "0.01"},
+		{-1, "-0.01"},
+		{10, "0.10"},
+		{-1250, "-12.50"},
+		{125000, "1250.00"},
+		{MAX_AMOUNT, "999999999.99"},
+		{math.MaxInt64, "92233720368547758.07"},
+		{-math.MaxInt64, "-92233720368547758.07"},
+		{math.MinInt64, "-92233720368547758.08"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+		// What String writes, ParseMoney reads back
+		if back, err := ParseMoney(tt.want); err != nil || back != tt.in {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", tt.want, back, err, tt.in)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	marshal := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{1250, "12.50"},
+		{-5, "-0.05"},
+	}
+	for _, tt := range marshal {
+		got, err := json.Marshal(tt.in)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("json.Marshal(Money(%d)) = %s, %v, want %s", int64(tt.in), got, err, tt.want)
+		}
+	}
+
+	unmarshal := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "12.5", want: 1250},
+		{in: "0.1", want: 10},
+		{in: "1e2", want: 10000},
+		{in: "-0.005", want: -1},
+		{in: `"12.50"`, want: 1250},
+		{in: `" 3 "`, want: 300},
+		{in: "null", want: 0},
+		{in: `"abc"`, wantErr: true},
+		{in: "1e30", wantErr: true},
+		{in: "true", wantErr: true},
+	}
+	for _, tt := range unmarshal {
+		var got Money
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("json.Unmarshal(%s) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	// Amounts inside a struct survive a round trip exactly
+	type row struct {
+		Amount Money `json:"amount"`
+	}
+	for _, m := range []Money{0, 1, -1, 10, 99, 123456789, -MAX_AMOUNT, math.MaxInt64} {
+		data, err := json.Marshal(row{Amount: m})
+		if err != nil {
+			t.Fatalf("json.Marshal(%d): %v", int64(m), err)
+		}
+		var back row
+		if err := json.Unmarshal(data, &back); err != nil || back.Amount != m {
+			t.Errorf("round trip of %d via %s = %d, %v", int64(m), data, back.Amount, err)
+		}
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		in      Money
+		rate    *big.Rat
+		want    Money
+		wantErr bool
+	}{
+		{in: 10000, rate: big.NewRat(8325, 100), want: 832500},
+		{in: 1, rate: big.NewRat(1, 3), want: 0},
+		{in: 2, rate: big.NewRat(1, 3), want: 1},
+		{in: 1, rate: big.NewRat(1, 2), want: 1},
+		{in: -1, rate: big.NewRat(1, 2), want: -1},
+		{in: math.MaxInt64, rate: big.NewRat(1, 1), want: math.MaxInt64},
+		{in: math.MaxInt64, rate: big.NewRat(2, 1), want: 0, wantErr: true},
+		{in: math.MinInt64, rate: big.NewRat(2, 1), want: 0, wantErr: true},
+		{in: math.MaxInt64, rate: big.NewRat(-2, 1), want: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.in.Convert(tt.rate)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Money(%d).Convert(%s) error = %v, want error %v", int64(tt.in), tt.rate, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("Money(%d).Convert(%s) = %d, want %d", int64(tt.in), tt.rate, got, tt.want)
+		}
+	}
+}
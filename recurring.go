@@ -0,0 +1,676 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recurring transaction templates are stored in recurring.csv. A template
+// records its schedule and how many occurrences are done (posted or
+// skipped); the next due date is always derived from those two, so the
+// file never needs a separate cursor.
+
+const (
+	FREQ_DAILY   = "DAILY"
+	FREQ_WEEKLY  = "WEEKLY"
+	FREQ_MONTHLY = "MONTHLY"
+	FREQ_YEARLY  = "YEARLY"
+
+	// Occurrences posted per template per run, so a template started far
+	// in the past cannot flood the ledger in one go
+	MAX_RECURRING_CATCHUP = 400
+)
+
+var recurringHeader = []string{
+	"ID", "From", "To", "Description", "Amount", "TranTime",
+	"Frequency", "Interval", "StartDate", "Week", "Weekday", "EndDate", "Count", "Done",
+}
+
+var weekdays = map[string]time.Weekday{
+	"SUN": time.Sunday, "MON": time.Monday, "TUE": time.Tuesday, "WED": time.Wednesday,
+	"THU": time.Thursday, "FRI": time.Friday, "SAT": time.Saturday,
+}
+
+var (
+	errRecurringNotFound = errors.New("recurring transaction not found")
+	errRecurringFinished = errors.New("recurring transaction has no further occurrences")
+)
+
+var recurringMutex sync.Mutex
+
+type Recurring struct {
+	ID          string `json:"id"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Description string `json:"description"`
+	Amount      Money  `json:"amount"`
+	TranTime    string `json:"tranTime"`
+	Frequency   string `json:"frequency"`
+	Interval    int    `json:"interval"`
+	StartDate   string `json:"startDate"`
+	Week        int    `json:"week,omitempty"`    // MONTHLY only: 1-5, or -1 for the last
+	Weekday     string `json:"weekday,omitempty"` // SUN-SAT, used with Week
+	EndDate     string `json:"endDate,omitempty"`
+	Count       int    `json:"count,omitempty"` // 0 repeats until EndDate or forever
+	Done        int    `json:"done"`
+	NextDate    string `json:"nextDate"` // derived, not stored
+}
+
+// occurrence returns the date of the n-th occurrence (from 0), or false once
+// the schedule has ended
+func (rt Recurring) occurrence(n int) (time.Time, bool) {
+	if rt.Count > 0 && n >= rt.Count {
+		return time.Time{}, false
+	}
+
+	start, err := time.Parse("02-01-2006", rt.StartDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// An nth-weekday schedule may fall before the start date in the first
+	// month; that occurrence does not count
+	if rt.Frequency == FREQ_MONTHLY && rt.Week != 0 && rt.scheduled(start, 0).Before(start) {
+		n++
+	}
+
+	date := rt.scheduled(start, n)
+	if rt.EndDate != "" {
+		end, err := time.Parse("02-01-2006", rt.EndDate)
+		if err == nil && date.After(end) {
+			return time.Time{}, false
+		}
+	}
+	return date, true
+}
+
+// firstOnOrAfter returns the first occurrence from n on that is not before
+// date, or one past the last occurrence. Dates only move forward with n, so
+// it doubles the step until it passes date and then bisects, rather than
+// walking every occurrence of a schedule that started long ago.
+func (rt Recurring) firstOnOrAfter(n int, date time.Time) int {
+	before := func(i int) bool {
+		d, ok := rt.occurrence(i)
+		return ok && d.Before(date)
+	}
+	if !before(n) {
+		return n
+	}
+	lo, step := n, 1
+	for before(lo + step) {
+		lo += step
+		step *= 2
+	}
+	// lo is before date and lo+step is not
+	return lo + 1 + sort.Search(step-1, func(i int) bool { return !before(lo + 1 + i) })
+}
+
+func (rt Recurring) scheduled(start time.Time, n int) time.Time {
+	interval := rt.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	switch rt.Frequency {
+	case FREQ_DAILY:
+		return start.AddDate(0, 0, n*interval)
+	case FREQ_WEEKLY:
+		return start.AddDate(0, 0, 7*n*interval)
+	case FREQ_MONTHLY:
+		first := time.Date(start.Year(), start.Month()+time.Month(n*interval), 1, 0, 0, 0, 0, time.UTC)
+		if rt.Week != 0 {
+			return nthWeekday(first.Year(), first.Month(), rt.Week, weekdays[rt.Weekday])
+		}
+		return clampDay(first.Year(), first.Month(), start.Day())
+	default: // FREQ_YEARLY
+		return clampDay(start.Year()+n*interval, start.Month(), start.Day())
+	}
+}
+
+// clampDay keeps the day of month, moving the 29th-31st back to the last
+// day of shorter months
+func clampDay(year int, month time.Month, day int) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// nthWeekday returns e.g. the 2nd Tuesday (week 2) or the last Friday
+// (week -1) of a month. A 5th weekday that does not exist becomes the last.
+func nthWeekday(year int, month time.Month, week int, day time.Weekday) time.Time {
+	if week < 0 {
+		last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+		return last.AddDate(0, 0, -((int(last.Weekday()) - int(day) + 7) % 7))
+	}
+
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	date := first.AddDate(0, 0, (int(day)-int(first.Weekday())+7)%7+(week-1)*7)
+	if date.Month() != month {
+		return nthWeekday(year, month, -1, day)
+	}
+	return date
+}
+
+// transaction builds the ledger entry for the n-th occurrence. The ID is
+// derived from the template and occurrence so a retried post never
+// duplicates an entry. Names and description are unescaped, since
+// validateTransaction escapes them again.
+func (rt Recurring) transaction(n int, date time.Time) Transaction {
+	sum := sha256.Sum256([]byte(rt.ID + ":" + strconv.Itoa(n)))
+	return Transaction{
+		ID:          hex.EncodeToString(sum[:TRANSACTION_ID_LENGTH]),
+		TranDate:    date.Format("02-01-2006"),
+		TranTime:    rt.TranTime,
+		From:        html.UnescapeString(rt.From),
+		To:          html.UnescapeString(rt.To),
+		Description: html.UnescapeString(rt.Description),
+		Amount:      rt.Amount,
+	}
+}
+
+func (rt *Recurring) setNextDate() {
+	rt.NextDate = ""
+	if date, ok := rt.occurrence(rt.Done); ok {
+		rt.NextDate = date.Format("02-01-2006")
+	}
+}
+
+func validateRecurring(rt *Recurring) error {
+	rt.From = sanitizeInput(rt.From)
+	rt.To = sanitizeInput(rt.To)
+	rt.Description = sanitizeInput(rt.Description)
+	rt.Frequency = strings.ToUpper(sanitizeInput(rt.Frequency))
+	rt.Weekday = strings.ToUpper(sanitizeInput(rt.Weekday))
+
+	if rt.From == "" || rt.To == "" {
+		return errors.New("from and to accounts required")
+	}
+	if len(rt.Description) == 0 || len(rt.Description) > 100 {
+		return errors.New("description must be 1-100 characters")
+	}
+	if rt.Amount <= 0 || rt.Amount > MAX_AMOUNT {
+		return errors.New("amount must be between 0 and 999,999,999.99")
+	}
+	if rt.TranTime == "" {
+		rt.TranTime = "00:00"
+	}
+	if !isValidTime(rt.TranTime) {
+		return errors.New("invalid time format")
+	}
+	if !isValidDate(rt.StartDate) {
+		return errors.New("invalid start date")
+	}
+	if rt.EndDate != "" && !isValidDate(rt.EndDate) {
+		return errors.New("invalid end date")
+	}
+
+	switch rt.Frequency {
+	case FREQ_DAILY, FREQ_WEEKLY, FREQ_MONTHLY, FREQ_YEARLY:
+	default:
+		return errors.New("frequency must be DAILY, WEEKLY, MONTHLY or YEARLY")
+	}
+	if rt.Interval == 0 {
+		rt.Interval = 1
+	}
+	if rt.Interval < 1 || rt.Interval > 366 {
+		return errors.New("interval must be between 1 and 366")
+	}
+
+	if rt.Week != 0 || rt.Weekday != "" {
+		if rt.Frequency != FREQ_MONTHLY {
+			return errors.New("week and weekday apply to MONTHLY schedules only")
+		}
+		if rt.Week < -1 || rt.Week > 5 {
+			return errors.New("week must be 1-5, or -1 for the last")
+		}
+		if _, ok := weekdays[rt.Weekday]; !ok || rt.Week == 0 {
+			return errors.New("week and weekday (SUN-SAT) must be given together")
+		}
+	}
+
+	if rt.Count < 0 || rt.Done < 0 {
+		return errors.New("count must not be negative")
+	}
+
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{rt.From, rt.To} {
+		if findAccount(accounts, name).Name == "" {
+			return fmt.Errorf("unknown account: %s", name)
+		}
+	}
+	return nil
+}
+
+func readRecurring() ([]Recurring, error) {
+	rows, cols, err := store.ReadTable("recurring")
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []Recurring
+	for _, record := range rows {
+		amount, _ := ParseMoney(columnValue(record, cols, "Amount"))
+		interval, _ := strconv.Atoi(columnValue(record, cols, "Interval"))
+		week, _ := strconv.Atoi(columnValue(record, cols, "Week"))
+		count, _ := strconv.Atoi(columnValue(record, cols, "Count"))
+		done, _ := strconv.Atoi(columnValue(record, cols, "Done"))
+
+		rt := Recurring{
+			ID:          columnValue(record, cols, "ID"),
+			From:        columnValue(record, cols, "From"),
+			To:          columnValue(record, cols, "To"),
+			Description: columnValue(record, cols, "Description"),
+			Amount:      amount,
+			TranTime:    columnValue(record, cols, "TranTime"),
+			Frequency:   columnValue(record, cols, "Frequency"),
+			Interval:    interval,
+			StartDate:   columnValue(record, cols, "StartDate"),
+			Week:        week,
+			Weekday:     columnValue(record, cols, "Weekday"),
+			EndDate:     columnValue(record, cols, "EndDate"),
+			Count:       count,
+			Done:        done,
+		}
+		rt.setNextDate()
+		templates = append(templates, rt)
+	}
+	return templates, nil
+}
+
+func writeRecurring(templates []Recurring) error {
+	rows := make([][]string, 0, len(templates))
+	for _, rt := range templates {
+		rows = append(rows, []string{
+			rt.ID,
+			rt.From,
+			rt.To,
+			rt.Description,
+			rt.Amount.String(),
+			rt.TranTime,
+			rt.Frequency,
+			strconv.Itoa(rt.Interval),
+			rt.StartDate,
+			strconv.Itoa(rt.Week),
+			rt.Weekday,
+			rt.EndDate,
+			strconv.Itoa(rt.Count),
+			strconv.Itoa(rt.Done),
+		})
+	}
+	return store.WriteTable("recurring", recurringHeader, rows)
+}
+
+func findRecurring(templates []Recurring, id string) int {
+	for i, rt := range templates {
+		if rt.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// postDueRecurring posts every occurrence due on or before today and
+// returns how many transactions were added
+func postDueRecurring(today time.Time) (int, error) {
+	recurringMutex.Lock()
+	defer recurringMutex.Unlock()
+
+	templates, err := readRecurring()
+	if err != nil || len(templates) == 0 {
+		return 0, err
+	}
+
+	transactions, err := readAllTransactions()
+	if err != nil {
+		return 0, err
+	}
+	accounts, err := readAccounts()
+	if err != nil {
+		return 0, err
+	}
+
+	existing := make(map[string]bool, len(transactions))
+	for _, t := range transactions {
+		existing[t.ID] = true
+	}
+
+	posted := 0
+	changed := false
+	for i := range templates {
+		rt := &templates[i]
+		for n := 0; n < MAX_RECURRING_CATCHUP; n++ {
+			date, ok := rt.occurrence(rt.Done)
+			if !ok || date.After(today) {
+				break
+			}
+
+			tran := rt.transaction(rt.Done, date)
+			if !existing[tran.ID] {
+				// e.g. an account closed since; the template waits
+				// until it is fixed
+				if err := validateTransactionWith(&tran, accounts); err != nil {
+					log.Printf("Recurring %s: %v", rt.ID, err)
+					break
+				}
+				if err := addTransaction(tran); err != nil {
+					if changed {
+						writeRecurring(templates)
+					}
+					return posted, err
+				}
+				posted++
+			}
+			rt.Done++
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := writeRecurring(templates); err != nil {
+			return posted, err
+		}
+	}
+	return posted, nil
+}
+
+// runRecurringBatch posts due occurrences and refreshes balances
+func runRecurringBatch(logger *log.Logger) {
+	if readOnlyMode {
+		return
+	}
+
+	posted, err := postDueRecurring(today())
+	if err != nil {
+		logger.Printf("Error posting recurring transactions: %v", err)
+	}
+	if posted > 0 {
+		logger.Printf("Posted %d recurring transactions", posted)
+		if err := recalculateAllData(); err != nil {
+			logger.Printf("Error recalculating data: %v", err)
+		}
+	}
+}
+
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func handleRecurring(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		templates, err := readRecurring()
+		if err != nil {
+			respondError(w, "Failed to load recurring transactions", http.StatusInternalServerError)
+			return
+		}
+		if templates == nil {
+			templates = []Recurring{}
+		}
+		json.NewEncoder(w).Encode(templates)
+
+	case http.MethodPost:
+		var rt Recurring
+		if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+
+		rt.Done = 0
+		if err := validateRecurring(&rt); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		id, err := newTransactionID()
+		if err != nil {
+			respondError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		rt.ID = id
+
+		recurringMutex.Lock()
+		templates, err := readRecurring()
+		if err == nil {
+			err = writeRecurring(append(templates, rt))
+		}
+		recurringMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to add recurring transaction", http.StatusInternalServerError)
+			return
+		}
+
+		// A start date of today or earlier posts straight away
+		runRecurringBatch(log.Default())
+
+		logSecurityEvent("RECURRING_ADD", getClientIP(r), fmt.Sprintf("Added recurring transaction %s: %s", rt.ID, rt.Description))
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": rt.ID})
+
+	case http.MethodPut:
+		var rt Recurring
+		if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+
+		rt.ID = sanitizeInput(rt.ID)
+		if rt.ID == "" {
+			respondError(w, "Recurring transaction ID required", http.StatusBadRequest)
+			return
+		}
+		if err := validateRecurring(&rt); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		recurringMutex.Lock()
+		templates, err := readRecurring()
+		if err == nil {
+			i := findRecurring(templates, rt.ID)
+			if i < 0 {
+				err = errRecurringNotFound
+			} else {
+				// Occurrences already done stay done
+				rt.Done = templates[i].Done
+				templates[i] = rt
+				err = writeRecurring(templates)
+			}
+		}
+		recurringMutex.Unlock()
+		if errors.Is(err, errRecurringNotFound) {
+			respondError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			respondError(w, "Failed to update recurring transaction", http.StatusInternalServerError)
+			return
+		}
+
+		runRecurringBatch(log.Default())
+
+		logSecurityEvent("RECURRING_UPDATE", getClientIP(r), fmt.Sprintf("Updated recurring transaction: %s", rt.ID))
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	case http.MethodDelete:
+		var data map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+		id := sanitizeInput(data["id"])
+
+		recurringMutex.Lock()
+		templates, err := readRecurring()
+		if err == nil {
+			i := findRecurring(templates, id)
+			if i < 0 {
+				err = errRecurringNotFound
+			} else {
+				err = writeRecurring(append(templates[:i], templates[i+1:]...))
+			}
+		}
+		recurringMutex.Unlock()
+		if errors.Is(err, errRecurringNotFound) {
+			respondError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			respondError(w, "Failed to delete recurring transaction", http.StatusInternalServerError)
+			return
+		}
+
+		// Transactions already posted stay in the ledger
+		logSecurityEvent("RECURRING_DELETE", getClientIP(r), fmt.Sprintf("Deleted recurring transaction: %s", id))
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRecurringUpcoming lists occurrences due from today through the next
+// `days` days (default 30, at most 366). Overdue occurrences are left to
+// the daily batch.
+func handleRecurringUpcoming(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := 30
+	if d := r.URL.Query().Get("days"); d != "" {
+		var err error
+		days, err = strconv.Atoi(d)
+		if err != nil || days < 1 || days > 366 {
+			respondError(w, "days must be between 1 and 366", http.StatusBadRequest)
+			return
+		}
+	}
+
+	templates, err := readRecurring()
+	if err != nil {
+		respondError(w, "Failed to load recurring transactions", http.StatusInternalServerError)
+		return
+	}
+
+	from := today()
+	horizon := from.AddDate(0, 0, days)
+	upcoming := []map[string]interface{}{}
+	for _, rt := range templates {
+		for n := rt.firstOnOrAfter(rt.Done, from); ; n++ {
+			date, ok := rt.occurrence(n)
+			if !ok || date.After(horizon) {
+				break
+			}
+			upcoming = append(upcoming, map[string]interface{}{
+				"id":          rt.ID,
+				"date":        date.Format("02-01-2006"),
+				"tranTime":    rt.TranTime,
+				"from":        rt.From,
+				"to":          rt.To,
+				"description": rt.Description,
+				"amount":      rt.Amount,
+				"occurrence":  n + 1,
+			})
+		}
+	}
+
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return compareDates(upcoming[j]["date"].(string), upcoming[i]["date"].(string))
+	})
+
+	json.NewEncoder(w).Encode(upcoming)
+}
+
+// handleRecurringAction handles POST /api/recurring/skip, which drops the
+// next occurrence, and POST /api/recurring/post, which posts it today
+func handleRecurringAction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		respondError(w, "Invalid request data", http.StatusBadRequest)
+		return
+	}
+	id := sanitizeInput(data["id"])
+	post := strings.HasSuffix(r.URL.Path, "/post")
+
+	recurringMutex.Lock()
+	templates, err := readRecurring()
+	var tran Transaction
+	var invalid error
+	if err == nil {
+		i := findRecurring(templates, id)
+		if i < 0 {
+			err = errRecurringNotFound
+		} else if _, ok := templates[i].occurrence(templates[i].Done); !ok {
+			err = errRecurringFinished
+		} else {
+			rt := &templates[i]
+			if post {
+				tran = rt.transaction(rt.Done, today())
+				if invalid = validateTransaction(&tran); invalid == nil {
+					err = addTransaction(tran)
+				}
+			}
+			if err == nil && invalid == nil {
+				rt.Done++
+				err = writeRecurring(templates)
+			}
+		}
+	}
+	recurringMutex.Unlock()
+
+	switch {
+	case errors.Is(err, errRecurringNotFound):
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errRecurringFinished):
+		respondError(w, err.Error(), http.StatusConflict)
+		return
+	case invalid != nil:
+		// e.g. an account the template uses has since been closed
+		respondError(w, invalid.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		respondError(w, "Failed to update recurring transaction", http.StatusInternalServerError)
+		return
+	}
+
+	if post {
+		if err := recalculateAllData(); err != nil {
+			log.Printf("Error recalculating data: %v", err)
+		}
+		logSecurityEvent("RECURRING_POST", getClientIP(r), fmt.Sprintf("Posted recurring transaction %s early as %s", id, tran.ID))
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "transactionId": tran.ID})
+		return
+	}
+
+	logSecurityEvent("RECURRING_SKIP", getClientIP(r), fmt.Sprintf("Skipped next occurrence of %s", id))
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
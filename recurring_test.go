@@ -0,0 +1,264 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustDate(s string) time.Time {
+	d, err := time.Parse("02-01-2006", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestNthWeekday(t *testing.T) {
+	tests := []struct {
+		year  int
+		month time.Month
+		week  int
+		day   time.Weekday
+		want  string
+	}{
+		{2026, time.October, 1, time.Thursday, "01-10-2026"}, // the 1st is a Thursday
+		{2026, time.October, 1, time.Friday, "02-10-2026"},
+		{2026, time.October, 1, time.Wednesday, "07-10-2026"},
+		{2026, time.October, 2, time.Tuesday, "13-10-2026"},
+		{2026, time.October, 5, time.Friday, "30-10-2026"},
+		{2026, time.October, -1, time.Saturday, "31-10-2026"}, // the 31st is a Saturday
+		{2026, time.October, -1, time.Friday, "30-10-2026"},
+		{2026, time.October, -1, time.Sunday, "25-10-2026"},
+		// There is no 5th Monday; it becomes the last
+		{2026, time.October, 5, time.Monday, "26-10-2026"},
+		{2026, time.February, 5, time.Sunday, "22-02-2026"},
+		{2024, time.February, -1, time.Thursday, "29-02-2024"},
+	}
+
+	for _, tt := range tests {
+		got := nthWeekday(tt.year, tt.month, tt.week, tt.day)
+		if got.Format("02-01-2006") != tt.want {
+			t.Errorf("nthWeekday(%d, %s, %d, %s) = %s, want %s",
+				tt.year, tt.month, tt.week, tt.day, got.Format("02-01-2006"), tt.want)
+		}
+	}
+}
+
+func TestClampDay(t *testing.T) {
+	tests := []struct {
+		year  int
+		month time.Month
+		day   int
+		want  string
+	}{
+		{2026, time.March, 15, "15-03-2026"},
+		{2026, time.March, 31, "31-03-2026"},
+		{2026, time.April, 31, "30-04-2026"},
+		{2026, time.February, 30, "28-02-2026"},
+		{2024, time.February, 31, "29-02-2024"},
+		{2100, time.February, 29, "28-02-2100"}, // not a leap year
+		{2026, time.December, 31, "31-12-2026"},
+	}
+
+	for _, tt := range tests {
+		got := clampDay(tt.year, tt.month, tt.day)
+		if got.Format("02-01-2006") != tt.want {
+			t.Errorf("clampDay(%d, %s, %d) = %s, want %s", tt.year, tt.month, tt.day, got.Format("02-01-2006"), tt.want)
+		}
+	}
+}
+
+func TestRecurringOccurrence(t *testing.T) {
+	tests := []struct {
+		name string
+		rt   Recurring
+		want []string // occurrences from 0 on; "" once the schedule ends
+	}{
+		{
+			name: "daily every 3 days",
+			rt:   Recurring{Frequency: FREQ_DAILY, Interval: 3, StartDate: "30-12-2025"},
+			want: []string{"30-12-2025", "02-01-2026", "05-01-2026"},
+		},
+		{
+			name: "weekly every 2 weeks",
+			rt:   Recurring{Frequency: FREQ_WEEKLY, Interval: 2, StartDate: "01-10-2026"},
+			want: []string{"01-10-2026", "15-10-2026", "29-10-2026"},
+		},
+		{
+			// Each month is worked out from the start date, so a short
+			// month does not pull the later ones back
+			name: "monthly on the 31st",
+			rt:   Recurring{Frequency: FREQ_MONTHLY, Interval: 1, StartDate: "31-01-2024"},
+			want: []string{"31-01-2024", "29-02-2024", "31-03-2024", "30-04-2024", "31-05-2024"},
+		},
+		{
+			name: "quarterly on the 30th",
+			rt:   Recurring{Frequency: FREQ_MONTHLY, Interval: 3, StartDate: "30-11-2025"},
+			want: []string{"30-11-2025", "28-02-2026", "30-05-2026", "30-08-2026"},
+		},
+		{
+			name: "yearly on 29 February",
+			rt:   Recurring{Frequency: FREQ_YEARLY, Interval: 1, StartDate: "29-02-2024"},
+			want: []string{"29-02-2024", "28-02-2025", "28-02-2026", "28-02-2027", "29-02-2028"},
+		},
+		{
+			name: "second Tuesday",
+			rt:   Recurring{Frequency: FREQ_MONTHLY, Interval: 1, StartDate: "01-10-2026", Week: 2, Weekday: "TUE"},
+			want: []string{"13-10-2026", "10-11-2026", "08-12-2026"},
+		},
+		{
+			// The first Monday of October 2026 is before the start date,
+			// so the first occurrence is in November
+			name: "first Monday after the start",
+			rt:   Recurring{Frequency: FREQ_MONTHLY, Interval: 1, StartDate: "10-10-2026", Week: 1, Weekday: "MON"},
+			want: []string{"02-11-2026", "07-12-2026", "04-01-2027"},
+		},
+		{
+			name: "last Friday",
+			rt:   Recurring{Frequency: FREQ_MONTHLY, Interval: 1, StartDate: "01-01-2026", Week: -1, Weekday: "FRI"},
+			want: []string{"30-01-2026", "27-02-2026", "27-03-2026"},
+		},
+		{
+			name: "count",
+			rt:   Recurring{Frequency: FREQ_MONTHLY, Interval: 1, StartDate: "15-01-2026", Count: 2},
+			want: []string{"15-01-2026", "15-02-2026", ""},
+		},
+		{
+			name: "end date",
+			rt:   Recurring{Frequency: FREQ_WEEKLY, Interval: 1, StartDate: "01-10-2026", EndDate: "15-10-2026"},
+			want: []string{"01-10-2026", "08-10-2026", "15-10-2026", ""},
+		},
+		{
+			name: "bad start date",
+			rt:   Recurring{Frequency: FREQ_DAILY, Interval: 1, StartDate: "2026-10-01"},
+			want: []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		for n, want := range tt.want {
+			d, ok := tt.rt.occurrence(n)
+			got := ""
+			if ok {
+				got = d.Format("02-01-2006")
+			}
+			if got != want {
+				t.Errorf("%s: occurrence(%d) = %q, want %q", tt.name, n, got, want)
+			}
+		}
+	}
+}
+
+func TestRecurringNextDate(t *testing.T) {
+	rt := Recurring{Frequency: FREQ_MONTHLY, Interval: 1, StartDate: "31-01-2026", Count: 3}
+
+	for done, want := range []string{"31-01-2026", "28-02-2026", "31-03-2026", ""} {
+		rt.Done = done
+		rt.setNextDate()
+		if rt.NextDate != want {
+			t.Errorf("NextDate with %d done = %q, want %q", done, rt.NextDate, want)
+		}
+	}
+
+	// The posted transaction's ID depends only on the template and the
+	// occurrence, so posting it again is caught as a duplicate
+	rt.ID = "abc"
+	first := rt.transaction(1, mustDate("28-02-2026"))
+	if again := rt.transaction(1, mustDate("01-03-2026")); again.ID != first.ID {
+		t.Errorf("occurrence 1 posted with IDs %s and %s", first.ID, again.ID)
+	}
+	if other := rt.transaction(2, mustDate("28-02-2026")); other.ID == first.ID {
+		t.Errorf("occurrences 1 and 2 share ID %s", first.ID)
+	}
+}
+
+func TestRecurringFirstOnOrAfter(t *testing.T) {
+	daily := Recurring{Frequency: FREQ_DAILY, Interval: 1, StartDate: "01-01-2000"}
+	monthly := Recurring{Frequency: FREQ_MONTHLY, Interval: 1, StartDate: "31-01-2026"}
+	ended := Recurring{Frequency: FREQ_WEEKLY, Interval: 1, StartDate: "01-01-2026", Count: 5}
+
+	tests := []struct {
+		name string
+		rt   Recurring
+		done int
+		from string
+		want int
+	}{
+		{name: "already due", rt: monthly, done: 0, from: "31-01-2026", want: 0},
+		{name: "done is later", rt: monthly, done: 5, from: "01-02-2026", want: 5},
+		{name: "one month on", rt: monthly, done: 0, from: "01-02-2026", want: 1},
+		{name: "clamped day counts", rt: monthly, done: 0, from: "28-02-2026", want: 1},
+		{name: "day after clamped", rt: monthly, done: 0, from: "01-03-2026", want: 2},
+		{name: "from done", rt: monthly, done: 3, from: "16-10-2026", want: 9},
+		{name: "long ago", rt: daily, done: 0, from: "16-10-2026", want: 9785},
+		{name: "long ago, partly done", rt: daily, done: 9000, from: "16-10-2026", want: 9785},
+		{name: "ended", rt: ended, done: 2, from: "16-10-2026", want: 5},
+	}
+
+	for _, tt := range tests {
+		got := tt.rt.firstOnOrAfter(tt.done, mustDate(tt.from))
+		if got != tt.want {
+			t.Errorf("%s: firstOnOrAfter(%d, %s) = %d, want %d", tt.name, tt.done, tt.from, got, tt.want)
+		}
+		// Nothing between done and the result falls on or after the date
+		if got > tt.done {
+			if d, ok := tt.rt.occurrence(got - 1); ok && !d.Before(mustDate(tt.from)) {
+				t.Errorf("%s: occurrence %d on %s is not before %s", tt.name, got-1, d.Format("02-01-2006"), tt.from)
+			}
+		}
+	}
+}
+
+func TestPostDueRecurringClosedAccount(t *testing.T) {
+	useTestStore(t)
+
+	rent := sanitizeInput("Rent & Bills")
+	accounts := []Account{
+		{Name: "HDFC", Type: "ASSET", IINW: "Yes"},
+		{Name: rent, Type: "EXPENSE", IINW: "No", ClosedDate: "15-09-2026"},
+	}
+	if err := store.WriteAccounts(accounts); err != nil {
+		t.Fatal(err)
+	}
+	rt := Recurring{
+		ID: "rent", From: "HDFC", To: rent, Description: sanitizeInput("Rent & maintenance"), Amount: 2000000,
+		TranTime: "09:00", Frequency: FREQ_MONTHLY, Interval: 1, StartDate: "01-09-2026",
+	}
+	if err := writeRecurring([]Recurring{rt}); err != nil {
+		t.Fatal(err)
+	}
+
+	// September is before the account was closed; October is after
+	posted, err := postDueRecurring(mustDate("16-10-2026"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if posted != 1 {
+		t.Errorf("posted %d occurrences, want 1", posted)
+	}
+
+	transactions, err := readAllTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("got %d transactions, want 1: %+v", len(transactions), transactions)
+	}
+	// Stored names are escaped once, as the template holds them
+	if got := transactions[0]; got.TranDate != "01-09-2026" || got.To != rent || got.Description != rt.Description {
+		t.Errorf("posted %+v", got)
+	}
+
+	templates, err := readRecurring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if templates[0].Done != 1 || templates[0].NextDate != "01-10-2026" {
+		t.Errorf("template is at %d done, next %s; want 1, 01-10-2026", templates[0].Done, templates[0].NextDate)
+	}
+
+	// Running again posts nothing more while the account stays closed
+	if posted, err := postDueRecurring(mustDate("16-10-2026")); err != nil || posted != 0 {
+		t.Errorf("second run posted %d, %v", posted, err)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Reports are computed from the transaction files for any period, not from
+// the stored balances, so past periods can be reported exactly. Amounts
+// are in the base currency: flows at the rate on each transaction's date,
+// balances at the rate on the reporting date. Each account's line includes
+// its sub-accounts; section totals count every account once.
+//
+// Quarters and years follow the fiscal year set by fiscalYearStart (e.g.
+// 4 for April to March); the transaction files stay split by calendar
+// year.
+
+// Report period kinds
+const (
+	PERIOD_MONTH   = "month"
+	PERIOD_QUARTER = "quarter"
+	PERIOD_YEAR    = "year"
+	PERIOD_YTD     = "ytd" // from the start of the fiscal year to date
+	PERIOD_CUSTOM  = "custom"
+)
+
+// Comparison periods
+const (
+	COMPARE_PREVIOUS = "previous" // the period just before
+	COMPARE_YEAR     = "year"     // the same period a year earlier
+	COMPARE_NONE     = "none"
+)
+
+// Period is an inclusive date range (DD-MM-YYYY)
+type Period struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label"`
+}
+
+// ReportLine is one account in a report section. Amount includes the
+// account's sub-accounts.
+type ReportLine struct {
+	Account  string `json:"account"`
+	Path     string `json:"path"` // e.g. "Food:Groceries"
+	Amount   Money  `json:"amount"`
+	Previous Money  `json:"previous"`
+	Change   Money  `json:"change"`
+}
+
+// ReportSection groups the accounts of one type
+type ReportSection struct {
+	Type     string       `json:"type"`
+	Accounts []ReportLine `json:"accounts"`
+	Total    Money        `json:"total"`
+	Previous Money        `json:"previous"`
+	Change   Money        `json:"change"`
+}
+
+// fiscalYearStart returns the month the fiscal year starts in
+func fiscalYearStart() time.Month {
+	month, err := strconv.Atoi(getSetting("fiscalYearStart"))
+	if err != nil || month < 1 || month > 12 {
+		return time.January
+	}
+	return time.Month(month)
+}
+
+// fiscalYear returns the first day of the fiscal year containing date and
+// its label: "2026" for calendar years, "FY 2026-27" otherwise
+func fiscalYear(date time.Time, start time.Month) (time.Time, string) {
+	year := date.Year()
+	if date.Month() < start {
+		year--
+	}
+	first := time.Date(year, start, 1, 0, 0, 0, 0, time.UTC)
+	if start == time.January {
+		return first, fmt.Sprintf("%d", year)
+	}
+	return first, fmt.Sprintf("FY %d-%02d", year, (year+1)%100)
+}
+
+// reportPeriod returns the month, fiscal quarter or fiscal year containing
+// date, or the fiscal year up to date
+func reportPeriod(kind string, date time.Time, fyStart time.Month) Period {
+	year, month := date.Year(), date.Month()
+	fyFirst, fyLabel := fiscalYear(date, fyStart)
+	var start, end time.Time
+	var label string
+	switch kind {
+	case PERIOD_QUARTER:
+		q := (int(month) - int(fyStart) + 12) % 12 / 3
+		start = fyFirst.AddDate(0, q*3, 0)
+		end = start.AddDate(0, 3, -1)
+		label = fmt.Sprintf("Q%d %s", q+1, fyLabel)
+	case PERIOD_YEAR:
+		start = fyFirst
+		end = start.AddDate(1, 0, -1)
+		label = fyLabel
+	case PERIOD_YTD:
+		start = fyFirst
+		end = time.Date(year, month, date.Day(), 0, 0, 0, 0, time.UTC)
+		label = fyLabel + " to " + end.Format("02-01-2006")
+	default:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		end = start.AddDate(0, 1, -1)
+		label = start.Format("01-2006")
+	}
+	return Period{From: start.Format("02-01-2006"), To: end.Format("02-01-2006"), Label: label}
+}
+
+// customPeriod is the range between two dates
+func customPeriod(from, to time.Time) Period {
+	p := Period{From: from.Format("02-01-2006"), To: to.Format("02-01-2006")}
+	p.Label = p.From + " to " + p.To
+	return p
+}
+
+// comparisonPeriod returns the period to compare p with: the one just
+// before it, or the same dates a year earlier. Year to date is always
+// compared with the same part of the fiscal year before.
+func comparisonPeriod(kind, compare string, p Period, fyStart time.Month) Period {
+	from, _ := time.Parse("02-01-2006", p.From)
+	to, _ := time.Parse("02-01-2006", p.To)
+
+	if kind == PERIOD_YTD {
+		return reportPeriod(kind, yearEarlier(to), fyStart)
+	}
+	if compare == COMPARE_YEAR {
+		if kind == PERIOD_CUSTOM {
+			return customPeriod(yearEarlier(from), yearEarlier(to))
+		}
+		return reportPeriod(kind, yearEarlier(from), fyStart)
+	}
+	if kind == PERIOD_CUSTOM {
+		days := int(to.Sub(from).Hours()/24) + 1
+		return customPeriod(from.AddDate(0, 0, -days), from.AddDate(0, 0, -1))
+	}
+	return reportPeriod(kind, from.AddDate(0, 0, -1), fyStart)
+}
+
+// yearEarlier returns the same day a year before, with 29 February
+// becoming the 28th rather than 1 March
+func yearEarlier(date time.Time) time.Time {
+	return clampDay(date.Year()-1, date.Month(), date.Day())
+}
+
+// parseReportPeriod reads period (month, quarter, year, ytd or custom),
+// date (any day in the period or the last day of ytd, default today) or
+// from and to for a custom period, and compare (previous, year or none)
+func parseReportPeriod(r *http.Request) (Period, *Period, error) {
+	q := r.URL.Query()
+	kind := q.Get("period")
+	if kind == "" {
+		kind = PERIOD_MONTH
+	}
+	compare := q.Get("compare")
+	if compare == "" {
+		compare = COMPARE_PREVIOUS
+	}
+	if compare != COMPARE_PREVIOUS && compare != COMPARE_YEAR && compare != COMPARE_NONE {
+		return Period{}, nil, errors.New("compare must be previous, year or none")
+	}
+
+	fyStart := fiscalYearStart()
+	var p Period
+	switch kind {
+	case PERIOD_MONTH, PERIOD_QUARTER, PERIOD_YEAR, PERIOD_YTD:
+		date := time.Now()
+		if d := q.Get("date"); d != "" {
+			parsed, err := time.Parse("02-01-2006", d)
+			if err != nil || !isValidDate(d) {
+				return Period{}, nil, errors.New("invalid date format (use DD-MM-YYYY)")
+			}
+			date = parsed
+		}
+		p = reportPeriod(kind, date, fyStart)
+
+	case PERIOD_CUSTOM:
+		from, errFrom := time.Parse("02-01-2006", q.Get("from"))
+		to, errTo := time.Parse("02-01-2006", q.Get("to"))
+		if errFrom != nil || errTo != nil || !isValidDate(q.Get("from")) || !isValidDate(q.Get("to")) {
+			return Period{}, nil, errors.New("a custom period needs from and to (DD-MM-YYYY)")
+		}
+		if from.After(to) {
+			return Period{}, nil, errors.New("from must not be after to")
+		}
+		p = customPeriod(from, to)
+
+	default:
+		return Period{}, nil, errors.New("period must be month, quarter, year, ytd or custom")
+	}
+
+	if compare == COMPARE_NONE {
+		return p, nil, nil
+	}
+	previous := comparisonPeriod(kind, compare, p, fyStart)
+	return p, &previous, nil
+}
+
+// periodChanges adds up how much each account's balance moved during p, in
+// the base currency at each transaction's date
+func periodChanges(transactions []Transaction, currencies map[string]string, conv *Converter, p Period) map[string]Money {
+	from, to := dateKey(p.From), dateKey(p.To)
+	changes := make(map[string]Money)
+	for _, t := range transactions {
+		key := dateKey(t.TranDate)
+		if key < from || key > to {
+			continue
+		}
+		changes[t.From] -= conv.ToBase(t.Amount, currencies[t.From], t.TranDate)
+		for _, leg := range t.Legs() {
+			changes[leg.To] += conv.ToBase(leg.Received(), currencies[leg.To], t.TranDate)
+		}
+	}
+	return changes
+}
+
+// balancesAt returns every account's balance at the end of date, in the
+// base currency at that date's rate
+func balancesAt(transactions []Transaction, currencies map[string]string, conv *Converter, date string) map[string]Money {
+	end := dateKey(date)
+	balances := make(map[string]Money)
+	for _, t := range transactions {
+		if dateKey(t.TranDate) > end {
+			continue
+		}
+		balances[t.From] -= t.Amount
+		for _, leg := range t.Legs() {
+			balances[leg.To] += leg.Received()
+		}
+	}
+	for name, balance := range balances {
+		balances[name] = conv.ToBase(balance, currencies[name], date)
+	}
+	return balances
+}
+
+// reportSection lists the accounts of one type with their values in both
+// periods. sign turns balances into the way the section reads, e.g. -1 to
+// show income as positive. Accounts with nothing in either period are
+// left out.
+func reportSection(accounts []Account, typ string, sign Money, current, previous map[string]Money) ReportSection {
+	section := ReportSection{Type: typ, Accounts: []ReportLine{}}
+	values := make(map[string]Money)
+	before := make(map[string]Money)
+	for _, acc := range accounts {
+		if acc.Type != typ {
+			continue
+		}
+		values[acc.Name] = sign * current[acc.Name]
+		before[acc.Name] = sign * previous[acc.Name]
+		section.Total += values[acc.Name]
+		section.Previous += before[acc.Name]
+	}
+	section.Change = section.Total - section.Previous
+
+	totals := rollupAccounts(accounts, values)
+	previousTotals := rollupAccounts(accounts, before)
+	for _, acc := range accounts {
+		if acc.Type != typ || (totals[acc.Name] == 0 && previousTotals[acc.Name] == 0) {
+			continue
+		}
+		section.Accounts = append(section.Accounts, ReportLine{
+			Account:  acc.Name,
+			Path:     accountPath(accounts, acc.Name),
+			Amount:   totals[acc.Name],
+			Previous: previousTotals[acc.Name],
+			Change:   totals[acc.Name] - previousTotals[acc.Name],
+		})
+	}
+	sort.SliceStable(section.Accounts, func(i, j int) bool {
+		return section.Accounts[i].Path < section.Accounts[j].Path
+	})
+	return section
+}
+
+// loadReportData reads what every report needs
+func loadReportData() ([]Transaction, []Account, map[string]string, *Converter, error) {
+	transactions, err := readAllTransactions()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	accounts, err := readAccounts()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	conv, err := loadConverter()
+	if err != nil {
+		log.Printf("Error reading exchange rates: %v", err)
+	}
+	currencies := make(map[string]string, len(accounts))
+	for _, acc := range accounts {
+		currencies[acc.Name] = accountCurrency(acc, conv.Base)
+	}
+	return transactions, accounts, currencies, conv, nil
+}
+
+// handleIncomeStatement reports income and expenses over a period:
+// GET /api/reports/income-statement?period=quarter&date=15-08-2026
+func handleIncomeStatement(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	period, previous, err := parseReportPeriod(r)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transactions, accounts, currencies, conv, err := loadReportData()
+	if err != nil {
+		respondError(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	current := periodChanges(transactions, currencies, conv, period)
+	before := map[string]Money{}
+	if previous != nil {
+		before = periodChanges(transactions, currencies, conv, *previous)
+	}
+
+	income := reportSection(accounts, "INCOME", -1, current, before)
+	expenses := reportSection(accounts, "EXPENSE", 1, current, before)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"period":            period,
+		"previousPeriod":    previous,
+		"baseCurrency":      conv.Base,
+		"income":            income,
+		"expenses":          expenses,
+		"netIncome":         income.Total - expenses.Total,
+		"previousNetIncome": income.Previous - expenses.Previous,
+	})
+}
+
+// handleBalanceSheet reports assets and liabilities at the end of a
+// period, for the accounts counted in net worth:
+// GET /api/reports/balance-sheet?period=year&date=31-12-2025
+func handleBalanceSheet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	period, previous, err := parseReportPeriod(r)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transactions, accounts, currencies, conv, err := loadReportData()
+	if err != nil {
+		respondError(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	var inNetWorth []Account
+	for _, acc := range accounts {
+		if acc.IINW == "Yes" {
+			inNetWorth = append(inNetWorth, acc)
+		}
+	}
+
+	current := balancesAt(transactions, currencies, conv, period.To)
+	before := map[string]Money{}
+	if previous != nil {
+		before = balancesAt(transactions, currencies, conv, previous.To)
+	}
+
+	assets := reportSection(inNetWorth, "ASSET", 1, current, before)
+	liabilities := reportSection(inNetWorth, "LIABILITIES", 1, current, before)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"period":           period,
+		"previousPeriod":   previous,
+		"baseCurrency":     conv.Base,
+		"assets":           assets,
+		"liabilities":      liabilities,
+		"netWorth":         assets.Total + liabilities.Total,
+		"previousNetWorth": assets.Previous + liabilities.Previous,
+	})
+}
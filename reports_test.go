@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportPeriod(t *testing.T) {
+	tests := []struct {
+		kind    string
+		date    string
+		fyStart time.Month
+		want    Period
+	}{
+		{PERIOD_MONTH, "16-10-2026", time.April, Period{"01-10-2026", "31-10-2026", "10-2026"}},
+		{PERIOD_MONTH, "29-02-2028", time.January, Period{"01-02-2028", "29-02-2028", "02-2028"}},
+		{PERIOD_QUARTER, "16-10-2026", time.January, Period{"01-10-2026", "31-12-2026", "Q4 2026"}},
+		{PERIOD_YEAR, "16-10-2026", time.January, Period{"01-01-2026", "31-12-2026", "2026"}},
+		{PERIOD_YTD, "16-10-2026", time.January, Period{"01-01-2026", "16-10-2026", "2026 to 16-10-2026"}},
+
+		// An April to March fiscal year crosses the calendar year
+		{PERIOD_QUARTER, "01-04-2026", time.April, Period{"01-04-2026", "30-06-2026", "Q1 FY 2026-27"}},
+		{PERIOD_QUARTER, "16-10-2026", time.April, Period{"01-10-2026", "31-12-2026", "Q3 FY 2026-27"}},
+		{PERIOD_QUARTER, "15-01-2027", time.April, Period{"01-01-2027", "31-03-2027", "Q4 FY 2026-27"}},
+		{PERIOD_QUARTER, "31-03-2027", time.April, Period{"01-01-2027", "31-03-2027", "Q4 FY 2026-27"}},
+		{PERIOD_YEAR, "16-10-2026", time.April, Period{"01-04-2026", "31-03-2027", "FY 2026-27"}},
+		{PERIOD_YEAR, "15-02-2027", time.April, Period{"01-04-2026", "31-03-2027", "FY 2026-27"}},
+		{PERIOD_YEAR, "01-04-2027", time.April, Period{"01-04-2027", "31-03-2028", "FY 2027-28"}},
+		{PERIOD_YTD, "15-02-2027", time.April, Period{"01-04-2026", "15-02-2027", "FY 2026-27 to 15-02-2027"}},
+
+		// A year starting in a month that is not a quarter boundary
+		{PERIOD_QUARTER, "28-02-2027", time.November, Period{"01-02-2027", "30-04-2027", "Q2 FY 2026-27"}},
+		{PERIOD_YEAR, "31-10-2027", time.November, Period{"01-11-2026", "31-10-2027", "FY 2026-27"}},
+		{PERIOD_YEAR, "16-12-2099", time.July, Period{"01-07-2099", "30-06-2100", "FY 2099-00"}},
+	}
+
+	for _, tt := range tests {
+		got := reportPeriod(tt.kind, mustDate(tt.date), tt.fyStart)
+		if got != tt.want {
+			t.Errorf("reportPeriod(%s, %s, %s) = %+v, want %+v", tt.kind, tt.date, tt.fyStart, got, tt.want)
+		}
+	}
+}
+
+func TestComparisonPeriod(t *testing.T) {
+	tests := []struct {
+		kind, compare string
+		date          string // a day in the period; for custom, "from to"
+		fyStart       time.Month
+		want          Period
+	}{
+		{PERIOD_MONTH, COMPARE_PREVIOUS, "16-03-2026", time.January, Period{"01-02-2026", "28-02-2026", "02-2026"}},
+		{PERIOD_MONTH, COMPARE_PREVIOUS, "16-01-2026", time.January, Period{"01-12-2025", "31-12-2025", "12-2025"}},
+		{PERIOD_MONTH, COMPARE_YEAR, "16-02-2028", time.January, Period{"01-02-2027", "28-02-2027", "02-2027"}},
+		{PERIOD_QUARTER, COMPARE_PREVIOUS, "16-01-2027", time.January, Period{"01-10-2026", "31-12-2026", "Q4 2026"}},
+		{PERIOD_YEAR, COMPARE_PREVIOUS, "16-10-2026", time.January, Period{"01-01-2025", "31-12-2025", "2025"}},
+
+		// Across the calendar year end of an April fiscal year
+		{PERIOD_QUARTER, COMPARE_PREVIOUS, "16-01-2027", time.April, Period{"01-10-2026", "31-12-2026", "Q3 FY 2026-27"}},
+		{PERIOD_QUARTER, COMPARE_PREVIOUS, "16-04-2027", time.April, Period{"01-01-2027", "31-03-2027", "Q4 FY 2026-27"}},
+		{PERIOD_QUARTER, COMPARE_YEAR, "16-01-2027", time.April, Period{"01-01-2026", "31-03-2026", "Q4 FY 2025-26"}},
+		{PERIOD_YEAR, COMPARE_PREVIOUS, "16-01-2027", time.April, Period{"01-04-2025", "31-03-2026", "FY 2025-26"}},
+		{PERIOD_YEAR, COMPARE_YEAR, "16-01-2027", time.April, Period{"01-04-2025", "31-03-2026", "FY 2025-26"}},
+
+		// Year to date is compared with the same part of the year before
+		// whichever comparison is asked for
+		{PERIOD_YTD, COMPARE_PREVIOUS, "15-02-2027", time.April, Period{"01-04-2025", "15-02-2026", "FY 2025-26 to 15-02-2026"}},
+		{PERIOD_YTD, COMPARE_YEAR, "15-02-2027", time.April, Period{"01-04-2025", "15-02-2026", "FY 2025-26 to 15-02-2026"}},
+		{PERIOD_YTD, COMPARE_PREVIOUS, "29-02-2028", time.January, Period{"01-01-2027", "28-02-2027", "2027 to 28-02-2027"}},
+
+		{PERIOD_CUSTOM, COMPARE_PREVIOUS, "01-03-2026 10-03-2026", time.January, Period{"19-02-2026", "28-02-2026", "19-02-2026 to 28-02-2026"}},
+		{PERIOD_CUSTOM, COMPARE_PREVIOUS, "25-12-2026 05-01-2027", time.April, Period{"13-12-2026", "24-12-2026", "13-12-2026 to 24-12-2026"}},
+		{PERIOD_CUSTOM, COMPARE_YEAR, "15-02-2028 29-02-2028", time.January, Period{"15-02-2027", "28-02-2027", "15-02-2027 to 28-02-2027"}},
+	}
+
+	for _, tt := range tests {
+		var p Period
+		if tt.kind == PERIOD_CUSTOM {
+			p = customPeriod(mustDate(tt.date[:10]), mustDate(tt.date[11:]))
+		} else {
+			p = reportPeriod(tt.kind, mustDate(tt.date), tt.fyStart)
+		}
+		got := comparisonPeriod(tt.kind, tt.compare, p, tt.fyStart)
+		if got != tt.want {
+			t.Errorf("comparisonPeriod(%s, %s, %+v) = %+v, want %+v", tt.kind, tt.compare, p, got, tt.want)
+		}
+	}
+}
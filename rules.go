@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Rules categorize transactions whose other side is a catch-all account,
+// such as an import profile's DefaultAccount. A rule matches on the
+// description (text it contains, or a regular expression), an amount range
+// and the account on the known side, and replaces the catch-all with its
+// Target, optionally rewriting the description and adding tags. Rules are
+// tried in the order they are saved and the first match wins.
+//
+// They run on every imported line, on transactions entered by hand when
+// the applyRulesOnEntry setting is on, and on demand over the existing
+// transactions of a catch-all account.
+
+var ruleHeader = []string{"Name", "Match", "Pattern", "Account", "MinAmount", "MaxAmount", "Target", "Description", "Tags"}
+
+// Ways a rule matches the description
+const (
+	MATCH_CONTAINS = "contains" // case-insensitive text
+	MATCH_REGEX    = "regex"
+)
+
+const MAX_TAGS = 10
+
+var rulesMutex sync.Mutex
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_][\p{L}\p{N}_/.-]*$`)
+
+type Rule struct {
+	Name        string   `json:"name"`
+	Match       string   `json:"match"`       // contains (default) or regex
+	Pattern     string   `json:"pattern"`     // matched against the description
+	Account     string   `json:"account"`     // the known side; empty for any
+	MinAmount   Money    `json:"minAmount"`   // zero for no limit
+	MaxAmount   Money    `json:"maxAmount"`   // zero for no limit
+	Target      string   `json:"target"`      // replaces the catch-all account
+	Description string   `json:"description"` // replaces the description if set
+	Tags        []string `json:"tags,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compile prepares the pattern for matching
+func (rule *Rule) compile() error {
+	pattern := rule.Pattern
+	if rule.Match != MATCH_REGEX {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return err
+	}
+	rule.re = re
+	return nil
+}
+
+// matches reports whether the rule applies to t, whose known side is
+// source. Stored descriptions are HTML-escaped, so the pattern is matched
+// against the text as the user sees it.
+func (rule Rule) matches(t Transaction, source string) bool {
+	if rule.Account != "" && rule.Account != source {
+		return false
+	}
+	if t.Amount < rule.MinAmount || (rule.MaxAmount != 0 && t.Amount > rule.MaxAmount) {
+		return false
+	}
+	return rule.re != nil && rule.re.MatchString(html.UnescapeString(t.Description))
+}
+
+func readRules() ([]Rule, error) {
+	rows, cols, err := store.ReadTable("rules")
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	for _, record := range rows {
+		minAmount, _ := ParseMoney(columnValue(record, cols, "MinAmount"))
+		maxAmount, _ := ParseMoney(columnValue(record, cols, "MaxAmount"))
+		rule := Rule{
+			Name:        columnValue(record, cols, "Name"),
+			Match:       columnValue(record, cols, "Match"),
+			Pattern:     columnValue(record, cols, "Pattern"),
+			Account:     columnValue(record, cols, "Account"),
+			MinAmount:   minAmount,
+			MaxAmount:   maxAmount,
+			Target:      columnValue(record, cols, "Target"),
+			Description: columnValue(record, cols, "Description"),
+			Tags:        splitTags(columnValue(record, cols, "Tags")),
+		}
+		if err := rule.compile(); err != nil {
+			log.Printf("Skipping rule %s: %v", rule.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func writeRules(rules []Rule) error {
+	rows := make([][]string, 0, len(rules))
+	for _, rule := range rules {
+		rows = append(rows, []string{
+			rule.Name,
+			rule.Match,
+			rule.Pattern,
+			rule.Account,
+			optionalMoney(rule.MinAmount),
+			optionalMoney(rule.MaxAmount),
+			rule.Target,
+			rule.Description,
+			strings.Join(rule.Tags, ","),
+		})
+	}
+	return store.WriteTable("rules", ruleHeader, rows)
+}
+
+func findRule(rules []Rule, name string) int {
+	for i, rule := range rules {
+		if rule.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateRule(rule *Rule) error {
+	rule.Name = sanitizeInput(rule.Name)
+	rule.Match = strings.ToLower(strings.TrimSpace(rule.Match))
+	// Kept verbatim so regular expressions survive; the frontend escapes it
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	rule.Account = sanitizeInput(rule.Account)
+	rule.Target = sanitizeInput(rule.Target)
+	rule.Description = sanitizeInput(rule.Description)
+
+	if rule.Name == "" || len(rule.Name) > 50 {
+		return errors.New("rule name required (max 50 characters)")
+	}
+	if rule.Pattern == "" || len(rule.Pattern) > 200 {
+		return errors.New("pattern required (max 200 characters)")
+	}
+	if strings.ContainsAny(rule.Pattern[:1], "=+-@") {
+		return errors.New("pattern cannot start with =, +, - or @")
+	}
+
+	switch rule.Match {
+	case "":
+		rule.Match = MATCH_CONTAINS
+	case MATCH_CONTAINS, MATCH_REGEX:
+	default:
+		return errors.New("match must be contains or regex")
+	}
+	if err := rule.compile(); err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+
+	if rule.MinAmount < 0 || rule.MaxAmount < 0 {
+		return errors.New("amounts cannot be negative")
+	}
+	if rule.MaxAmount != 0 && rule.MaxAmount < rule.MinAmount {
+		return errors.New("maxAmount is below minAmount")
+	}
+
+	if rule.Target == "" {
+		return errors.New("target account required")
+	}
+	if rule.Target == rule.Account {
+		return errors.New("account and target must differ")
+	}
+	accounts, err := readAccounts()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{rule.Account, rule.Target} {
+		if name != "" && findAccount(accounts, name).Name == "" {
+			return fmt.Errorf("unknown account: %s", name)
+		}
+	}
+
+	if len(rule.Description) > 100 {
+		return errors.New("description too long (max 100 characters)")
+	}
+
+	tags, err := normalizeTags(rule.Tags)
+	if err != nil {
+		return err
+	}
+	rule.Tags = tags
+	return nil
+}
+
+// normalizeTags lowercases, de-duplicates and sorts tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > 30 || !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q (letters, digits and _ / . -, max 30)", tag)
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	if len(result) > MAX_TAGS {
+		return nil, fmt.Errorf("too many tags (max %d)", MAX_TAGS)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// splitTags reads the comma-separated Tags column
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// applyRules categorizes a validated transaction with the first matching
+// rule, replacing the account on the opposite side of source. Split and
+// security transactions are left alone. It returns the name of the rule
+// applied, or "" when none matched.
+func applyRules(rules []Rule, t *Transaction, source string, accounts []Account) string {
+	if len(t.Splits) > 0 || t.Security != "" {
+		return ""
+	}
+	if t.From != source && t.To != source {
+		return ""
+	}
+
+	for _, rule := range rules {
+		if !rule.matches(*t, source) {
+			continue
+		}
+		target := findAccount(accounts, rule.Target)
+		if target.Name == "" || target.Name == source || (target.Closed() && compareDates(t.TranDate, target.ClosedDate)) {
+			continue
+		}
+
+		if (t.From == source && t.To == target.Name) || (t.To == source && t.From == target.Name) {
+			return "" // already categorized
+		}
+
+		categorized := *t
+		if categorized.From == source {
+			categorized.To = target.Name
+			categorized.ToAmount = 0
+		} else {
+			categorized.From = target.Name
+		}
+		// The received amount may need a rate for the new account
+		if err := resolveToAmounts(&categorized, accounts); err != nil {
+			continue
+		}
+		if rule.Description != "" {
+			categorized.Description = rule.Description
+		}
+		tags, err := normalizeTags(append(append([]string{}, t.Tags...), rule.Tags...))
+		if err != nil {
+			continue
+		}
+		categorized.Tags = tags
+
+		*t = categorized
+		return rule.Name
+	}
+	return ""
+}
+
+// categorizeEntry applies the rules to a transaction entered by hand, with
+// From as the known side, if the applyRulesOnEntry setting is on
+func categorizeEntry(t *Transaction) string {
+	if getSetting("applyRulesOnEntry") != "true" {
+		return ""
+	}
+	rules, err := readRules()
+	if err != nil {
+		log.Printf("Error reading rules: %v", err)
+		return ""
+	}
+	accounts, err := readAccounts()
+	if err != nil {
+		log.Printf("Error reading accounts: %v", err)
+		return ""
+	}
+	return applyRules(rules, t, t.From, accounts)
+}
+
+// categorizeTransactions runs the rules over every transaction with
+// account on one side, writing the changes in one batch unless dryRun is
+// set. It returns the changed transactions.
+func categorizeTransactions(account string, dryRun bool) ([]Transaction, error) {
+	rules, err := readRules()
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := readAccounts()
+	if err != nil {
+		return nil, err
+	}
+	years, err := store.TransactionYears()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []Transaction{}
+	batch := Batch{Transactions: make(map[string][]Transaction)}
+	for _, year := range years {
+		transactions, err := store.ReadTransactions(year)
+		if err != nil {
+			return nil, err
+		}
+
+		modified := false
+		for i := range transactions {
+			t := &transactions[i]
+			var source string
+			switch account {
+			case t.To:
+				source = t.From
+			case t.From:
+				source = t.To
+			default:
+				continue
+			}
+			if applyRules(rules, t, source, accounts) != "" {
+				changed = append(changed, *t)
+				modified = true
+			}
+		}
+		if modified {
+			batch.Transactions[year] = transactions
+		}
+	}
+
+	if dryRun || len(changed) == 0 {
+		return changed, nil
+	}
+	return changed, store.Commit(batch)
+}
+
+func handleRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := readRules()
+		if err != nil {
+			respondError(w, "Failed to load rules", http.StatusInternalServerError)
+			return
+		}
+		if rules == nil {
+			rules = []Rule{}
+		}
+		json.NewEncoder(w).Encode(rules)
+
+	case http.MethodPost:
+		var rule Rule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+
+		if err := validateRule(&rule); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Saving under an existing name replaces that rule in place, so
+		// its position in the order is kept
+		rulesMutex.Lock()
+		rules, err := readRules()
+		if err == nil {
+			if i := findRule(rules, rule.Name); i >= 0 {
+				rules[i] = rule
+			} else {
+				rules = append(rules, rule)
+			}
+			err = writeRules(rules)
+		}
+		rulesMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to save rule", http.StatusInternalServerError)
+			return
+		}
+
+		logSecurityEvent("RULE_SAVE", getClientIP(r), fmt.Sprintf("Saved rule: %s", rule.Name))
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "rule": rule})
+
+	case http.MethodDelete:
+		var data map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			respondError(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+		name := sanitizeInput(data["name"])
+
+		rulesMutex.Lock()
+		rules, err := readRules()
+		i := -1
+		if err == nil {
+			if i = findRule(rules, name); i >= 0 {
+				err = writeRules(append(rules[:i], rules[i+1:]...))
+			}
+		}
+		rulesMutex.Unlock()
+		if err != nil {
+			respondError(w, "Failed to delete rule", http.StatusInternalServerError)
+			return
+		}
+		if i < 0 {
+			respondError(w, "Rule not found", http.StatusNotFound)
+			return
+		}
+
+		logSecurityEvent("RULE_DELETE", getClientIP(r), fmt.Sprintf("Deleted rule: %s", name))
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+
+	default:
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleApplyRules re-runs the rules over the transactions of a catch-all
+// account, sent as {"account": "Uncategorized", "dryRun": true}
+func handleApplyRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Account string `json:"account"`
+		DryRun  bool   `json:"dryRun"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "Invalid request data", http.StatusBadRequest)
+		return
+	}
+	account := sanitizeInput(req.Account)
+	if account == "" {
+		respondError(w, "account required", http.StatusBadRequest)
+		return
+	}
+
+	rulesMutex.Lock()
+	changed, err := categorizeTransactions(account, req.DryRun)
+	rulesMutex.Unlock()
+	if err != nil {
+		respondError(w, "Failed to apply rules", http.StatusInternalServerError)
+		return
+	}
+
+	if !req.DryRun && len(changed) > 0 {
+		if err := recalculateAllData(); err != nil {
+			log.Printf("Error recalculating data: %v", err)
+		}
+		logSecurityEvent("RULES_APPLY", getClientIP(r), fmt.Sprintf("Categorized %d transactions of %s", len(changed), account))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"transactions": changed,
+		"changed":      len(changed),
+	})
+}
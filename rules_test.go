@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyRules(t *testing.T) {
+	useTestStore(t)
+
+	accounts := []Account{
+		{Name: "HDFC", Type: "ASSET"},
+		{Name: "Uncategorized", Type: "EXPENSE"},
+		{Name: "Food", Type: "EXPENSE"},
+		{Name: "Dining", Type: "EXPENSE"},
+		{Name: "Salary", Type: "INCOME"},
+		{Name: "Travel", Type: "EXPENSE", ClosedDate: "30-09-2026"},
+		{Name: "Zerodha", Type: "ASSET"},
+	}
+	rules := []Rule{
+		// Tried in order, so the narrower amount range goes first
+		{Name: "big swiggy", Pattern: "swiggy", MinAmount: 100000, Target: "Dining", Tags: []string{"party"}},
+		{Name: "swiggy", Pattern: "swiggy", MaxAmount: 99999, Target: "Food", Description: "Food delivery"},
+		{Name: "salary", Match: MATCH_REGEX, Pattern: `^NEFT.*ACME`, Account: "HDFC", Target: "Salary"},
+		{Name: "uber", Pattern: "uber", Target: "Travel"},
+		{Name: "uber fallback", Pattern: "uber", Target: "Food"},
+		{Name: "gone", Pattern: "gone", Target: "Missing"},
+		{Name: "self", Pattern: "self", Target: "HDFC"},
+	}
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spend := func(desc string, amount Money) Transaction {
+		return Transaction{TranDate: "16-10-2026", From: "HDFC", To: "Uncategorized", Description: desc, Amount: amount, Tags: []string{"card"}}
+	}
+
+	tests := []struct {
+		name   string
+		tran   Transaction
+		source string
+		rule   string      // the rule applied
+		want   Transaction // the transaction after, when a rule applied
+	}{
+		{
+			name: "small amount", tran: spend("SWIGGY*Bangalore", 45000), source: "HDFC", rule: "swiggy",
+			want: Transaction{TranDate: "16-10-2026", From: "HDFC", To: "Food", Description: "Food delivery", Amount: 45000, Tags: []string{"card"}},
+		},
+		{
+			name: "at the minimum", tran: spend("swiggy", 100000), source: "HDFC", rule: "big swiggy",
+			want: Transaction{TranDate: "16-10-2026", From: "HDFC", To: "Dining", Description: "swiggy", Amount: 100000, Tags: []string{"card", "party"}},
+		},
+		{
+			name: "at the maximum", tran: spend("swiggy", 99999), source: "HDFC", rule: "swiggy",
+			want: Transaction{TranDate: "16-10-2026", From: "HDFC", To: "Food", Description: "Food delivery", Amount: 99999, Tags: []string{"card"}},
+		},
+		{
+			name:   "income side",
+			tran:   Transaction{TranDate: "16-10-2026", From: "Uncategorized", To: "HDFC", Description: "NEFT CR ACME LTD", Amount: 5000000},
+			source: "HDFC", rule: "salary",
+			want: Transaction{TranDate: "16-10-2026", From: "Salary", To: "HDFC", Description: "NEFT CR ACME LTD", Amount: 5000000},
+		},
+		{
+			name:   "other known account",
+			tran:   Transaction{TranDate: "16-10-2026", From: "Uncategorized", To: "Zerodha", Description: "NEFT CR ACME LTD", Amount: 5000000},
+			source: "Zerodha",
+		},
+		{name: "source not on the transaction", tran: spend("swiggy", 100), source: "Zerodha"},
+		{name: "no match", tran: spend("Amazon", 100), source: "HDFC"},
+		{
+			// Travel was closed before the transaction, so the next rule wins
+			name: "closed target", tran: spend("Uber trip", 500), source: "HDFC", rule: "uber fallback",
+			want: Transaction{TranDate: "16-10-2026", From: "HDFC", To: "Food", Description: "Uber trip", Amount: 500, Tags: []string{"card"}},
+		},
+		{
+			name: "closed target, earlier date", tran: Transaction{TranDate: "15-09-2026", From: "HDFC", To: "Uncategorized", Description: "Uber", Amount: 500},
+			source: "HDFC", rule: "uber",
+			want: Transaction{TranDate: "15-09-2026", From: "HDFC", To: "Travel", Description: "Uber", Amount: 500},
+		},
+		{name: "unknown target", tran: spend("gone", 100), source: "HDFC"},
+		{name: "target is the source", tran: spend("self", 100), source: "HDFC"},
+		{
+			name: "already categorized", tran: Transaction{TranDate: "16-10-2026", From: "HDFC", To: "Food", Description: "swiggy", Amount: 100},
+			source: "HDFC",
+		},
+		{
+			name: "split",
+			tran: Transaction{TranDate: "16-10-2026", From: "HDFC", Description: "swiggy", Amount: 300,
+				Splits: []Split{{To: "Uncategorized", Amount: 100}, {To: "Food", Amount: 200}}},
+			source: "HDFC",
+		},
+		{
+			name:   "security",
+			tran:   Transaction{TranDate: "16-10-2026", From: "HDFC", To: "Uncategorized", Description: "swiggy", Amount: 100, Security: "SWIGGY", Units: 10000},
+			source: "HDFC",
+		},
+	}
+
+	for _, tt := range tests {
+		before := tt.tran
+		got := applyRules(rules, &tt.tran, tt.source, accounts)
+		if got != tt.rule {
+			t.Errorf("%s: applied %q, want %q", tt.name, got, tt.rule)
+		}
+		want := tt.want
+		if tt.rule == "" {
+			want = before
+		}
+		if !reflect.DeepEqual(tt.tran, want) {
+			t.Errorf("%s: transaction = %+v, want %+v", tt.name, tt.tran, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GET /api/transactions filters and sorts every transaction on the server
+// before paging, so totals cover all matches rather than one page.
+
+const (
+	DEFAULT_PAGE_SIZE = 30
+	MAX_PAGE_SIZE     = 200
+)
+
+// Transaction types accepted by the type filter
+const (
+	TYPE_EXPENSE  = "expense"  // into an expense account
+	TYPE_INCOME   = "income"   // out of an income account
+	TYPE_TRANSFER = "transfer" // anything else
+)
+
+// TransactionFilter holds the search parameters of GET /api/transactions
+type TransactionFilter struct {
+	Query     string // matched against description, account names and tags
+	Account   string // either side, including child accounts
+	FromDate  string
+	ToDate    string
+	MinAmount Money
+	MaxAmount Money // zero for no limit
+	Type      string
+	Sort      string // date-desc (default), date-asc, amount-desc or amount-asc
+	Page      int
+	PageSize  int
+}
+
+// parseTransactionFilter reads the filter from the query string
+func parseTransactionFilter(q url.Values) (TransactionFilter, error) {
+	f := TransactionFilter{
+		Query:    strings.ToLower(sanitizeInput(q.Get("q"))),
+		Account:  sanitizeInput(q.Get("account")),
+		FromDate: q.Get("from"),
+		ToDate:   q.Get("to"),
+		Type:     strings.ToLower(q.Get("type")),
+		Sort:     q.Get("sort"),
+		Page:     1,
+		PageSize: DEFAULT_PAGE_SIZE,
+	}
+
+	if p := q.Get("page"); p != "" {
+		page, err := strconv.Atoi(p)
+		if err == nil && page >= 1 {
+			f.Page = page
+		}
+	}
+	if f.Page > 1000 {
+		return f, errors.New("page number too large")
+	}
+
+	if s := q.Get("pageSize"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 || size > MAX_PAGE_SIZE {
+			return f, errors.New("pageSize must be between 1 and 200")
+		}
+		f.PageSize = size
+	}
+
+	if f.FromDate != "" && !isValidDate(f.FromDate) {
+		return f, errors.New("invalid from date (use DD-MM-YYYY)")
+	}
+	if f.ToDate != "" && !isValidDate(f.ToDate) {
+		return f, errors.New("invalid to date (use DD-MM-YYYY)")
+	}
+
+	var err error
+	if f.MinAmount, err = ParseMoney(q.Get("min")); err != nil {
+		return f, errors.New("invalid min amount")
+	}
+	if f.MaxAmount, err = ParseMoney(q.Get("max")); err != nil {
+		return f, errors.New("invalid max amount")
+	}
+	if f.MaxAmount != 0 && f.MaxAmount < f.MinAmount {
+		return f, errors.New("max amount is below min amount")
+	}
+
+	switch f.Type {
+	case "", TYPE_EXPENSE, TYPE_INCOME, TYPE_TRANSFER:
+	default:
+		return f, errors.New("type must be expense, income or transfer")
+	}
+
+	switch f.Sort {
+	case "":
+		f.Sort = "date-desc"
+	case "date-desc", "date-asc", "amount-desc", "amount-asc":
+	default:
+		return f, errors.New("sort must be date-desc, date-asc, amount-desc or amount-asc")
+	}
+
+	return f, nil
+}
+
+// filterTransactions returns the matching transactions in the requested
+// order
+func filterTransactions(transactions []Transaction, accounts []Account, f TransactionFilter) []Transaction {
+	// An account matches its descendants too, e.g. Food finds Groceries
+	var matchAccounts map[string]bool
+	if f.Account != "" {
+		matchAccounts = map[string]bool{f.Account: true}
+		for _, acc := range accounts {
+			if strings.HasPrefix(accountPath(accounts, acc.Name)+":", accountPath(accounts, f.Account)+":") {
+				matchAccounts[acc.Name] = true
+			}
+		}
+	}
+
+	types := make(map[string]string, len(accounts))
+	for _, acc := range accounts {
+		types[acc.Name] = acc.Type
+	}
+
+	matched := []Transaction{}
+	for _, t := range transactions {
+		names := append([]string{t.From}, legAccounts(t)...)
+
+		if f.FromDate != "" && compareDates(f.FromDate, t.TranDate) {
+			continue
+		}
+		if f.ToDate != "" && compareDates(t.TranDate, f.ToDate) {
+			continue
+		}
+		if t.Amount < f.MinAmount || (f.MaxAmount != 0 && t.Amount > f.MaxAmount) {
+			continue
+		}
+		if matchAccounts != nil && !anyName(names, func(name string) bool { return matchAccounts[name] }) {
+			continue
+		}
+		if f.Query != "" && !strings.Contains(strings.ToLower(t.Description), f.Query) &&
+			!anyName(names, func(name string) bool { return strings.Contains(strings.ToLower(name), f.Query) }) &&
+			!anyName(t.Tags, func(tag string) bool { return tag == f.Query }) {
+			continue
+		}
+		if f.Type != "" && transactionType(t, types) != f.Type {
+			continue
+		}
+		matched = append(matched, t)
+	}
+
+	// transactions arrive newest first; the other orders keep that as the
+	// tie-break
+	switch f.Sort {
+	case "date-asc":
+		for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+			matched[i], matched[j] = matched[j], matched[i]
+		}
+	case "amount-desc":
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].Amount > matched[j].Amount })
+	case "amount-asc":
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].Amount < matched[j].Amount })
+	}
+	return matched
+}
+
+func anyName(names []string, match func(string) bool) bool {
+	for _, name := range names {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// transactionType classifies a transaction by the accounts it touches
+func transactionType(t Transaction, types map[string]string) string {
+	for _, name := range legAccounts(t) {
+		if types[name] == "EXPENSE" {
+			return TYPE_EXPENSE
+		}
+	}
+	if types[t.From] == "INCOME" {
+		return TYPE_INCOME
+	}
+	return TYPE_TRANSFER
+}
+
+// pageOf returns one page of transactions
+func pageOf(transactions []Transaction, page, pageSize int) []Transaction {
+	start := (page - 1) * pageSize
+	end := start + pageSize
+	if start > len(transactions) {
+		start = len(transactions)
+	}
+	if end > len(transactions) {
+		end = len(transactions)
+	}
+	return transactions[start:end]
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterTransactions(t *testing.T) {
+	accounts := []Account{
+		{Name: "HDFC", Type: "ASSET"},
+		{Name: "Card", Type: "LIABILITY"},
+		{Name: "Salary", Type: "INCOME"},
+		{Name: "Food", Type: "EXPENSE"},
+		{Name: "Groceries", Type: "EXPENSE", Parent: "Food"},
+		{Name: "Fruit", Type: "EXPENSE", Parent: "Groceries"},
+		{Name: "Foodie Club", Type: "EXPENSE"},
+		{Name: "Home", Type: "EXPENSE"},
+	}
+	// Newest first, as readAllTransactions returns them
+	transactions := []Transaction{
+		{ID: "7", TranDate: "20-10-2026", From: "HDFC", Description: "Market", Amount: 30000,
+			Splits: []Split{{To: "Home", Amount: 10000}, {To: "Fruit", Amount: 20000}}},
+		{ID: "6", TranDate: "15-10-2026", From: "HDFC", To: "Card", Description: "Card bill", Amount: 500000},
+		{ID: "5", TranDate: "10-10-2026", From: "Card", To: "Foodie Club", Description: "Membership", Amount: 100000},
+		{ID: "4", TranDate: "01-10-2026", From: "Salary", To: "HDFC", Description: "Salary ACME", Amount: 5000000},
+		{ID: "3", TranDate: "30-09-2026", From: "Card", To: "Groceries", Description: "Big Basket", Amount: 45000, Tags: []string{"monthly"}},
+		{ID: "2", TranDate: "15-09-2026", From: "HDFC", To: "Food", Description: "Tea &amp; snacks", Amount: 100000},
+		{ID: "1", TranDate: "31-12-2025", From: "Card", To: "Home", Description: "Rent", Amount: 2000000, Tags: []string{"rent"}},
+	}
+
+	tests := []struct {
+		name string
+		f    TransactionFilter
+		want []string // IDs in order
+	}{
+		{name: "everything", want: []string{"7", "6", "5", "4", "3", "2", "1"}},
+		{name: "from date, inclusive", f: TransactionFilter{FromDate: "01-10-2026"}, want: []string{"7", "6", "5", "4"}},
+		{name: "to date, inclusive", f: TransactionFilter{ToDate: "30-09-2026"}, want: []string{"3", "2", "1"}},
+		{name: "across the year end", f: TransactionFilter{FromDate: "31-12-2025", ToDate: "15-09-2026"}, want: []string{"2", "1"}},
+		{name: "min amount, inclusive", f: TransactionFilter{MinAmount: 500000}, want: []string{"6", "4", "1"}},
+		{name: "amount range", f: TransactionFilter{MinAmount: 45000, MaxAmount: 100000}, want: []string{"5", "3", "2"}},
+		// Food and its descendants, but not Foodie Club
+		{name: "account with children", f: TransactionFilter{Account: "Food"}, want: []string{"7", "3", "2"}},
+		{name: "account on the source side", f: TransactionFilter{Account: "Card"}, want: []string{"6", "5", "3", "1"}},
+		{name: "split leg", f: TransactionFilter{Account: "Home"}, want: []string{"7", "1"}},
+		{name: "query in the description", f: TransactionFilter{Query: "basket"}, want: []string{"3"}},
+		{name: "escaped query", f: TransactionFilter{Query: "tea &amp; s"}, want: []string{"2"}},
+		{name: "query in an account name", f: TransactionFilter{Query: "food"}, want: []string{"5", "2"}},
+		{name: "query is a whole tag", f: TransactionFilter{Query: "monthly"}, want: []string{"3"}},
+		{name: "part of a tag", f: TransactionFilter{Query: "month"}, want: nil},
+		{name: "expense", f: TransactionFilter{Type: TYPE_EXPENSE}, want: []string{"7", "5", "3", "2", "1"}},
+		{name: "income", f: TransactionFilter{Type: TYPE_INCOME}, want: []string{"4"}},
+		{name: "transfer", f: TransactionFilter{Type: TYPE_TRANSFER}, want: []string{"6"}},
+		{
+			name: "combined",
+			f:    TransactionFilter{Account: "Card", Type: TYPE_EXPENSE, FromDate: "01-01-2026", MaxAmount: 100000},
+			want: []string{"5", "3"},
+		},
+		{name: "combined, no match", f: TransactionFilter{Account: "Salary", Type: TYPE_EXPENSE}, want: nil},
+		{name: "oldest first", f: TransactionFilter{Account: "Card", Sort: "date-asc"}, want: []string{"1", "3", "5", "6"}},
+		// Equal amounts keep newest first
+		{name: "largest first", f: TransactionFilter{MaxAmount: 100000, Sort: "amount-desc"}, want: []string{"5", "2", "3", "7"}},
+		{name: "smallest first", f: TransactionFilter{MaxAmount: 100000, Sort: "amount-asc"}, want: []string{"7", "3", "5", "2"}},
+	}
+
+	for _, tt := range tests {
+		input := append([]Transaction{}, transactions...)
+		var got []string
+		for _, tran := range filterTransactions(input, accounts, tt.f) {
+			got = append(got, tran.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server-side settings live in settings.csv as Key,Value rows. Only keys
+// listed in settingDefaults are accepted.
+
+var settingsHeader = []string{"Key", "Value"}
+
+var settingDefaults = map[string]string{
+	"baseCurrency":      "INR",
+	"applyRulesOnEntry": "false", // run rules.go on transactions entered by hand
+	"fiscalYearStart":   "1",     // month the fiscal year starts in, e.g. 4 for April
+	"envelopeStart":     "",      // first month (MM-YYYY) of envelope budgeting; empty for off
+}
+
+var settingsMutex sync.Mutex
+
+// readSettings returns every known setting, with defaults for those never
+// saved
+func readSettings() (map[string]string, error) {
+	rows, cols, err := store.ReadTable("settings")
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string, len(settingDefaults))
+	for key, value := range settingDefaults {
+		settings[key] = value
+	}
+	for _, record := range rows {
+		key := columnValue(record, cols, "Key")
+		if _, ok := settingDefaults[key]; ok {
+			settings[key] = columnValue(record, cols, "Value")
+		}
+	}
+	return settings, nil
+}
+
+// settingsTable lays out the settings as rows ordered by key, for writing
+// in a Batch
+func settingsTable(settings map[string]string) Table {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rows := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, []string{key, settings[key]})
+	}
+	return Table{Header: settingsHeader, Rows: rows}
+}
+
+// getSetting returns a setting, falling back to its default if the
+// settings cannot be read
+func getSetting(key string) string {
+	settings, err := readSettings()
+	if err != nil {
+		log.Printf("Error reading settings: %v", err)
+		return settingDefaults[key]
+	}
+	return settings[key]
+}
+
+func validateSetting(key, value string) (string, error) {
+	value = sanitizeInput(value)
+
+	switch key {
+	case "baseCurrency":
+		value = strings.ToUpper(value)
+		if !isValidCurrency(value) {
+			return "", fmt.Errorf("baseCurrency must be a 3-letter currency code")
+		}
+	case "applyRulesOnEntry":
+		if value != "true" && value != "false" {
+			return "", fmt.Errorf("applyRulesOnEntry must be true or false")
+		}
+	case "fiscalYearStart":
+		month, err := strconv.Atoi(value)
+		if err != nil || month < 1 || month > 12 {
+			return "", fmt.Errorf("fiscalYearStart must be a month from 1 to 12")
+		}
+		value = strconv.Itoa(month)
+	case "envelopeStart":
+		if value != "" && !isValidMonth(value) {
+			return "", fmt.Errorf("envelopeStart must be a month (MM-YYYY) or empty")
+		}
+	default:
+		return "", fmt.Errorf("unknown setting: %s", key)
+	}
+	return value, nil
+}
+
+func handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := readSettings()
+	if err != nil {
+		respondError(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(settings)
+}
+
+func handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var data map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		respondError(w, "Invalid request data", http.StatusBadRequest)
+		return
+	}
+
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	settings, err := readSettings()
+	if err != nil {
+		respondError(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	oldBase := settings["baseCurrency"]
+	for key, value := range data {
+		value, err := validateSetting(key, value)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		settings[key] = value
+	}
+
+	// Accounts without a currency are in the base currency; pin them to
+	// the old one so changing the base does not re-denominate them. Both
+	// are written together so a crash cannot leave the accounts pinned
+	// under the old base or re-denominated under the new one.
+	batch := Batch{Tables: map[string]Table{"settings": settingsTable(settings)}}
+	if settings["baseCurrency"] != oldBase {
+		if batch.Accounts, err = pinAccountCurrencies(oldBase); err != nil {
+			respondError(w, "Failed to load accounts", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := store.Commit(batch); err != nil {
+		respondError(w, "Failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	// Net worth history depends on settings such as the base currency
+	if err := recalculateAllData(); err != nil {
+		log.Printf("Error recalculating data: %v", err)
+	}
+
+	logSecurityEvent("SETTINGS_UPDATE", getClientIP(r), fmt.Sprintf("Updated settings: %d keys", len(data)))
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "settings": settings})
+}
+
+// pinAccountCurrencies returns the accounts with the given currency on
+// every account that has none, or nil if none needed it
+func pinAccountCurrencies(currency string) ([]Account, error) {
+	accounts, err := readAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	for i := range accounts {
+		if accounts[i].Currency == "" {
+			accounts[i].Currency = currency
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return accounts, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateSettingsPinsCurrencies(t *testing.T) {
+	useTestStore(t)
+
+	if err := store.WriteAccounts([]Account{
+		{Name: "HDFC", Type: "ASSET"},
+		{Name: "Wise", Type: "ASSET", Currency: "USD"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/api/settings", strings.NewReader(`{"baseCurrency": "usd"}`))
+	w := httptest.NewRecorder()
+	handleUpdateSettings(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	if base := getSetting("baseCurrency"); base != "USD" {
+		t.Errorf("baseCurrency = %s, want USD", base)
+	}
+	accounts, err := readAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := findAccount(accounts, "HDFC").Currency; c != "INR" {
+		t.Errorf("HDFC pinned to %q, want INR", c)
+	}
+	if c := findAccount(accounts, "Wise").Currency; c != "USD" {
+		t.Errorf("Wise currency = %q, want USD", c)
+	}
+}
@@ -34,7 +34,7 @@ cp style2.css frontend/ 2>/dev/null || echo "Note: style2.css not found, make su
 
 # Build and run
 echo "Building application..."
-go build -o arthik main.go
+go build -o arthik .
 
 if [ $? -eq 0 ]; then
     echo ""
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is the persistence layer. The CSV layout under DATA_DIR is the
+// default implementation; SQLite is available with -store sqlite.
+type Store interface {
+	ReadAccounts() ([]Account, error)
+	WriteAccounts(accounts []Account) error
+
+	// Transactions are partitioned by year (YYYY) the same way the
+	// tran_YYYY.csv files are
+	TransactionYears() ([]string, error)
+	ReadTransactions(year string) ([]Transaction, error)
+	WriteTransactions(year string, transactions []Transaction) error
+
+	ReadRecords() ([]Record, error)
+	WriteRecords(records []Record) error
+
+	// Feature tables (see storeTables) are a header row plus data rows:
+	// <name>.csv in the CSV layout, a table of the same name in SQLite.
+	// A table that does not exist yet reads as empty.
+	ReadTable(name string) ([][]string, map[string]int, error)
+	WriteTable(name string, header []string, rows [][]string) error
+
+	// Commit applies every write in the batch or, after a crash, none of
+	// them
+	Commit(b Batch) error
+
+	Close() error
+}
+
+// Batch groups writes that must land together, such as an account rename
+// touching the account list and every year of transactions
+type Batch struct {
+	Accounts     []Account                // nil leaves the accounts unchanged
+	Transactions map[string][]Transaction // replaced years, keyed by YYYY
+	Tables       map[string]Table         // replaced feature tables
+}
+
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+var store Store
+
+// storeTables lists the feature tables copied by `arthik migrate`
+var storeTables = []string{"recurring", "settings", "rates", "prices", "import_profiles", "duplicate_dismissals", "rules", "budgets", "allocations"}
+
+// accountColumns lists the feature table columns that hold account names,
+// so renames follow the account into them
+var accountColumns = map[string][]string{
+	"recurring":       {"From", "To"},
+	"import_profiles": {"Account", "DefaultAccount"},
+	"rules":           {"Account", "Target"},
+	"budgets":         {"Account"},
+	"allocations":     {"From", "To"},
+}
+
+// COMMIT_MANIFEST marks a CSV batch write in progress
+const COMMIT_MANIFEST = "commit.pending"
+
+var (
+	accountHeader = []string{"Account", "Type", "Amount", "IINW", "Budget", "DueDate", "ClosedDate", "Parent", "Currency"}
+	recordHeader  = []string{"Date", "NetWorth", "Assets", "Liabilities", "Expenses"}
+)
+
+// openStore opens the named storage backend
+func openStore(kind, dataDir, dbPath string) (Store, error) {
+	switch kind {
+	case "csv":
+		s := newCSVStore(dataDir)
+		if err := s.Recover(); err != nil {
+			return nil, fmt.Errorf("recovering %s: %v", dataDir, err)
+		}
+		return s, nil
+	case "sqlite":
+		return newSQLiteStore(dbPath)
+	default:
+		return nil, fmt.Errorf("unknown store %q (use csv or sqlite)", kind)
+	}
+}
+
+// Row codecs shared by every backend. Rows are decoded by header name so
+// older files with fewer columns still load.
+
+func accountRows(accounts []Account) [][]string {
+	rows := make([][]string, 0, len(accounts))
+	for _, a := range accounts {
+		rows = append(rows, accountRow(a))
+	}
+	return rows
+}
+
+func accountRow(a Account) []string {
+	return []string{
+		a.Name,
+		a.Type,
+		a.Amount.String(),
+		a.IINW,
+		a.Budget.String(),
+		a.DueDate,
+		a.ClosedDate,
+		a.Parent,
+		a.Currency,
+	}
+}
+
+func parseAccountRow(cols map[string]int, record []string) Account {
+	amount, _ := ParseMoney(columnValue(record, cols, "Amount"))
+	budget, _ := ParseMoney(columnValue(record, cols, "Budget"))
+	return Account{
+		Name:       columnValue(record, cols, "Account"),
+		Type:       columnValue(record, cols, "Type"),
+		Amount:     amount,
+		IINW:       columnValue(record, cols, "IINW"),
+		Budget:     budget,
+		DueDate:    columnValue(record, cols, "DueDate"),
+		ClosedDate: columnValue(record, cols, "ClosedDate"),
+		Parent:     columnValue(record, cols, "Parent"),
+		Currency:   columnValue(record, cols, "Currency"),
+	}
+}
+
+// transactionRows encodes a transaction. A split transaction is stored as
+// one row per destination leg, all sharing the same ID, date, time, source
+// and description.
+func transactionRows(t Transaction) [][]string {
+	var rows [][]string
+	for _, leg := range t.Legs() {
+		rows = append(rows, []string{
+			t.ID,
+			t.TranDate,
+			t.TranTime,
+			t.From,
+			leg.To,
+			t.Description,
+			leg.Amount.String(),
+			optionalMoney(leg.ToAmount),
+			t.Security,
+			optionalDecimal(t.Units),
+			optionalDecimal(t.Price),
+			t.FITID,
+			strings.Join(t.Tags, ","),
+		})
+	}
+	return rows
+}
+
+func transactionListRows(transactions []Transaction) [][]string {
+	rows := make([][]string, 0, len(transactions))
+	for _, t := range transactions {
+		rows = append(rows, transactionRows(t)...)
+	}
+	return rows
+}
+
+// optionalMoney leaves a column empty for a zero amount
+func optionalMoney(m Money) string {
+	if m == 0 {
+		return ""
+	}
+	return m.String()
+}
+
+// optionalDecimal leaves a column empty for zero
+func optionalDecimal(d Decimal) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func parseTransactionRow(cols map[string]int, record []string) Transaction {
+	amount, _ := ParseMoney(columnValue(record, cols, "Amount"))
+	toAmount, _ := ParseMoney(columnValue(record, cols, "ToAmount"))
+	units, _ := ParseDecimal(columnValue(record, cols, "Units"))
+	price, _ := ParseDecimal(columnValue(record, cols, "Price"))
+	return Transaction{
+		ID:          columnValue(record, cols, "ID"),
+		TranDate:    columnValue(record, cols, "TranDate"),
+		TranTime:    columnValue(record, cols, "TranTime"),
+		From:        columnValue(record, cols, "From"),
+		To:          columnValue(record, cols, "To"),
+		Description: columnValue(record, cols, "Description"),
+		Amount:      amount,
+		ToAmount:    toAmount,
+		Security:    columnValue(record, cols, "Security"),
+		Units:       units,
+		Price:       price,
+		FITID:       columnValue(record, cols, "FITID"),
+		Tags:        splitTags(columnValue(record, cols, "Tags")),
+	}
+}
+
+// parseTransactionRows decodes transaction rows, joining the legs of split
+// transactions back together
+func parseTransactionRows(cols map[string]int, records [][]string) []Transaction {
+	var transactions []Transaction
+	byID := make(map[string]int)
+
+	for _, record := range records {
+		t := parseTransactionRow(cols, record)
+
+		if i, ok := byID[t.ID]; ok && t.ID != "" {
+			first := &transactions[i]
+			if first.TranDate == t.TranDate && first.TranTime == t.TranTime &&
+				first.From == t.From && first.Description == t.Description {
+				if len(first.Splits) == 0 {
+					first.Splits = first.Legs()
+					first.To = ""
+					first.ToAmount = 0
+				}
+				first.Splits = append(first.Splits, t.Legs()...)
+				first.Amount += t.Amount
+				continue
+			}
+		} else {
+			byID[t.ID] = len(transactions)
+		}
+
+		transactions = append(transactions, t)
+	}
+	return transactions
+}
+
+func recordRow(r Record) []string {
+	return []string{
+		r.Date,
+		r.NetWorth.String(),
+		r.Assets.String(),
+		r.Liabilities.String(),
+		r.Expenses.String(),
+	}
+}
+
+func parseRecordRow(cols map[string]int, record []string) Record {
+	netWorth, _ := ParseMoney(columnValue(record, cols, "NetWorth"))
+	assets, _ := ParseMoney(columnValue(record, cols, "Assets"))
+	liabilities, _ := ParseMoney(columnValue(record, cols, "Liabilities"))
+	expenses, _ := ParseMoney(columnValue(record, cols, "Expenses"))
+	return Record{
+		Date:        columnValue(record, cols, "Date"),
+		NetWorth:    netWorth,
+		Assets:      assets,
+		Liabilities: liabilities,
+		Expenses:    expenses,
+	}
+}
+
+// tableHeader rebuilds a header row from its column index
+func tableHeader(cols map[string]int) []string {
+	header := make([]string, len(cols))
+	for column, i := range cols {
+		if i < len(header) {
+			header[i] = column
+		}
+	}
+	return header
+}
+
+// csvStore keeps the original layout: account.csv, record.csv and one
+// tran_YYYY.csv per year. Every rewrite is atomic and keeps the previous
+// version as a .bak file next to it.
+type csvStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func newCSVStore(dir string) *csvStore {
+	return &csvStore{dir: dir}
+}
+
+func (s *csvStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *csvStore) ReadAccounts() ([]Account, error) {
+	rows, cols, err := s.readFile("account.csv")
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []Account
+	for _, record := range rows {
+		accounts = append(accounts, parseAccountRow(cols, record))
+	}
+	return accounts, nil
+}
+
+func (s *csvStore) WriteAccounts(accounts []Account) error {
+	return s.writeFile("account.csv", accountHeader, accountRows(accounts))
+}
+
+func (s *csvStore) TransactionYears() ([]string, error) {
+	files, err := filepath.Glob(s.path("tran_*.csv"))
+	if err != nil {
+		return nil, err
+	}
+
+	var years []string
+	for _, f := range files {
+		year := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), "tran_"), ".csv")
+		if len(year) == 4 {
+			years = append(years, year)
+		}
+	}
+	sort.Strings(years)
+	return years, nil
+}
+
+func (s *csvStore) ReadTransactions(year string) ([]Transaction, error) {
+	rows, cols, err := s.readFile("tran_" + year + ".csv")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cols["TranDate"]; !ok {
+		return nil, fmt.Errorf("tran_%s.csv: missing header row", year)
+	}
+
+	return parseTransactionRows(cols, rows), nil
+}
+
+func (s *csvStore) WriteTransactions(year string, transactions []Transaction) error {
+	return s.writeFile("tran_"+year+".csv", transactionHeader, transactionListRows(transactions))
+}
+
+func (s *csvStore) ReadRecords() ([]Record, error) {
+	rows, cols, err := s.readFile("record.csv")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Record
+	for _, record := range rows {
+		result = append(result, parseRecordRow(cols, record))
+	}
+	return result, nil
+}
+
+func (s *csvStore) WriteRecords(records []Record) error {
+	rows := make([][]string, 0, len(records))
+	for _, r := range records {
+		rows = append(rows, recordRow(r))
+	}
+	return s.writeFile("record.csv", recordHeader, rows)
+}
+
+func (s *csvStore) ReadTable(name string) ([][]string, map[string]int, error) {
+	rows, cols, err := s.readFile(name + ".csv")
+	if os.IsNotExist(err) {
+		return nil, map[string]int{}, nil
+	}
+	return rows, cols, err
+}
+
+func (s *csvStore) WriteTable(name string, header []string, rows [][]string) error {
+	return s.writeFile(name+".csv", header, rows)
+}
+
+// Commit writes every file of the batch to a .tmp file first, then records
+// the list in commit.pending before moving any into place. Recover finishes
+// the moves if the process dies part way, so either all files change or,
+// if commit.pending was never written, none do.
+func (s *csvStore) Commit(b Batch) error {
+	files := make(map[string][]byte)
+	if b.Accounts != nil {
+		data, err := encodeCSV(accountHeader, accountRows(b.Accounts))
+		if err != nil {
+			return err
+		}
+		files["account.csv"] = data
+	}
+	for year, transactions := range b.Transactions {
+		data, err := encodeCSV(transactionHeader, transactionListRows(transactions))
+		if err != nil {
+			return err
+		}
+		files["tran_"+year+".csv"] = data
+	}
+	for name, table := range b.Tables {
+		data, err := encodeCSV(table.Header, table.Rows)
+		if err != nil {
+			return err
+		}
+		files[name+".csv"] = data
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range names {
+		if err := writeTemp(s.path(name), files[name]); err != nil {
+			for _, n := range names {
+				os.Remove(s.path(n) + ".tmp")
+			}
+			return err
+		}
+	}
+
+	// The batch is committed once the manifest is in place
+	manifest := s.path(COMMIT_MANIFEST)
+	if err := writeTemp(manifest, []byte(strings.Join(names, "\n")+"\n")); err != nil {
+		for _, n := range names {
+			os.Remove(s.path(n) + ".tmp")
+		}
+		return err
+	}
+	if err := os.Rename(manifest+".tmp", manifest); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	return s.finishCommit(names)
+}
+
+// finishCommit moves the .tmp files of a committed batch into place and
+// removes the manifest
+func (s *csvStore) finishCommit(names []string) error {
+	for _, name := range names {
+		if _, err := os.Stat(s.path(name) + ".tmp"); os.IsNotExist(err) {
+			// Already moved before a crash
+			continue
+		}
+		if err := replaceWithTemp(s.path(name)); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(COMMIT_MANIFEST)); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+func (s *csvStore) Close() error {
+	return nil
+}
+
+// readFile returns the data rows of a CSV file along with its header index
+func (s *csvStore) readFile(name string) ([][]string, map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, map[string]int{}, nil
+	}
+
+	return records[1:], columnIndex(records[0]), nil
+}
+
+func (s *csvStore) writeFile(name string, header []string, rows [][]string) error {
+	data, err := encodeCSV(header, rows)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeFileAtomic(s.path(name), data)
+}
+
+func encodeCSV(header []string, rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(header)
+	for _, row := range rows {
+		writer.Write(row)
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// writeFileAtomic replaces path without ever exposing a half-written file.
+// The data is written to path.tmp and synced, the current version is kept
+// as path.bak, and the temp file is renamed into place.
+func writeFileAtomic(path string, data []byte) error {
+	if err := writeTemp(path, data); err != nil {
+		return err
+	}
+	if err := replaceWithTemp(path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// writeTemp writes and syncs path.tmp
+func writeTemp(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// replaceWithTemp keeps the current version of path as path.bak and renames
+// path.tmp into place
+func replaceWithTemp(path string) error {
+	tmp := path + ".tmp"
+	if _, err := os.Stat(path); err == nil {
+		bak := path + ".bak"
+		os.Remove(bak)
+		// A hard link keeps the old version without copying it
+		if err := os.Link(path, bak); err != nil {
+			if err := copyFile(path, bak); err != nil {
+				os.Remove(tmp)
+				return fmt.Errorf("backing up %s: %v", filepath.Base(path), err)
+			}
+		}
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir makes a rename durable. Windows cannot sync directories and
+// persists renames on its own.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Recover repairs the data directory after a crash. A batch whose manifest
+// was written is completed; other leftover .tmp files are writes that never
+// completed and are discarded. A CSV file that no longer parses is set aside
+// and restored from its .bak copy.
+func (s *csvStore) Recover() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if data, err := os.ReadFile(s.path(COMMIT_MANIFEST)); err == nil {
+		names := strings.Fields(string(data))
+		log.Printf("Recovery: completing interrupted batch write of %s", strings.Join(names, ", "))
+		if err := s.finishCommit(names); err != nil {
+			return err
+		}
+	}
+	os.Remove(s.path(COMMIT_MANIFEST) + ".tmp")
+
+	temps, err := filepath.Glob(s.path("*.csv.tmp"))
+	if err != nil {
+		return err
+	}
+	for _, tmp := range temps {
+		log.Printf("Recovery: discarding incomplete write %s", filepath.Base(tmp))
+		if err := os.Remove(tmp); err != nil {
+			return err
+		}
+	}
+
+	files, err := filepath.Glob(s.path("*.csv"))
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		checkErr := checkCSVFile(path)
+		if checkErr == nil {
+			continue
+		}
+
+		bak := path + ".bak"
+		if err := checkCSVFile(bak); err != nil {
+			log.Printf("Recovery: %s is damaged (%v) and has no usable backup", filepath.Base(path), checkErr)
+			continue
+		}
+
+		damaged := fmt.Sprintf("%s.damaged-%s", path, time.Now().Format("20060102-150405"))
+		if err := os.Rename(path, damaged); err != nil {
+			return err
+		}
+		if err := copyFile(bak, path+".tmp"); err != nil {
+			return err
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+		if err := syncDir(s.dir); err != nil {
+			return err
+		}
+		log.Printf("Recovery: %s was damaged (%v); restored from backup, damaged copy kept as %s",
+			filepath.Base(path), checkErr, filepath.Base(damaged))
+	}
+	return nil
+}
+
+// checkCSVFile reports whether a file is a complete CSV document with a
+// header row. A write cut short usually leaves a truncated last row, which
+// fails the field count check.
+func checkCSVFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return errors.New("file is empty")
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore keeps the same columns as the CSV files in a single embedded
+// database. Values are stored as text so amounts round-trip exactly.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serialising here avoids SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	s := &sqliteStore{db: db}
+	tables := map[string][]string{
+		"accounts":     accountHeader,
+		"transactions": transactionColumns,
+		"records":      recordHeader,
+	}
+	for name, columns := range tables {
+		if err := s.ensureTable(name, columns); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("sqlite: %s: %v", name, err)
+		}
+	}
+	return s, nil
+}
+
+// ensureTable creates the table, or adds any columns introduced since the
+// database was created
+func (s *sqliteStore) ensureTable(name string, columns []string) error {
+	defs := []string{"Seq INTEGER NOT NULL"}
+	for _, c := range columns {
+		defs = append(defs, quoteIdent(c)+" TEXT NOT NULL DEFAULT ''")
+	}
+	if _, err := s.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteIdent(name), strings.Join(defs, ", "))); err != nil {
+		return err
+	}
+
+	current, err := s.tableColumns(name)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, c := range current {
+		existing[c] = true
+	}
+
+	for _, c := range columns {
+		if !existing[c] {
+			if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s TEXT NOT NULL DEFAULT ''", quoteIdent(name), quoteIdent(c))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tableColumns lists the data columns of a table, or nothing if the table
+// does not exist
+func (s *sqliteStore) tableColumns(name string) ([]string, error) {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdent(name)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var (
+			cid     int
+			colName string
+			colType string
+			notNull int
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		if colName != "Seq" {
+			columns = append(columns, colName)
+		}
+	}
+	return columns, rows.Err()
+}
+
+func (s *sqliteStore) ReadAccounts() ([]Account, error) {
+	rows, err := s.selectRows("accounts", accountHeader, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cols := columnIndex(accountHeader)
+	var accounts []Account
+	for _, record := range rows {
+		accounts = append(accounts, parseAccountRow(cols, record))
+	}
+	return accounts, nil
+}
+
+func (s *sqliteStore) WriteAccounts(accounts []Account) error {
+	return s.replaceRows("accounts", accountHeader, accountRows(accounts), "", nil)
+}
+
+func (s *sqliteStore) TransactionYears() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT Year FROM transactions ORDER BY Year`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var years []string
+	for rows.Next() {
+		var year string
+		if err := rows.Scan(&year); err != nil {
+			return nil, err
+		}
+		years = append(years, year)
+	}
+	return years, rows.Err()
+}
+
+func (s *sqliteStore) ReadTransactions(year string) ([]Transaction, error) {
+	rows, err := s.selectRows("transactions", transactionHeader, "Year = ?", []interface{}{year})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTransactionRows(columnIndex(transactionHeader), rows), nil
+}
+
+func (s *sqliteStore) WriteTransactions(year string, transactions []Transaction) error {
+	return s.replaceRows("transactions", transactionColumns, yearRows(year, transactions), "Year = ?", []interface{}{year})
+}
+
+var transactionColumns = append([]string{"Year"}, transactionHeader...)
+
+func yearRows(year string, transactions []Transaction) [][]string {
+	rows := transactionListRows(transactions)
+	for i, row := range rows {
+		rows[i] = append([]string{year}, row...)
+	}
+	return rows
+}
+
+func (s *sqliteStore) ReadRecords() ([]Record, error) {
+	rows, err := s.selectRows("records", recordHeader, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cols := columnIndex(recordHeader)
+	var result []Record
+	for _, record := range rows {
+		result = append(result, parseRecordRow(cols, record))
+	}
+	return result, nil
+}
+
+func (s *sqliteStore) WriteRecords(records []Record) error {
+	rows := make([][]string, 0, len(records))
+	for _, r := range records {
+		rows = append(rows, recordRow(r))
+	}
+	return s.replaceRows("records", recordHeader, rows, "", nil)
+}
+
+func (s *sqliteStore) ReadTable(name string) ([][]string, map[string]int, error) {
+	columns, err := s.tableColumns(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(columns) == 0 {
+		return nil, map[string]int{}, nil
+	}
+
+	rows, err := s.selectRows(name, columns, "", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rows, columnIndex(columns), nil
+}
+
+func (s *sqliteStore) WriteTable(name string, header []string, rows [][]string) error {
+	if err := s.ensureTable(name, header); err != nil {
+		return err
+	}
+	return s.replaceRows(name, header, rows, "", nil)
+}
+
+// Commit applies the whole batch in one database transaction
+func (s *sqliteStore) Commit(b Batch) error {
+	for name, table := range b.Tables {
+		if err := s.ensureTable(name, table.Header); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if b.Accounts != nil {
+		if err := replaceRowsTx(tx, "accounts", accountHeader, accountRows(b.Accounts), "", nil); err != nil {
+			return err
+		}
+	}
+	for year, transactions := range b.Transactions {
+		if err := replaceRowsTx(tx, "transactions", transactionColumns, yearRows(year, transactions), "Year = ?", []interface{}{year}); err != nil {
+			return err
+		}
+	}
+	for name, table := range b.Tables {
+		if err := replaceRowsTx(tx, name, table.Header, table.Rows, "", nil); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// selectRows returns the given columns of a table in insertion order
+func (s *sqliteStore) selectRows(table string, columns []string, where string, args []interface{}) ([][]string, error) {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quoteIdent(c)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), quoteIdent(table))
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY Seq"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result [][]string
+	for rows.Next() {
+		values := make([]string, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		result = append(result, values)
+	}
+	return result, rows.Err()
+}
+
+// replaceRows swaps the matching rows of a table for new ones in a single
+// transaction
+func (s *sqliteStore) replaceRows(table string, columns []string, rows [][]string, where string, args []interface{}) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRowsTx(tx, table, columns, rows, where, args); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRowsTx(tx *sql.Tx, table string, columns []string, rows [][]string, where string, args []interface{}) error {
+	del := "DELETE FROM " + quoteIdent(table)
+	if where != "" {
+		del += " WHERE " + where
+	}
+	if _, err := tx.Exec(del, args...); err != nil {
+		return err
+	}
+
+	quoted := []string{"Seq"}
+	marks := []string{"?"}
+	for _, c := range columns {
+		quoted = append(quoted, quoteIdent(c))
+		marks = append(marks, "?")
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(table), strings.Join(quoted, ", "), strings.Join(marks, ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, row := range rows {
+		values := []interface{}{i}
+		for _, v := range row {
+			values = append(values, v)
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// storeTransactions covers every column and both kinds of multi-leg rows:
+// a split, and a converted leg with its own received amount
+var storeTransactions = []Transaction{
+	{ID: "a1", TranDate: "01-10-2026", TranTime: "09:00", From: "HDFC", To: "Food", Description: "Lunch, with \"quotes\"", Amount: 45050, Tags: []string{"food", "work"}},
+	{
+		ID: "a2", TranDate: "02-10-2026", TranTime: "10:30", From: "HDFC", Description: "Supermarket",
+		Amount: 150000, Splits: []Split{{To: "Food", Amount: 100000}, {To: "Home", Amount: 40000}, {To: "Wise", Amount: 10000, ToAmount: 120}},
+	},
+	{ID: "a3", TranDate: "03-10-2026", TranTime: "11:00", From: "HDFC", To: "Wise", Description: "Transfer", Amount: 832500, ToAmount: 10000},
+	{ID: "a4", TranDate: "04-10-2026", TranTime: "12:00", From: "HDFC", To: "Zerodha", Description: "Buy", Amount: 250000, Security: "INFY", Units: 12500, Price: 2000000, FITID: "F-1"},
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"csv": func(t *testing.T) Store { return newCSVStore(t.TempDir()) },
+		"sqlite": func(t *testing.T) Store {
+			s, err := newSQLiteStore(filepath.Join(t.TempDir(), "arthik.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+	accounts := []Account{
+		{Name: "HDFC", Type: "ASSET", Amount: 1234567, IINW: "Yes"},
+		{Name: "Food", Type: "EXPENSE", IINW: "No", Budget: 500000, Parent: "Living"},
+		{Name: "Wise", Type: "ASSET", IINW: "Yes", Currency: "USD", ClosedDate: "30-09-2026"},
+		{Name: "Card", Type: "LIABILITY", Amount: -2500, IINW: "Yes", DueDate: "15"},
+	}
+	records := []Record{{Date: "30-09-2026", NetWorth: 1000000, Assets: 1200000, Liabilities: -200000, Expenses: 50000}}
+	budgets := Table{Header: []string{"Month", "Account", "Amount"}, Rows: [][]string{{"10-2026", "Food", "5000.00"}}}
+
+	for kind, open := range stores {
+		t.Run(kind, func(t *testing.T) {
+			s := open(t)
+
+			if err := s.WriteAccounts(accounts); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.WriteTransactions("2026", storeTransactions); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.WriteRecords(records); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Commit(Batch{
+				Transactions: map[string][]Transaction{"2025": storeTransactions[:1]},
+				Tables:       map[string]Table{"budgets": budgets},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := s.ReadAccounts(); err != nil || !reflect.DeepEqual(got, accounts) {
+				t.Errorf("accounts = %+v, %v, want %+v", got, err, accounts)
+			}
+			if years, err := s.TransactionYears(); err != nil || !reflect.DeepEqual(years, []string{"2025", "2026"}) {
+				t.Errorf("years = %v, %v", years, err)
+			}
+			if got, err := s.ReadTransactions("2026"); err != nil || !reflect.DeepEqual(got, storeTransactions) {
+				t.Errorf("transactions = %+v, %v, want %+v", got, err, storeTransactions)
+			}
+			if got, err := s.ReadTransactions("2025"); err != nil || !reflect.DeepEqual(got, storeTransactions[:1]) {
+				t.Errorf("committed transactions = %+v, %v", got, err)
+			}
+			if got, err := s.ReadTransactions("2024"); err != nil || len(got) != 0 {
+				t.Errorf("missing year = %+v, %v, want none", got, err)
+			}
+			if got, err := s.ReadRecords(); err != nil || !reflect.DeepEqual(got, records) {
+				t.Errorf("records = %+v, %v, want %+v", got, err, records)
+			}
+			rows, cols, err := s.ReadTable("budgets")
+			if err != nil || !reflect.DeepEqual(rows, budgets.Rows) || !reflect.DeepEqual(tableHeader(cols), budgets.Header) {
+				t.Errorf("budgets = %v %v, %v, want %v", cols, rows, err, budgets)
+			}
+			if rows, cols, err := s.ReadTable("rules"); err != nil || len(rows) != 0 || len(cols) != 0 {
+				t.Errorf("missing table = %v %v, %v, want empty", cols, rows, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// readDir returns the files in dir with their contents
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(data)
+	}
+	return files
+}
+
+func fileNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// seedStore writes the first version of the files a test batch replaces
+func seedStore(t *testing.T) *csvStore {
+	t.Helper()
+	s := newCSVStore(t.TempDir())
+	if err := s.WriteAccounts([]Account{{Name: "Old", Type: "ASSET"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteTransactions("2026", []Transaction{{ID: "1", TranDate: "01-10-2026", From: "Old", To: "Food", Amount: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteTable("budgets", []string{"Account", "Amount"}, [][]string{{"Old", "10.00"}}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func renameBatch() Batch {
+	return Batch{
+		Accounts:     []Account{{Name: "New", Type: "ASSET"}},
+		Transactions: map[string][]Transaction{"2026": {{ID: "1", TranDate: "01-10-2026", From: "New", To: "Food", Amount: 100}}},
+		Tables:       map[string]Table{"budgets": {Header: []string{"Account", "Amount"}, Rows: [][]string{{"New", "10.00"}}}},
+	}
+}
+
+// checkRenamed checks that every file renameBatch replaces holds the same
+// name, so none of the batch was applied or all of it
+func checkRenamed(t *testing.T, s *csvStore, want string) {
+	t.Helper()
+	accounts, err := s.ReadAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transactions, err := s.ReadTransactions("2026")
+	if err != nil {
+		t.Fatal(err)
+	}
+	budgets, _, err := s.ReadTable("budgets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{accounts[0].Name, transactions[0].From, budgets[0][0]}
+	if !reflect.DeepEqual(got, []string{want, want, want}) {
+		t.Errorf("account, transaction and budget name %v, want all %s", got, want)
+	}
+}
+
+func TestCSVStoreCommit(t *testing.T) {
+	s := seedStore(t)
+	if err := s.Commit(renameBatch()); err != nil {
+		t.Fatal(err)
+	}
+	checkRenamed(t, s, "New")
+
+	// No .tmp files or manifest are left, and each file keeps the old
+	// version as .bak
+	want := []string{
+		"account.csv", "account.csv.bak",
+		"budgets.csv", "budgets.csv.bak",
+		"tran_2026.csv", "tran_2026.csv.bak",
+	}
+	files := readDir(t, s.dir)
+	if got := fileNames(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("files after Commit = %v, want %v", got, want)
+	}
+	if !strings.Contains(files["account.csv.bak"], "Old") {
+		t.Errorf("account.csv.bak = %q, want the old accounts", files["account.csv.bak"])
+	}
+}
+
+func TestCSVStoreRecover(t *testing.T) {
+	names := []string{"account.csv", "budgets.csv", "tran_2026.csv"}
+
+	tests := []struct {
+		name     string
+		manifest bool     // the crash came after commit.pending was written
+		renamed  []string // files already moved into place
+		want     string   // the name every file holds after Recover
+	}{
+		{name: "before the manifest", want: "Old"},
+		{name: "after the manifest", manifest: true, want: "New"},
+		{name: "part way through the renames", manifest: true, renamed: names[:2], want: "New"},
+		{name: "after every rename", manifest: true, renamed: names, want: "New"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := seedStore(t)
+
+			// Stage the batch as Commit would, stopping at the crash
+			b := renameBatch()
+			staged := map[string][]byte{}
+			var err error
+			if staged["account.csv"], err = encodeCSV(accountHeader, accountRows(b.Accounts)); err != nil {
+				t.Fatal(err)
+			}
+			if staged["tran_2026.csv"], err = encodeCSV(transactionHeader, transactionListRows(b.Transactions["2026"])); err != nil {
+				t.Fatal(err)
+			}
+			if staged["budgets.csv"], err = encodeCSV(b.Tables["budgets"].Header, b.Tables["budgets"].Rows); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range names {
+				if err := writeTemp(s.path(name), staged[name]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.manifest {
+				if err := os.WriteFile(s.path(COMMIT_MANIFEST), []byte(strings.Join(names, "\n")+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				// The manifest itself was being written
+				if err := os.WriteFile(s.path(COMMIT_MANIFEST)+".tmp", []byte(names[0]), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range tt.renamed {
+				if err := replaceWithTemp(s.path(name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := s.Recover(); err != nil {
+				t.Fatal(err)
+			}
+			checkRenamed(t, s, tt.want)
+
+			for _, name := range fileNames(readDir(t, s.dir)) {
+				if strings.HasSuffix(name, ".tmp") || strings.HasPrefix(name, COMMIT_MANIFEST) {
+					t.Errorf("%s left after Recover", name)
+				}
+			}
+
+			// Recovering again changes nothing
+			before := readDir(t, s.dir)
+			if err := s.Recover(); err != nil {
+				t.Fatal(err)
+			}
+			if after := readDir(t, s.dir); !reflect.DeepEqual(after, before) {
+				t.Errorf("second Recover changed the files from %v to %v", fileNames(before), fileNames(after))
+			}
+		})
+	}
+}
+
+func TestCSVStoreRecoverDamaged(t *testing.T) {
+	s := newCSVStore(t.TempDir())
+	accounts := []Account{{Name: "HDFC", Type: "ASSET"}, {Name: "Food", Type: "EXPENSE"}}
+	if err := s.WriteAccounts(accounts[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteAccounts(accounts); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write cut short leaves a truncated last row
+	data, err := os.ReadFile(s.path("account.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(s.path("account.csv"))
+	if err := os.WriteFile(s.path("account.csv"), data[:len(data)-20], 0600); err != nil {
+		t.Fatal(err)
+	}
+	// A damaged file without a usable backup is left alone
+	if err := os.WriteFile(s.path("rules.csv"), []byte("Name,Pattern\n\"open"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.ReadAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, accounts[:1]) {
+		t.Errorf("accounts after Recover = %+v, want the backup %+v", got, accounts[:1])
+	}
+	damaged, err := filepath.Glob(s.path("account.csv.damaged-*"))
+	if err != nil || len(damaged) != 1 {
+		t.Errorf("damaged copies = %v, %v, want one", damaged, err)
+	}
+	if err := checkCSVFile(s.path("rules.csv")); err == nil {
+		t.Error("rules.csv was replaced")
+	}
+}
+
+func TestTransactionRowsRoundTrip(t *testing.T) {
+	split := Transaction{
+		ID: "s1", TranDate: "02-10-2026", TranTime: "10:30", From: "HDFC", Description: "Supermarket", Amount: 150000,
+		Tags:   []string{"home"},
+		Splits: []Split{{To: "Food", Amount: 100000}, {To: "Home", Amount: 40000}, {To: "Wise", Amount: 10000, ToAmount: 120}},
+	}
+	plain := Transaction{ID: "p1", TranDate: "03-10-2026", TranTime: "11:00", From: "HDFC", To: "Wise", Amount: 832500, ToAmount: 10000}
+
+	tests := []struct {
+		name string
+		in   []Transaction
+		rows int // rows written
+	}{
+		{name: "plain", in: []Transaction{plain}, rows: 1},
+		{name: "split", in: []Transaction{split}, rows: 3},
+		{name: "split between others", in: []Transaction{plain, split, {ID: "p2", TranDate: "04-10-2026", From: "HDFC", To: "Food", Amount: 1}}, rows: 5},
+	}
+
+	for _, tt := range tests {
+		rows := transactionListRows(tt.in)
+		if len(rows) != tt.rows {
+			t.Errorf("%s: %d rows, want %d", tt.name, len(rows), tt.rows)
+		}
+		// Every leg row repeats the shared columns
+		for _, row := range rows {
+			if len(row) != len(transactionHeader) {
+				t.Errorf("%s: row %v has %d columns, want %d", tt.name, row, len(row), len(transactionHeader))
+			}
+		}
+		got := parseTransactionRows(columnIndex(transactionHeader), rows)
+		if !reflect.DeepEqual(got, tt.in) {
+			t.Errorf("%s: read back as %+v, want %+v", tt.name, got, tt.in)
+		}
+	}
+}
+
+func TestParseTransactionRowsJoin(t *testing.T) {
+	cols := columnIndex(transactionHeader)
+	row := func(id, date, from, to, desc, amount string) []string {
+		return []string{id, date, "10:00", from, to, desc, amount, "", "", "", "", "", ""}
+	}
+
+	tests := []struct {
+		name string
+		rows [][]string
+		want []Transaction
+	}{
+		{
+			name: "legs sharing an ID",
+			rows: [][]string{row("1", "01-10-2026", "HDFC", "Food", "Shop", "10.00"), row("1", "01-10-2026", "HDFC", "Home", "Shop", "5.00")},
+			want: []Transaction{{ID: "1", TranDate: "01-10-2026", TranTime: "10:00", From: "HDFC", Description: "Shop", Amount: 1500,
+				Splits: []Split{{To: "Food", Amount: 1000}, {To: "Home", Amount: 500}}}},
+		},
+		{
+			// A reused ID on an unrelated row is not a leg
+			name: "same ID, other date",
+			rows: [][]string{row("1", "01-10-2026", "HDFC", "Food", "Shop", "10.00"), row("1", "02-10-2026", "HDFC", "Home", "Shop", "5.00")},
+			want: []Transaction{
+				{ID: "1", TranDate: "01-10-2026", TranTime: "10:00", From: "HDFC", To: "Food", Description: "Shop", Amount: 1000},
+				{ID: "1", TranDate: "02-10-2026", TranTime: "10:00", From: "HDFC", To: "Home", Description: "Shop", Amount: 500},
+			},
+		},
+		{
+			name: "same ID, other source",
+			rows: [][]string{row("1", "01-10-2026", "HDFC", "Food", "Shop", "10.00"), row("1", "01-10-2026", "SBI", "Home", "Shop", "5.00")},
+			want: []Transaction{
+				{ID: "1", TranDate: "01-10-2026", TranTime: "10:00", From: "HDFC", To: "Food", Description: "Shop", Amount: 1000},
+				{ID: "1", TranDate: "01-10-2026", TranTime: "10:00", From: "SBI", To: "Home", Description: "Shop", Amount: 500},
+			},
+		},
+		{
+			name: "rows without an ID",
+			rows: [][]string{row("", "01-10-2026", "HDFC", "Food", "Shop", "10.00"), row("", "01-10-2026", "HDFC", "Home", "Shop", "5.00")},
+			want: []Transaction{
+				{TranDate: "01-10-2026", TranTime: "10:00", From: "HDFC", To: "Food", Description: "Shop", Amount: 1000},
+				{TranDate: "01-10-2026", TranTime: "10:00", From: "HDFC", To: "Home", Description: "Shop", Amount: 500},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := parseTransactionRows(cols, tt.rows)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Suggestions for the add-transaction form are mined from past
+// transactions, with no outside service. Past transactions with the same
+// description and accounts form one suggestion. Each occurrence counts
+// for less the older it is, and for more when it was entered near the
+// current time of day, so a frequent, recent habit ranks first.
+
+const (
+	DEFAULT_SUGGESTIONS = 5
+	MAX_SUGGESTIONS     = 20
+	// SUGGESTION_HALF_LIFE_DAYS is the age at which an occurrence counts half
+	SUGGESTION_HALF_LIFE_DAYS = 60
+	// SUGGESTION_TIME_WINDOW is how many minutes apart a time of day still
+	// adds to the score
+	SUGGESTION_TIME_WINDOW = 180
+)
+
+// Suggestion is one way to complete a transaction
+type Suggestion struct {
+	Description string  `json:"description"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Amount      Money   `json:"amount"` // the usual amount
+	Count       int     `json:"count"`
+	LastUsed    string  `json:"lastUsed"`
+	Score       float64 `json:"score"`
+}
+
+// descriptionMatch scores how well a partial description matches: 2 when
+// the description starts with it, 1 when one of its words does, 0 otherwise
+func descriptionMatch(description, query string) float64 {
+	if query == "" {
+		return 1
+	}
+	description = strings.ToLower(html.UnescapeString(description))
+	if strings.HasPrefix(description, query) {
+		return 2
+	}
+	for _, word := range strings.Fields(description) {
+		if strings.HasPrefix(word, query) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// minutesOfDay turns HH:MM into minutes since midnight
+func minutesOfDay(t string) int {
+	parsed, err := time.Parse("15:04", t)
+	if err != nil {
+		return -1
+	}
+	return parsed.Hour()*60 + parsed.Minute()
+}
+
+// suggestTransactions ranks past transactions matching a partial
+// description and, if given, the From account. transactions must be
+// sorted newest first.
+func suggestTransactions(transactions []Transaction, query, from, now string, today int, limit int) []Suggestion {
+	query = strings.ToLower(strings.TrimSpace(query))
+	nowMinutes := minutesOfDay(now)
+
+	type group struct {
+		suggestion Suggestion
+		amounts    map[Money]float64
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+
+	for _, t := range transactions {
+		// Splits and trades are not completed from a single suggestion
+		if len(t.Splits) > 0 || t.Security != "" {
+			continue
+		}
+		if from != "" && t.From != from {
+			continue
+		}
+		match := descriptionMatch(t.Description, query)
+		if match == 0 {
+			continue
+		}
+
+		age := today - dayNumber(t.TranDate)
+		if age < 0 {
+			age = 0
+		}
+		weight := math.Pow(0.5, float64(age)/SUGGESTION_HALF_LIFE_DAYS)
+		if m := minutesOfDay(t.TranTime); nowMinutes >= 0 && m >= 0 {
+			gap := math.Abs(float64(m - nowMinutes))
+			gap = math.Min(gap, 24*60-gap)
+			weight *= 1 + math.Max(0, 1-gap/SUGGESTION_TIME_WINDOW)
+		}
+		weight *= match
+
+		key := strings.ToLower(html.UnescapeString(t.Description)) + "\x00" + t.From + "\x00" + t.To
+		g, ok := byKey[key]
+		if !ok {
+			// The newest occurrence gives the spelling and last date
+			g = &group{
+				suggestion: Suggestion{Description: t.Description, From: t.From, To: t.To, LastUsed: t.TranDate},
+				amounts:    make(map[Money]float64),
+			}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.suggestion.Count++
+		g.suggestion.Score += weight
+		g.amounts[t.Amount] += weight
+	}
+
+	suggestions := make([]Suggestion, 0, len(groups))
+	for _, g := range groups {
+		// The usual amount is the one with the most weight
+		best := -1.0
+		for amount, w := range g.amounts {
+			if w > best || (w == best && amount > g.suggestion.Amount) {
+				best = w
+				g.suggestion.Amount = amount
+			}
+		}
+		g.suggestion.Score = math.Round(g.suggestion.Score*1000) / 1000
+		suggestions = append(suggestions, g.suggestion)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Score > suggestions[j].Score
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// handleSuggestions answers GET /api/suggestions?q=swi&from=ICICIBank with
+// ranked completions. time (HH:MM) defaults to now and limit to 5.
+func handleSuggestions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	query := q.Get("q")
+	from := sanitizeInput(q.Get("from"))
+	if strings.TrimSpace(query) == "" && from == "" {
+		respondError(w, "q or from required", http.StatusBadRequest)
+		return
+	}
+	if len(query) > 100 {
+		respondError(w, "q too long (max 100 characters)", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	at := q.Get("time")
+	if at == "" {
+		at = now.Format("15:04")
+	} else if !isValidTime(at) {
+		respondError(w, "invalid time format (use HH:MM)", http.StatusBadRequest)
+		return
+	}
+
+	limit := DEFAULT_SUGGESTIONS
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > MAX_SUGGESTIONS {
+			respondError(w, "limit must be between 1 and 20", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	transactions, err := readAllTransactions()
+	if err != nil {
+		respondError(w, "Failed to load transactions", http.StatusInternalServerError)
+		return
+	}
+
+	today := dayNumber(now.Format("02-01-2006"))
+	json.NewEncoder(w).Encode(suggestTransactions(transactions, query, from, at, today, limit))
+}
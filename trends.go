@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The trend report pivots transactions into months: spending per expense
+// account, and money into and out of each asset account. Like the other
+// reports it is computed from the transaction files, in the base currency
+// at the rate on each transaction's date.
+
+const (
+	DEFAULT_TREND_MONTHS = 12
+	MAX_TREND_MONTHS     = 36
+)
+
+// TrendRow is one account's monthly amounts with their statistics
+type TrendRow struct {
+	Account string     `json:"account"`
+	Path    string     `json:"path"`
+	Amounts []Money    `json:"amounts"` // one per month
+	Total   Money      `json:"total"`
+	Average Money      `json:"average"`
+	Min     Money      `json:"min"`
+	Max     Money      `json:"max"`
+	Changes []*float64 `json:"changes"` // percent change from the month before; null when that month is 0
+}
+
+// CashFlowRow is the money into and out of one account per month
+type CashFlowRow struct {
+	Account  string  `json:"account"`
+	Path     string  `json:"path"`
+	Inflows  []Money `json:"inflows"`
+	Outflows []Money `json:"outflows"`
+	Net      []Money `json:"net"`
+}
+
+// trendRow works out the statistics of a row of monthly amounts
+func trendRow(account, path string, amounts []Money) TrendRow {
+	row := TrendRow{Account: account, Path: path, Amounts: amounts, Changes: make([]*float64, len(amounts))}
+	for i, amount := range amounts {
+		row.Total += amount
+		if i == 0 || amount < row.Min {
+			row.Min = amount
+		}
+		if i == 0 || amount > row.Max {
+			row.Max = amount
+		}
+		if i > 0 && amounts[i-1] != 0 {
+			change := math.Round(float64(amount-amounts[i-1])/math.Abs(float64(amounts[i-1]))*1000) / 10
+			row.Changes[i] = &change
+		}
+	}
+	if len(amounts) > 0 {
+		row.Average = Money(math.Round(float64(row.Total) / float64(len(amounts))))
+	}
+	return row
+}
+
+// trendMonths lists count months (MM-YYYY) ending with last
+func trendMonths(last time.Time, count int) []string {
+	months := make([]string, count)
+	first := time.Date(last.Year(), last.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1-count, 0)
+	for i := range months {
+		months[i] = first.AddDate(0, i, 0).Format("01-2006")
+	}
+	return months
+}
+
+// handleTrends reports monthly spending and cash flow:
+// GET /api/reports/trends?months=12&month=10-2026
+func handleTrends(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		respondError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	count := DEFAULT_TREND_MONTHS
+	if m := q.Get("months"); m != "" {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 1 || n > MAX_TREND_MONTHS {
+			respondError(w, "months must be between 1 and 36", http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+	last := time.Now()
+	if m := q.Get("month"); m != "" {
+		parsed, err := time.Parse("01-2006", m)
+		if err != nil {
+			respondError(w, "invalid month format (use MM-YYYY)", http.StatusBadRequest)
+			return
+		}
+		last = parsed
+	}
+	months := trendMonths(last, count)
+
+	transactions, accounts, currencies, conv, err := loadReportData()
+	if err != nil {
+		respondError(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	index := make(map[string]int, len(months))
+	for i, month := range months {
+		index[month] = i
+	}
+	types := make(map[string]string, len(accounts))
+	for _, acc := range accounts {
+		types[acc.Name] = acc.Type
+	}
+
+	// Money into and out of every account per month. The totals leave out
+	// transfers between two asset accounts, which are neither in nor out.
+	inflows := make(map[string][]Money)
+	outflows := make(map[string][]Money)
+	add := func(flows map[string][]Money, name string, i int, amount Money) {
+		if flows[name] == nil {
+			flows[name] = make([]Money, len(months))
+		}
+		flows[name][i] += amount
+	}
+	totalIn := make([]Money, len(months))
+	totalOut := make([]Money, len(months))
+	for _, t := range transactions {
+		if len(t.TranDate) < 10 {
+			continue
+		}
+		i, ok := index[t.TranDate[3:10]]
+		if !ok {
+			continue
+		}
+		out := conv.ToBase(t.Amount, currencies[t.From], t.TranDate)
+		add(outflows, t.From, i, out)
+		for _, leg := range t.Legs() {
+			received := conv.ToBase(leg.Received(), currencies[leg.To], t.TranDate)
+			add(inflows, leg.To, i, received)
+
+			fromAsset, toAsset := types[t.From] == "ASSET", types[leg.To] == "ASSET"
+			if toAsset && !fromAsset {
+				totalIn[i] += received
+			}
+			if fromAsset && !toAsset {
+				totalOut[i] += conv.ToBase(leg.Amount, currencies[t.From], t.TranDate)
+			}
+		}
+	}
+
+	// Spending per expense account, including its sub-accounts
+	spending := make([]map[string]Money, len(months))
+	for i := range months {
+		own := make(map[string]Money)
+		for _, acc := range accounts {
+			if acc.Type == "EXPENSE" {
+				if in := inflows[acc.Name]; in != nil {
+					own[acc.Name] += in[i]
+				}
+				if out := outflows[acc.Name]; out != nil {
+					own[acc.Name] -= out[i]
+				}
+			}
+		}
+		spending[i] = rollupAccounts(accounts, own)
+	}
+
+	expenses := []TrendRow{}
+	totals := make([]Money, len(months))
+	for _, acc := range accounts {
+		if acc.Type != "EXPENSE" {
+			continue
+		}
+		amounts := make([]Money, len(months))
+		active := false
+		for i := range months {
+			amounts[i] = spending[i][acc.Name]
+			active = active || amounts[i] != 0
+		}
+		if acc.Parent == "" {
+			for i := range months {
+				totals[i] += amounts[i]
+			}
+		}
+		if active {
+			expenses = append(expenses, trendRow(acc.Name, accountPath(accounts, acc.Name), amounts))
+		}
+	}
+
+	cashFlow := []CashFlowRow{}
+	for _, acc := range accounts {
+		if acc.Type != "ASSET" || (inflows[acc.Name] == nil && outflows[acc.Name] == nil) {
+			continue
+		}
+		row := CashFlowRow{Account: acc.Name, Path: accountPath(accounts, acc.Name), Inflows: inflows[acc.Name], Outflows: outflows[acc.Name], Net: make([]Money, len(months))}
+		if row.Inflows == nil {
+			row.Inflows = make([]Money, len(months))
+		}
+		if row.Outflows == nil {
+			row.Outflows = make([]Money, len(months))
+		}
+		for i := range months {
+			row.Net[i] = row.Inflows[i] - row.Outflows[i]
+		}
+		cashFlow = append(cashFlow, row)
+	}
+	net := make([]Money, len(months))
+	for i := range months {
+		net[i] = totalIn[i] - totalOut[i]
+	}
+	sort.SliceStable(expenses, func(i, j int) bool { return expenses[i].Path < expenses[j].Path })
+	sort.SliceStable(cashFlow, func(i, j int) bool { return cashFlow[i].Path < cashFlow[j].Path })
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"months":       months,
+		"baseCurrency": conv.Base,
+		"expenses": map[string]interface{}{
+			"accounts": expenses,
+			"total":    trendRow("", "", totals),
+		},
+		"cashFlow": map[string]interface{}{
+			"accounts": cashFlow,
+			"inflows":  totalIn,
+			"outflows": totalOut,
+			"net":      net,
+		},
+	})
+}